@@ -0,0 +1,250 @@
+package alerts
+
+import (
+	"backend/config"
+	"backend/events"
+	"backend/jobs"
+	"backend/mailer"
+	"backend/models"
+	"backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Start sends the product alerts when a product is restocked, by a restock or a cancelled order releasing
+// its stock, or its price drops, and every hour for the changes that publish no event such as scheduled
+// sales starting
+func Start() {
+	notify := func(ctx context.Context, payload interface{}) error {
+		return run(ctx)
+	}
+	events.Subscribe(events.ProductRestocked, notify)
+	events.Subscribe(events.ProductPriceDropped, notify)
+	jobs.Every("product alerts", time.Hour, run)
+}
+
+func run(ctx context.Context) error {
+	sent, err := Notify(config.DB.WithContext(ctx), time.Now())
+	if sent > 0 {
+		log.Printf("Sent %d product alert emails", sent)
+	}
+	return err
+}
+
+// BatchSize is how many customers are handled at a time, ALERT_BATCH_SIZE (default 100)
+func BatchSize() int {
+	size, err := strconv.Atoi(os.Getenv("ALERT_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		return 100
+	}
+	return size
+}
+
+// EmailInterval is the least time between two alert emails to a customer, ALERT_EMAIL_INTERVAL_HOURS
+// (default 24). Alerts that become due in between wait for the next email.
+func EmailInterval() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("ALERT_EMAIL_INTERVAL_HOURS"))
+	if err != nil || hours < 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// dueJoins and dueSQL select the alerts to send: published products or variations that can be ordered again
+// for back in stock alerts, and that are cheaper than the price last told about for price drop alerts
+const dueJoins = `JOIN products ON products.id = product_alerts.product_id AND products.deleted_at IS NULL
+	JOIN products AS listed ON listed.id = COALESCE(products.parent_id, products.id) AND listed.deleted_at IS NULL`
+
+func dueSQL() string {
+	return utils.PublishedSQL("listed") + ` AND (
+		(product_alerts.kind = 'back_in_stock' AND product_alerts.notified_at IS NULL AND ` + utils.AvailableStockSQL("products") + ` > 0) OR
+		(product_alerts.kind = 'price_drop' AND ` + utils.EffectivePriceSQL("products") + ` < product_alerts.price))`
+}
+
+// Notify emails the customers with due alerts, in batches of BatchSize customers, one email per customer
+// listing all their due alerts. Customers emailed less than EmailInterval ago are left for a later run.
+func Notify(db *gorm.DB, now time.Time) (int, error) {
+	sent := 0
+	var errs []error
+	lastUserID := uint(0)
+	for {
+		var userIDs []uint
+		if err := db.Model(&models.ProductAlert{}).
+			Distinct("product_alerts.user_id").
+			Joins(dueJoins).
+			Joins("JOIN users ON users.id = product_alerts.user_id AND users.deleted_at IS NULL").
+			Where(dueSQL()).
+			Where("product_alerts.user_id > ?", lastUserID).
+			Where("NOT EXISTS (SELECT 1 FROM product_alerts AS sent WHERE sent.user_id = product_alerts.user_id AND sent.notified_at > ?)", now.Add(-EmailInterval())).
+			Order("product_alerts.user_id").
+			Limit(BatchSize()).
+			Pluck("product_alerts.user_id", &userIDs).Error; err != nil {
+			return sent, err
+		}
+		if len(userIDs) == 0 {
+			return sent, errors.Join(errs...)
+		}
+
+		for _, userID := range userIDs {
+			emailed, err := notifyUser(db, userID, now)
+			if err != nil {
+				errs = append(errs, err)
+			} else if emailed {
+				sent++
+			}
+		}
+		lastUserID = userIDs[len(userIDs)-1]
+	}
+}
+
+// dueAlert is an alert to send with the product as it is now
+type dueAlert struct {
+	ID           uint
+	Kind         string
+	ProductID    uint
+	Price        *float64
+	Name         string
+	Size         string
+	Slug         *string
+	Currency     string
+	CurrentPrice float64
+}
+
+// notifyUser sends one customer the email about their due alerts. The alerts are marked notified while
+// locked, so concurrent runs do not send it twice, and the email is sent once that is committed so a slow
+// mail server holds no locks. When sending fails the marks are undone for a later run.
+func notifyUser(db *gorm.DB, userID uint, now time.Time) (bool, error) {
+	var user models.User
+	if err := db.Select("id", "name", "email").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+
+	var due []dueAlert
+	notified := map[uint]*time.Time{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var locked []models.ProductAlert
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).Find(&locked).Error; err != nil {
+			return err
+		}
+		for _, alert := range locked {
+			if alert.NotifiedAt != nil && alert.NotifiedAt.After(now.Add(-EmailInterval())) {
+				return nil
+			}
+			notified[alert.ID] = alert.NotifiedAt
+		}
+
+		if err := tx.Model(&models.ProductAlert{}).
+			Select(`product_alerts.id, product_alerts.kind, product_alerts.product_id, product_alerts.price,
+				listed.name, products.size, listed.slug, products.currency,
+				`+utils.EffectivePriceSQL("products")+` AS current_price`).
+			Joins(dueJoins).
+			Where("product_alerts.user_id = ?", userID).
+			Where(dueSQL()).
+			Order("product_alerts.id").
+			Scan(&due).Error; err != nil {
+			return err
+		}
+
+		for _, alert := range due {
+			updates := map[string]interface{}{"notified_at": now}
+			if alert.Kind == "price_drop" {
+				updates["price"] = alert.CurrentPrice
+			}
+			if err := tx.Model(&models.ProductAlert{}).Where("id = ?", alert.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || len(due) == 0 {
+		return false, err
+	}
+
+	subject, body := alertEmail(&user, due)
+	if err := mailer.Send(user.Email, subject, body); err != nil {
+		// Alerts changed since are left alone, the next run picks them up anyway
+		for _, alert := range due {
+			if undoErr := db.Model(&models.ProductAlert{}).
+				Where("id = ? AND notified_at = ?", alert.ID, now).
+				Updates(map[string]interface{}{"notified_at": notified[alert.ID], "price": alert.Price}).Error; undoErr != nil {
+				log.Printf("failed to undo the notification of product alert %d: %v", alert.ID, undoErr)
+			}
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func alertEmail(user *models.User, due []dueAlert) (string, string) {
+	storefront := config.StorefrontURL()
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nThere is news about products you follow:\n", user.Name)
+	for _, alert := range due {
+		name := alert.Name
+		if alert.Size != "" {
+			name += " (" + alert.Size + ")"
+		}
+		if alert.Kind == "back_in_stock" {
+			fmt.Fprintf(&body, "\n%s is back in stock at %.2f %s.\n", name, alert.CurrentPrice, alert.Currency)
+		} else {
+			fmt.Fprintf(&body, "\n%s dropped from %.2f to %.2f %s.\n", name, *alert.Price, alert.CurrentPrice, alert.Currency)
+		}
+		if alert.Slug != nil {
+			fmt.Fprintf(&body, "%s/products/%s\n", storefront, *alert.Slug)
+		}
+		fmt.Fprintf(&body, "Stop this alert: %s/alerts/unsubscribe?token=%s\n", storefront, AlertToken(alert.ID))
+	}
+	fmt.Fprintf(&body, "\nStop all product alerts: %s/alerts/unsubscribe?token=%s\n", storefront, UserToken(user.ID))
+
+	subject := "Products you follow have news"
+	if len(due) == 1 {
+		if due[0].Kind == "back_in_stock" {
+			subject = due[0].Name + " is back in stock"
+		} else {
+			subject = "Price drop on " + due[0].Name
+		}
+	}
+	return subject, body.String()
+}
+
+const (
+	alertToken = "product-alert"
+	userToken  = "product-alerts"
+)
+
+// AlertToken signs the ID of an alert for the unsubscribe link of that alert
+func AlertToken(id uint) string {
+	return utils.SignToken(alertToken, strconv.FormatUint(uint64(id), 10))
+}
+
+// UserToken signs the ID of a customer for the link unsubscribing from all their alerts
+func UserToken(userID uint) string {
+	return utils.SignToken(userToken, strconv.FormatUint(uint64(userID), 10))
+}
+
+// Unsubscribe removes the alert of an alert token, or every alert of the customer of a user token. It
+// returns false when the token is invalid.
+func Unsubscribe(db *gorm.DB, token string) (bool, error) {
+	for purpose, column := range map[string]string{alertToken: "id", userToken: "user_id"} {
+		value, ok := utils.VerifyToken(purpose, token)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return false, nil
+		}
+		return true, db.Where(column+" = ?", id).Delete(&models.ProductAlert{}).Error
+	}
+	return false, nil
+}
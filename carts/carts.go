@@ -0,0 +1,153 @@
+package carts
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CookieName and Header carry the UUID of an anonymous cart
+const (
+	CookieName = "cart_id"
+	Header     = "X-Cart-ID"
+)
+
+// Start removes the expired anonymous carts every night and sends the abandoned cart emails every
+// quarter of an hour
+func Start() {
+	jobs.Daily("expired carts", 4*time.Hour, func(ctx context.Context) error {
+		removed, err := RemoveExpired(config.DB.WithContext(ctx), time.Now())
+		log.Printf("Removed %d expired anonymous carts", removed)
+		return err
+	})
+	jobs.Every("abandoned carts", 15*time.Minute, func(ctx context.Context) error {
+		sent, err := SendRecoveryEmails(config.DB.WithContext(ctx), time.Now())
+		if sent > 0 {
+			log.Printf("Sent %d abandoned cart emails", sent)
+		}
+		return err
+	})
+}
+
+// GuestTTL is how long an anonymous cart is kept after its last change, GUEST_CART_TTL_DAYS (default 30)
+func GuestTTL() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("GUEST_CART_TTL_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ForUser returns the cart of a customer, creating it the first time
+func ForUser(db *gorm.DB, userID uint) (*models.ShoppingCart, error) {
+	created := &models.ShoppingCart{UUID: uuid.New(), UserID: &userID}
+	if err := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).Create(created).Error; err != nil {
+		return nil, err
+	}
+	var cart models.ShoppingCart
+	return &cart, db.Where("user_id = ?", userID).First(&cart).Error
+}
+
+// Guest returns the anonymous cart with the UUID unless it expired
+func Guest(db *gorm.DB, id uuid.UUID) (*models.ShoppingCart, error) {
+	var cart models.ShoppingCart
+	err := db.Where("uuid = ? AND user_id IS NULL AND expires_at > ?", id, time.Now()).First(&cart).Error
+	return &cart, err
+}
+
+// NewGuest creates an anonymous cart
+func NewGuest(db *gorm.DB) (*models.ShoppingCart, error) {
+	expires := time.Now().Add(GuestTTL())
+	cart := &models.ShoppingCart{UUID: uuid.New(), ExpiresAt: &expires}
+	return cart, db.Create(cart).Error
+}
+
+// Touch records a change of a cart, which pushes back the expiry of an anonymous cart and the abandoned
+// cart emails of a customer's cart
+func Touch(db *gorm.DB, cart *models.ShoppingCart) error {
+	now := time.Now()
+	updates := map[string]interface{}{"updated_at": now}
+	if cart.UserID == nil {
+		expires := now.Add(GuestTTL())
+		cart.ExpiresAt = &expires
+		updates["expires_at"] = expires
+	}
+	cart.UpdatedAt = now
+	return db.Model(cart).Updates(updates).Error
+}
+
+// Merge moves the items of an anonymous cart into the cart of a customer who signed in and removes the
+// anonymous cart. A product in both carts keeps the larger quantity, as it was most likely added twice
+// rather than wanted twice.
+func Merge(db *gorm.DB, guestID uuid.UUID, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		guest, err := Guest(tx.Clauses(clause.Locking{Strength: "UPDATE"}), guestID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+		cart, err := ForUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		var items []models.CartItem
+		if err := tx.Where("cart_id IN ?", []uuid.UUID{guest.UUID, cart.UUID}).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+		kept := map[uint]*models.CartItem{}
+		for i := range items {
+			if items[i].CartID == cart.UUID && kept[items[i].ProductID] == nil {
+				kept[items[i].ProductID] = &items[i]
+			}
+		}
+		for _, item := range items {
+			if item.CartID != guest.UUID {
+				continue
+			}
+			if existing := kept[item.ProductID]; existing != nil {
+				if item.Quantity > existing.Quantity {
+					existing.Quantity = item.Quantity
+					if err := tx.Model(existing).Update("quantity", item.Quantity).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := tx.Model(&item).Update("cart_id", cart.UUID).Error; err != nil {
+				return err
+			}
+			item.CartID = cart.UUID
+			kept[item.ProductID] = &item
+		}
+
+		if err := tx.Where("cart_id = ?", guest.UUID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(guest).Error; err != nil {
+			return err
+		}
+		return Touch(tx, cart)
+	})
+}
+
+// RemoveExpired deletes the anonymous carts that expired before the given time with their items
+func RemoveExpired(db *gorm.DB, at time.Time) (int64, error) {
+	expired := db.Model(&models.ShoppingCart{}).Select("uuid").Where("user_id IS NULL AND expires_at <= ?", at)
+	if err := db.Where("cart_id IN (?)", expired).Delete(&models.CartItem{}).Error; err != nil {
+		return 0, err
+	}
+	result := db.Where("user_id IS NULL AND expires_at <= ?", at).Delete(&models.ShoppingCart{})
+	return result.RowsAffected, result.Error
+}
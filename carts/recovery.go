@@ -0,0 +1,321 @@
+package carts
+
+import (
+	"backend/config"
+	"backend/mailer"
+	"backend/models"
+	"backend/promotions"
+	"backend/utils"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RecoverySteps are the times after the last change of a customer's cart at which the abandoned cart
+// emails go out: ABANDONED_CART_HOURS (default 1), then 24 and 72 hours
+func RecoverySteps() []time.Duration {
+	hours, err := strconv.ParseFloat(os.Getenv("ABANDONED_CART_HOURS"), 64)
+	if err != nil || hours <= 0 {
+		hours = 1
+	}
+	first := time.Duration(hours * float64(time.Hour))
+	steps := []time.Duration{first}
+	for _, step := range []time.Duration{24 * time.Hour, 72 * time.Hour} {
+		if step > first {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// recoveryWindow is how long after the last email a checkout still counts as recovering the cart
+const recoveryWindow = 7 * 24 * time.Hour
+
+const recoveryToken = "cart-recovery"
+
+// RecoveryToken signs the ID of a recovery for the link of its emails
+func RecoveryToken(id uint) string {
+	return utils.SignToken(recoveryToken, strconv.FormatUint(uint64(id), 10))
+}
+
+// RecoveryID returns the recovery a link token was made for
+func RecoveryID(token string) (uint, bool) {
+	value, ok := utils.VerifyToken(recoveryToken, token)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return uint(id), err == nil
+}
+
+// DetectAbandoned starts a recovery for the carts of customers that have items and were left untouched
+// since the first step, and whose customer did not check out since. A cart abandoned again closes its
+// previous recovery. Carts older than the last step are left alone as their emails would be over.
+func DetectAbandoned(db *gorm.DB, now time.Time) (int, error) {
+	steps := RecoverySteps()
+	var abandoned []struct {
+		UUID      uuid.UUID
+		UserID    uint
+		UpdatedAt time.Time
+	}
+	if err := db.Table("shopping_carts").
+		Select("shopping_carts.uuid, shopping_carts.user_id, shopping_carts.updated_at").
+		Joins("JOIN users ON users.id = shopping_carts.user_id AND users.deleted_at IS NULL AND users.email <> ''").
+		Where("shopping_carts.updated_at <= ? AND shopping_carts.updated_at > ?", now.Add(-steps[0]), now.Add(-steps[len(steps)-1])).
+		Where("EXISTS (SELECT 1 FROM cart_items WHERE cart_items.cart_id = shopping_carts.uuid)").
+		Where("NOT EXISTS (SELECT 1 FROM orders WHERE orders.user_id = shopping_carts.user_id AND orders.created_at >= shopping_carts.updated_at)").
+		Where("NOT EXISTS (SELECT 1 FROM cart_recoveries WHERE cart_recoveries.cart_id = shopping_carts.uuid AND cart_recoveries.abandoned_at >= shopping_carts.updated_at)").
+		Scan(&abandoned).Error; err != nil {
+		return 0, err
+	}
+
+	for i, cart := range abandoned {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.CartRecovery{}).
+				Where("cart_id = ? AND recovered_at IS NULL AND closed_at IS NULL", cart.UUID).
+				Update("closed_at", now).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.CartRecovery{CartID: cart.UUID, UserID: cart.UserID, AbandonedAt: cart.UpdatedAt}).Error
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(abandoned), nil
+}
+
+// SendRecoveryEmails starts the recoveries of newly abandoned carts and sends the emails that are due.
+// Emails stop when the cart changes or the customer checks out. A step missed while the job was not
+// running is skipped rather than sent late together with the next one.
+func SendRecoveryEmails(db *gorm.DB, now time.Time) (int, error) {
+	if _, err := DetectAbandoned(db, now); err != nil {
+		return 0, err
+	}
+
+	steps := RecoverySteps()
+	if err := db.Model(&models.CartRecovery{}).
+		Where("recovered_at IS NULL AND closed_at IS NULL AND abandoned_at < ?", now.Add(-steps[len(steps)-1]-recoveryWindow)).
+		Update("closed_at", now).Error; err != nil {
+		return 0, err
+	}
+
+	var due []models.CartRecovery
+	if err := db.Joins("JOIN shopping_carts ON shopping_carts.uuid = cart_recoveries.cart_id AND shopping_carts.updated_at = cart_recoveries.abandoned_at").
+		Where("cart_recoveries.recovered_at IS NULL AND cart_recoveries.closed_at IS NULL AND cart_recoveries.emails_sent < ?", len(steps)).
+		Preload("User").
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var errs []error
+	for i := range due {
+		recovery := &due[i]
+		step := recovery.EmailsSent
+		for step < len(steps) && !now.Before(recovery.AbandonedAt.Add(steps[step])) {
+			step++
+		}
+		if step == recovery.EmailsSent {
+			continue
+		}
+		if err := sendRecoveryEmail(db, recovery, step, step == len(steps), now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+// sendRecoveryEmail sends the email of a step, counting from 1, with the coupon code of the latest
+// running abandoned cart campaign in the last one. The step is recorded while the recovery is locked, so
+// concurrent runs do not send it twice, and the email is sent once that is committed so a slow mail server
+// holds no locks. When sending fails the step is undone for a later run, the code is kept for it.
+func sendRecoveryEmail(db *gorm.DB, recovery *models.CartRecovery, step int, last bool, now time.Time) error {
+	var cart models.ShoppingCart
+	if err := db.Preload("CartItems.Product").Where("uuid = ?", recovery.CartID).First(&cart).Error; err != nil {
+		return err
+	}
+
+	var locked models.CartRecovery
+	var code *models.CampaignCode
+	claimed := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Another run may have sent it already
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("CampaignCode").First(&locked, recovery.ID).Error; err != nil {
+			return err
+		}
+		if locked.EmailsSent >= step || locked.RecoveredAt != nil || locked.ClosedAt != nil {
+			return nil
+		}
+
+		code = locked.CampaignCode
+		if last && code == nil {
+			var err error
+			if code, err = issueRecoveryCode(tx, recovery.UserID, now); err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{"emails_sent": step, "last_email_at": now}
+		if code != nil {
+			updates["campaign_code_id"] = code.ID
+		}
+		if err := tx.Model(&models.CartRecovery{}).Where("id = ?", locked.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		claimed = true
+		return nil
+	})
+	if err != nil || !claimed {
+		return err
+	}
+
+	subject, body := recoveryEmail(recovery, &cart, code, step, last)
+	if err := mailer.Send(recovery.User.Email, subject, body); err != nil {
+		if undoErr := db.Model(&models.CartRecovery{}).
+			Where("id = ? AND emails_sent = ?", locked.ID, step).
+			Updates(map[string]interface{}{"emails_sent": locked.EmailsSent, "last_email_at": locked.LastEmailAt}).Error; undoErr != nil {
+			log.Printf("failed to undo recovery email %d of cart recovery %d: %v", step, locked.ID, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// issueRecoveryCode issues a code of the latest running abandoned cart campaign, nil without one
+func issueRecoveryCode(tx *gorm.DB, userID uint, now time.Time) (*models.CampaignCode, error) {
+	var campaign models.Campaign
+	err := tx.Where("abandoned_cart = true AND is_active = true AND start_date <= ? AND (expiration_date IS NULL OR expiration_date > ?)", now, now).
+		Order("id DESC").
+		First(&campaign).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return promotions.IssueCampaignCode(tx, campaign.ID, userID)
+}
+
+func recoveryEmail(recovery *models.CartRecovery, cart *models.ShoppingCart, code *models.CampaignCode, step int, last bool) (string, string) {
+	subjects := []string{"You left something in your cart", "Your cart is waiting for you", "Last chance to complete your order"}
+	subject := subjects[min(step, len(subjects)-1)-1]
+	if last {
+		subject = subjects[len(subjects)-1]
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nYou still have these items in your cart:\n\n", recovery.User.Name)
+	for _, item := range cart.CartItems {
+		fmt.Fprintf(&body, "  %d x %s\n", item.Quantity, item.Product.Name)
+	}
+	if code != nil {
+		fmt.Fprintf(&body, "\nUse the code %s at checkout for a discount on your order.\n", code.Code)
+	}
+	fmt.Fprintf(&body, "\nPick up where you left off: %s/cart/recover?token=%s\n", config.StorefrontURL(), RecoveryToken(recovery.ID))
+	return subject, body.String()
+}
+
+// Restore brings back the cart of a recovery email into the given cart, e.g. the anonymous cart of
+// another device, and records that the link was followed. Products already in the cart keep the larger
+// quantity, as when carts are merged on sign in.
+func Restore(db *gorm.DB, recoveryID uint, cart *models.ShoppingCart) (*models.CartRecovery, error) {
+	var recovery models.CartRecovery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("CampaignCode").First(&recovery, recoveryID).Error; err != nil {
+			return err
+		}
+		if recovery.ClickedAt == nil {
+			now := time.Now()
+			recovery.ClickedAt = &now
+			if err := tx.Model(&recovery).Update("clicked_at", now).Error; err != nil {
+				return err
+			}
+		}
+		if recovery.CartID == cart.UUID {
+			return nil
+		}
+
+		var items []models.CartItem
+		if err := tx.Where("cart_id = ?", recovery.CartID).Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for i := range items {
+			items[i].ID, items[i].CartID = 0, cart.UUID
+		}
+		if err := tx.Omit("Cart", "Product").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "cart_id"}, {Name: "product_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("GREATEST(cart_items.quantity, excluded.quantity)")}),
+		}).Create(&items).Error; err != nil {
+			return err
+		}
+		return Touch(tx, cart)
+	})
+	return &recovery, err
+}
+
+// RecordCheckout marks the open recoveries of the customer of an order recovered by it, which stops their
+// emails. It runs in the order transaction.
+func RecordCheckout(tx *gorm.DB, order *models.Order) error {
+	if order.UserID == nil {
+		return nil
+	}
+	return tx.Model(&models.CartRecovery{}).
+		Where("user_id = ? AND recovered_at IS NULL AND closed_at IS NULL", *order.UserID).
+		Updates(map[string]interface{}{"recovered_at": time.Now(), "order_id": order.ID}).Error
+}
+
+// RecoveryStats summarises the carts abandoned since a time. Carts are abandoned when left without
+// checking out, and recovered when the customer checked out after that. The abandonment rate is the
+// share of carts that ended without an order among those and the orders of customers.
+type RecoveryStats struct {
+	Abandoned        int64
+	EmailsSent       int64
+	Clicked          int64
+	Recovered        int64
+	RecoveredRevenue float64
+	CustomerOrders   int64
+	AbandonmentRate  float64
+	RecoveryRate     float64
+}
+
+// GetRecoveryStats reports the abandoned carts and their recovery since a time
+func GetRecoveryStats(db *gorm.DB, since time.Time) (*RecoveryStats, error) {
+	stats := &RecoveryStats{}
+	if err := db.Raw(`SELECT COUNT(*) AS abandoned,
+			COALESCE(SUM(emails_sent), 0) AS emails_sent,
+			COUNT(*) FILTER (WHERE clicked_at IS NOT NULL) AS clicked,
+			COUNT(*) FILTER (WHERE recovered_at IS NOT NULL) AS recovered,
+			COALESCE(SUM(orders.total_price) FILTER (WHERE orders.order_status <> 'cancelled'), 0) AS recovered_revenue
+		FROM cart_recoveries
+		LEFT JOIN orders ON orders.id = cart_recoveries.order_id
+		WHERE cart_recoveries.abandoned_at >= ?`, since).
+		Scan(stats).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Order{}).Where("user_id IS NOT NULL AND created_at >= ?", since).Count(&stats.CustomerOrders).Error; err != nil {
+		return nil, err
+	}
+
+	lost := stats.Abandoned - stats.Recovered
+	if lost+stats.CustomerOrders > 0 {
+		stats.AbandonmentRate = float64(lost) / float64(lost+stats.CustomerOrders)
+	}
+	if stats.Abandoned > 0 {
+		stats.RecoveryRate = float64(stats.Recovered) / float64(stats.Abandoned)
+	}
+	return stats, nil
+}
@@ -0,0 +1,89 @@
+package carts
+
+import (
+	"backend/models"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverySteps(t *testing.T) {
+	tests := []struct {
+		hours string
+		want  []time.Duration
+	}{
+		{"", []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour}},
+		{"2", []time.Duration{2 * time.Hour, 24 * time.Hour, 72 * time.Hour}},
+		{"0.5", []time.Duration{30 * time.Minute, 24 * time.Hour, 72 * time.Hour}},
+		// A first step at or after a later one replaces it
+		{"24", []time.Duration{24 * time.Hour, 72 * time.Hour}},
+		{"48", []time.Duration{48 * time.Hour, 72 * time.Hour}},
+		{"100", []time.Duration{100 * time.Hour}},
+		// Invalid values fall back to the default
+		{"0", []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour}},
+		{"-3", []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour}},
+		{"soon", []time.Duration{time.Hour, 24 * time.Hour, 72 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Setenv("ABANDONED_CART_HOURS", tt.hours)
+		if got := RecoverySteps(); !slices.Equal(got, tt.want) {
+			t.Errorf("ABANDONED_CART_HOURS=%q: RecoverySteps() = %v, want %v", tt.hours, got, tt.want)
+		}
+	}
+}
+
+func TestRecoveryID(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  uint
+		ok    bool
+	}{
+		{"valid", RecoveryToken(7), 7, true},
+		{"garbage", "not-a-token", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := RecoveryID(tt.token)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: RecoveryID = %d, %v, want %d, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRecoveryEmail(t *testing.T) {
+	t.Setenv("STOREFRONT_URL", "https://shop.example/")
+	recovery := &models.CartRecovery{ID: 7, User: models.User{Name: "Sam"}}
+	cart := &models.ShoppingCart{CartItems: []models.CartItem{{Quantity: 2, Product: models.Product{Name: "Gift Box"}}}}
+	code := &models.CampaignCode{Code: "COMEBACK10"}
+
+	tests := []struct {
+		name    string
+		step    int
+		last    bool
+		code    *models.CampaignCode
+		subject string
+	}{
+		{"first", 1, false, nil, "You left something in your cart"},
+		{"second", 2, false, nil, "Your cart is waiting for you"},
+		{"last", 3, true, code, "Last chance to complete your order"},
+		// With fewer steps the last email still reads as the last one
+		{"last of two", 2, true, code, "Last chance to complete your order"},
+		{"last without a campaign", 3, true, nil, "Last chance to complete your order"},
+	}
+	for _, tt := range tests {
+		subject, body := recoveryEmail(recovery, cart, tt.code, tt.step, tt.last)
+		if subject != tt.subject {
+			t.Errorf("%s: subject %q, want %q", tt.name, subject, tt.subject)
+		}
+		for _, want := range []string{"Hi Sam,", "2 x Gift Box", "https://shop.example/cart/recover?token=" + RecoveryToken(7)} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: body does not contain %q:\n%s", tt.name, want, body)
+			}
+		}
+		if hasCode := strings.Contains(body, "COMEBACK10"); hasCode != (tt.code != nil) {
+			t.Errorf("%s: body mentions the code %v, want %v", tt.name, hasCode, tt.code != nil)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package carts
+
+import (
+	"backend/models"
+	"backend/promotions"
+	"backend/utils"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Line warnings
+const (
+	WarningUnpublished          = "unpublished"           // The product is no longer sold, the line is left out of the totals
+	WarningOutOfStock           = "out_of_stock"          // None left to order
+	WarningInsufficientQuantity = "insufficient_quantity" // Fewer left than the line's quantity
+	WarningPriceChanged         = "price_changed"         // The price differs from when the item was added
+)
+
+// Line is an item of a cart priced now
+type Line struct {
+	ItemID     uint
+	ProductID  uint
+	Quantity   int
+	UnitPrice  float64
+	PriceAtAdd *float64
+	LineTotal  float64 // Before discounts
+	Discount   float64 // Taken off by automatic promotions
+	Available  int     // Quantity that can still be ordered
+	Warnings   []string
+}
+
+// Summary is a cart with its totals worked out on the server. Coupons, gift cards and loyalty points are
+// only applied at checkout.
+type Summary struct {
+	*models.ShoppingCart
+	Lines             []Line
+	Subtotal          float64
+	Discounts         []promotions.Discount
+	DiscountAmount    float64
+	EstimatedShipping float64 // After shipping discounts
+	EstimatedTax      float64 // Tax included in the total at TAX_RATE
+	Total             float64
+	HasWarnings       bool
+}
+
+// TaxRate is the percentage of tax included in prices, from TAX_RATE (default 0)
+func TaxRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("TAX_RATE"), 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// Available returns the quantity of each product that can be ordered right now
+func Available(db *gorm.DB, productIDs []uint) (map[uint]int, error) {
+	var rows []struct {
+		ID        uint
+		Available int
+	}
+	if err := db.Table("products").
+		Select("products.id, "+utils.AvailableStockSQL("products")+" AS available").
+		Where("products.id IN ?", productIDs).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	available := map[uint]int{}
+	for _, row := range rows {
+		available[row.ID] = row.Available
+	}
+	return available, nil
+}
+
+// Summarize prices the items of a cart, with its items and their products loaded, at the given time.
+// Shipping is estimated with the option of the payment method, or the cheapest option without one.
+func Summarize(db *gorm.DB, cart *models.ShoppingCart, paymentMethod string, at time.Time) (*Summary, error) {
+	summary := &Summary{ShoppingCart: cart, Lines: make([]Line, len(cart.CartItems))}
+	if len(cart.CartItems) == 0 {
+		return summary, nil
+	}
+
+	ids := make([]uint, 0, len(cart.CartItems))
+	for _, item := range cart.CartItems {
+		ids = append(ids, item.ProductID)
+	}
+	var products []models.Product
+	if err := db.Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := map[uint]models.Product{}
+	var parentIDs []uint
+	for _, product := range products {
+		byID[product.ID] = product
+		if product.ParentID != nil {
+			parentIDs = append(parentIDs, *product.ParentID)
+		}
+	}
+	var parents []models.Product
+	if len(parentIDs) > 0 {
+		if err := db.Where("id IN ?", parentIDs).Find(&parents).Error; err != nil {
+			return nil, err
+		}
+	}
+	parentByID := map[uint]models.Product{}
+	for _, parent := range parents {
+		parentByID[parent.ID] = parent
+	}
+	available, err := Available(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only lines that can be bought go through the promotions
+	var items []models.OrderItem
+	var priced []int
+	for i, item := range cart.CartItems {
+		line := Line{ItemID: item.ID, ProductID: item.ProductID, Quantity: item.Quantity, PriceAtAdd: item.PriceAtAdd, Available: available[item.ProductID]}
+
+		product, found := byID[item.ProductID]
+		// Variations follow the publishing schedule of their parent
+		listed := product
+		if found && product.ParentID != nil {
+			listed, found = parentByID[*product.ParentID]
+		}
+		if !found || !listed.IsPublished(at) {
+			line.Warnings = append(line.Warnings, WarningUnpublished)
+			summary.Lines[i] = line
+			continue
+		}
+
+		line.UnitPrice = product.EffectivePrice(at)
+		line.LineTotal = round(line.UnitPrice * float64(line.Quantity))
+		switch {
+		case line.Available <= 0:
+			line.Warnings = append(line.Warnings, WarningOutOfStock)
+		case line.Available < line.Quantity:
+			line.Warnings = append(line.Warnings, WarningInsufficientQuantity)
+		}
+		if line.PriceAtAdd != nil && round(*line.PriceAtAdd) != round(line.UnitPrice) {
+			line.Warnings = append(line.Warnings, WarningPriceChanged)
+		}
+		summary.Lines[i] = line
+
+		items = append(items, models.OrderItem{ProductID: item.ProductID, Quantity: item.Quantity, PriceAtPurchase: line.UnitPrice})
+		priced = append(priced, i)
+	}
+	for _, line := range summary.Lines {
+		summary.HasWarnings = summary.HasWarnings || len(line.Warnings) > 0
+	}
+	if len(items) == 0 {
+		return summary, nil
+	}
+
+	shipping, err := estimateShipping(db, paymentMethod)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := promotions.OrderLines(db, items)
+	if err != nil {
+		return nil, err
+	}
+	result, err := promotions.Evaluate(db, promotions.Cart{UserID: cart.UserID, Lines: lines, Shipping: shipping}, nil, at)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, i := range priced {
+		summary.Lines[i].Discount = result.LineDiscount(n)
+	}
+	// Discounts point at the lines of the summary rather than at the priced ones
+	for d := range result.Discounts {
+		for s := range result.Discounts[d].Lines {
+			result.Discounts[d].Lines[s].Line = priced[result.Discounts[d].Lines[s].Line]
+		}
+	}
+
+	summary.Subtotal = result.Subtotal
+	summary.Discounts = result.Discounts
+	summary.DiscountAmount = result.DiscountAmount
+	summary.EstimatedShipping = result.Shipping
+	summary.Total = result.Total
+	if rate := TaxRate(); rate > 0 {
+		summary.EstimatedTax = round(summary.Total * rate / (100 + rate))
+	}
+	return summary, nil
+}
+
+// estimateShipping is the cost of the shipping option of a payment method, or of the cheapest option
+func estimateShipping(db *gorm.DB, paymentMethod string) (float64, error) {
+	// Checkout uses the first option of the payment method
+	query := db.Model(&models.ShippingOptions{})
+	if paymentMethod != "" {
+		query = query.Where("payment_method = ?", paymentMethod).Order("id")
+	} else {
+		query = query.Order("shipping_cost")
+	}
+	var option models.ShippingOptions
+	err := query.First(&option).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return option.ShippingCost, err
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
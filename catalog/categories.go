@@ -0,0 +1,50 @@
+package catalog
+
+import (
+	"backend/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// CategoryPathSeparator joins category names from the root down, e.g. "Women > Dresses"
+const CategoryPathSeparator = " > "
+
+// CategoryPaths returns the full name path of every category by ID
+func CategoryPaths(db *gorm.DB) (map[uint]string, error) {
+	var categories []*models.Category
+	if err := db.Select("id, name, parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	paths := make(map[uint]string, len(categories))
+	for _, category := range categories {
+		var names []string
+		seen := map[uint]bool{}
+		for current := category; current != nil && !seen[current.ID]; {
+			seen[current.ID] = true
+			names = append([]string{current.Name.String}, names...)
+			if current.ParentID == nil {
+				break
+			}
+			current = byID[*current.ParentID]
+		}
+		paths[category.ID] = strings.Join(names, CategoryPathSeparator)
+	}
+
+	return paths, nil
+}
+
+// normalizeCategoryPath makes path lookups insensitive to case and spacing around separators
+func normalizeCategoryPath(path string) string {
+	parts := strings.Split(path, ">")
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, ">")
+}
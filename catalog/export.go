@@ -0,0 +1,108 @@
+package catalog
+
+import (
+	"backend/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportRows returns the catalogue as spreadsheet rows in the import format, each parent followed by its variations
+func ExportRows(db *gorm.DB) ([][]string, error) {
+	paths, err := CategoryPaths(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var products []*models.Product
+	if err := db.Preload("Images").Order("id").Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	var inventories []*models.Inventory
+	if err := db.Find(&inventories).Error; err != nil {
+		return nil, err
+	}
+	stock := make(map[uint]int, len(inventories))
+	for _, inventory := range inventories {
+		stock[inventory.ProductID] = inventory.StockLevel
+	}
+
+	bySKU := map[uint]string{}
+	variations := map[uint][]*models.Product{}
+	var parents []*models.Product
+	for _, product := range products {
+		bySKU[product.ID] = product.SKU
+		if product.ParentID != nil {
+			variations[*product.ParentID] = append(variations[*product.ParentID], product)
+		} else {
+			parents = append(parents, product)
+		}
+	}
+
+	rows := [][]string{Columns}
+	for _, parent := range parents {
+		rows = append(rows, exportRow(parent, "", paths, stock))
+		for _, variation := range variations[parent.ID] {
+			rows = append(rows, exportRow(variation, bySKU[parent.ID], paths, stock))
+		}
+	}
+
+	return rows, nil
+}
+
+func exportRow(product *models.Product, parentSKU string, paths map[uint]string, stock map[uint]int) []string {
+	var urls []string
+	for _, image := range product.Images {
+		urls = append(urls, image.URL)
+	}
+
+	row := map[string]string{
+		"sku":        product.SKU,
+		"parent_sku": parentSKU,
+		"price":      strconv.FormatFloat(product.Price, 'f', 2, 64),
+		"size":       product.Size,
+		"featured":   strconv.FormatBool(product.Featured),
+		"image_urls": strings.Join(urls, ImageURLSeparator),
+	}
+	if product.Barcode != nil {
+		row["barcode"] = *product.Barcode
+	}
+	if product.SalePrice != nil {
+		row["sale_price"] = strconv.FormatFloat(*product.SalePrice, 'f', 2, 64)
+	}
+
+	// Variations inherit these from their parent, so they are left blank
+	if parentSKU == "" {
+		row["name"] = product.Name
+		row["description"] = product.Description
+		if product.Brand != nil {
+			row["brand"] = *product.Brand
+		}
+		row["currency"] = product.Currency
+		row["category_path"] = paths[product.CategoryID]
+		row["stock"] = strconv.Itoa(stock[product.ID])
+		if product.Status != nil {
+			row["status"] = *product.Status
+		}
+		row["sale_starts_at"] = formatTime(product.SaleStartsAt)
+		row["sale_ends_at"] = formatTime(product.SaleEndsAt)
+		row["publish_at"] = formatTime(product.PublishAt)
+		row["unpublish_at"] = formatTime(product.UnpublishAt)
+	}
+
+	values := make([]string, len(Columns))
+	for i, column := range Columns {
+		values[i] = row[column]
+	}
+	return values
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(timeLayouts[0])
+}
@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/events"
+	"backend/jobs"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed is a generated document such as a sitemap or a merchant feed
+type Feed struct {
+	Body        []byte
+	ContentType string
+	ModifiedAt  time.Time // Last time the content changed, sent as Last-Modified
+}
+
+// Names of the generated merchant feeds, sitemap pages are named sitemap-1.xml, sitemap-2.xml, ...
+const (
+	SitemapFeed           = "sitemap.xml"
+	GoogleMerchantXMLFeed = "google-merchant.xml"
+	GoogleMerchantCSVFeed = "google-merchant.csv"
+)
+
+var ErrFeedNotFound = errors.New("feed not found")
+
+var feeds = struct {
+	sync.RWMutex
+	documents map[string]*Feed
+}{documents: map[string]*Feed{}}
+
+// generateMu keeps regenerations from running concurrently
+var generateMu sync.Mutex
+
+// StartFeeds regenerates the sitemap and merchant feeds every FEED_REFRESH_INTERVAL (default 1h) and when products are published or unpublished
+func StartFeeds() {
+	interval, err := time.ParseDuration(os.Getenv("FEED_REFRESH_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+
+	jobs.Every("feeds", interval, GenerateFeeds)
+	jobs.Enqueue("feeds", GenerateFeeds)
+
+	regenerate := func(ctx context.Context, payload interface{}) error {
+		return GenerateFeeds(ctx)
+	}
+	events.Subscribe(events.ProductPublished, regenerate)
+	events.Subscribe(events.ProductUnpublished, regenerate)
+}
+
+// GetFeed returns a generated document by name, generating the feeds first if that has not happened yet
+func GetFeed(ctx context.Context, name string) (*Feed, error) {
+	feeds.RLock()
+	empty := len(feeds.documents) == 0
+	feed := feeds.documents[name]
+	feeds.RUnlock()
+
+	if empty {
+		if err := GenerateFeeds(ctx); err != nil {
+			return nil, err
+		}
+		feeds.RLock()
+		feed = feeds.documents[name]
+		feeds.RUnlock()
+	}
+
+	if feed == nil {
+		return nil, ErrFeedNotFound
+	}
+	return feed, nil
+}
+
+// GenerateFeeds rebuilds the sitemap and merchant feeds and swaps them into the cache
+func GenerateFeeds(ctx context.Context) error {
+	generateMu.Lock()
+	defer generateMu.Unlock()
+
+	db := config.DB.WithContext(ctx)
+
+	documents, err := buildSitemaps(db)
+	if err != nil {
+		return err
+	}
+
+	items, err := merchantItems(db)
+	if err != nil {
+		return err
+	}
+	merchantXML, err := merchantXML(items)
+	if err != nil {
+		return err
+	}
+	merchantCSV, err := merchantCSV(items)
+	if err != nil {
+		return err
+	}
+	var itemsModifiedAt time.Time
+	for _, item := range items {
+		if item.UpdatedAt.After(itemsModifiedAt) {
+			itemsModifiedAt = item.UpdatedAt
+		}
+	}
+	documents[GoogleMerchantXMLFeed] = &Feed{Body: merchantXML, ContentType: "application/xml; charset=utf-8", ModifiedAt: itemsModifiedAt}
+	documents[GoogleMerchantCSVFeed] = &Feed{Body: merchantCSV, ContentType: "text/csv; charset=utf-8", ModifiedAt: itemsModifiedAt}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	feeds.Lock()
+	for name, document := range documents {
+		// Keep Last-Modified when nothing changed, and move it forward when the content
+		// changed without a newer updated_at, e.g. because a product was unpublished
+		if previous := feeds.documents[name]; previous != nil {
+			if bytes.Equal(previous.Body, document.Body) {
+				document.ModifiedAt = previous.ModifiedAt
+			} else if !document.ModifiedAt.After(previous.ModifiedAt) {
+				document.ModifiedAt = now
+			}
+		}
+		if document.ModifiedAt.IsZero() {
+			document.ModifiedAt = now
+		}
+		document.ModifiedAt = document.ModifiedAt.UTC().Truncate(time.Second)
+	}
+	feeds.documents = documents
+	feeds.Unlock()
+
+	log.Printf("Generated %d feeds", len(documents))
+	return nil
+}
+
+// absoluteURL prefixes relative links, such as images in local storage, with the public API address
+func absoluteURL(url string) string {
+	if url == "" || strings.Contains(url, "://") {
+		return url
+	}
+	return config.PublicAPIURL() + "/" + strings.TrimPrefix(url, "/")
+}
@@ -0,0 +1,688 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/imaging"
+	"backend/media"
+	"backend/models"
+	"backend/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Columns are the spreadsheet headers shared by product import and export
+var Columns = []string{
+	"sku", "parent_sku", "name", "description", "brand", "barcode", "price", "currency",
+	"category_path", "status", "featured", "size", "stock", "image_urls",
+	"sale_price", "sale_starts_at", "sale_ends_at", "publish_at", "unpublish_at",
+}
+
+// timeLayouts are the accepted formats of the date columns, exports use the first
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+// ImageURLSeparator separates multiple image URLs in the image_urls column
+const ImageURLSeparator = "|"
+
+// progressInterval is how many rows are processed between progress updates
+const progressInterval = 25
+
+// Row is a parsed spreadsheet line. Blank cells are nil and keep the existing value on update.
+type Row struct {
+	Line         int
+	SKU          string
+	ParentSKU    string
+	Name         *string
+	Description  *string
+	Brand        *string
+	Barcode      *string
+	Price        *float64
+	Currency     *string
+	CategoryPath *string
+	Status       *string
+	Featured     *bool
+	Size         *string
+	Stock        *int
+	ImageURLs    []string
+	SalePrice    *float64
+	SaleStartsAt *time.Time
+	SaleEndsAt   *time.Time
+	PublishAt    *time.Time
+	UnpublishAt  *time.Time
+}
+
+// RowError lists the problems found on one spreadsheet line
+type RowError struct {
+	Line   int
+	SKU    string
+	Errors []string
+}
+
+// ParseRows maps spreadsheet lines to rows using the header line, collecting parse errors per line
+func ParseRows(lines [][]string) ([]*Row, []RowError, error) {
+	if len(lines) == 0 {
+		return nil, nil, errors.New("file is empty")
+	}
+
+	index := map[string]int{}
+	for i, header := range lines[0] {
+		index[strings.ToLower(strings.TrimSpace(header))] = i
+	}
+	if _, ok := index["sku"]; !ok {
+		return nil, nil, errors.New("missing required column 'sku'")
+	}
+
+	var rows []*Row
+	var rowErrors []RowError
+
+	for i, line := range lines[1:] {
+		cell := func(column string) *string {
+			position, ok := index[column]
+			if !ok || position >= len(line) {
+				return nil
+			}
+			value := strings.TrimSpace(line[position])
+			if value == "" {
+				return nil
+			}
+			return &value
+		}
+
+		if isBlankLine(line) {
+			continue
+		}
+
+		row := &Row{Line: i + 2}
+		var problems []string
+
+		if sku := cell("sku"); sku != nil {
+			row.SKU = *sku
+		}
+		if parent := cell("parent_sku"); parent != nil {
+			row.ParentSKU = *parent
+		}
+		row.Name = cell("name")
+		row.Description = cell("description")
+		row.Brand = cell("brand")
+		row.Barcode = cell("barcode")
+		row.Currency = cell("currency")
+		row.CategoryPath = cell("category_path")
+		row.Status = cell("status")
+		row.Size = cell("size")
+
+		if value := cell("price"); value != nil {
+			price, err := strconv.ParseFloat(*value, 64)
+			if err != nil {
+				problems = append(problems, "price must be a number")
+			} else {
+				row.Price = &price
+			}
+		}
+		if value := cell("featured"); value != nil {
+			featured, err := strconv.ParseBool(*value)
+			if err != nil {
+				problems = append(problems, "featured must be true or false")
+			} else {
+				row.Featured = &featured
+			}
+		}
+		if value := cell("stock"); value != nil {
+			stock, err := strconv.Atoi(*value)
+			if err != nil {
+				problems = append(problems, "stock must be a whole number")
+			} else {
+				row.Stock = &stock
+			}
+		}
+		if value := cell("sale_price"); value != nil {
+			price, err := strconv.ParseFloat(*value, 64)
+			if err != nil {
+				problems = append(problems, "sale_price must be a number")
+			} else {
+				row.SalePrice = &price
+			}
+		}
+		for column, target := range map[string]**time.Time{
+			"sale_starts_at": &row.SaleStartsAt,
+			"sale_ends_at":   &row.SaleEndsAt,
+			"publish_at":     &row.PublishAt,
+			"unpublish_at":   &row.UnpublishAt,
+		} {
+			if value := cell(column); value != nil {
+				parsed, err := parseTime(*value)
+				if err != nil {
+					problems = append(problems, column+" must be a date such as 2024-12-31 or 2024-12-31T09:00:00Z")
+				} else {
+					*target = &parsed
+				}
+			}
+		}
+		if value := cell("image_urls"); value != nil {
+			for _, url := range strings.Split(*value, ImageURLSeparator) {
+				if url = strings.TrimSpace(url); url != "" {
+					row.ImageURLs = append(row.ImageURLs, url)
+				}
+			}
+		}
+
+		if len(problems) > 0 {
+			rowErrors = append(rowErrors, RowError{Line: row.Line, SKU: row.SKU, Errors: problems})
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var parsed time.Time
+		if parsed, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func isBlankLine(line []string) bool {
+	for _, value := range line {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// importer holds the lookups shared by every row of an import
+type importer struct {
+	db         *gorm.DB
+	categories map[string]uint // Normalized category path to ID
+	existing   map[string]*models.Product
+	fileSKUs   map[string]*Row
+	images     map[uint]map[string]bool // Source and stored URLs of the existing images by product ID
+}
+
+// RunImport validates and, unless the job is a dry run, upserts the rows by SKU while recording progress on the job
+func RunImport(ctx context.Context, jobID uint, rows []*Row, parseErrors []RowError) {
+	var job models.ProductImportJob
+	if err := config.DB.First(&job, jobID).Error; err != nil {
+		log.Printf("product import %d not found: %v", jobID, err)
+		return
+	}
+
+	job.Status = "running"
+	job.TotalRows = len(rows) + len(parseErrors)
+	job.ProcessedRows = len(parseErrors)
+	job.FailedCount = len(parseErrors)
+	config.DB.Save(&job)
+
+	rowErrors := append([]RowError{}, parseErrors...)
+	imported := false
+
+	err := func() error {
+		imp, err := newImporter(config.DB.WithContext(models.WithActor(ctx, job.UserID)), rows)
+		if err != nil {
+			return err
+		}
+
+		// Parents are upserted before their variations so parent_sku can refer to a product from the same file
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].ParentSKU == "" && rows[j].ParentSKU != "" })
+
+		for i, row := range rows {
+			problems := imp.validate(row)
+			if len(problems) == 0 && !job.DryRun {
+				created, err := imp.apply(ctx, row)
+				if err != nil {
+					problems = append(problems, err.Error())
+				} else if created {
+					job.CreatedCount++
+					imported = true
+				} else {
+					job.UpdatedCount++
+					imported = true
+				}
+			}
+
+			if len(problems) > 0 {
+				rowErrors = append(rowErrors, RowError{Line: row.Line, SKU: row.SKU, Errors: problems})
+				job.FailedCount++
+			}
+
+			job.ProcessedRows++
+			if (i+1)%progressInterval == 0 {
+				config.DB.Model(&job).Updates(map[string]interface{}{
+					"processed_rows": job.ProcessedRows,
+					"created_count":  job.CreatedCount,
+					"updated_count":  job.UpdatedCount,
+					"failed_count":   job.FailedCount,
+				})
+			}
+		}
+		return nil
+	}()
+
+	sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Line < rowErrors[j].Line })
+	job.RowErrors, _ = json.Marshal(rowErrors)
+	job.Status = "completed"
+	if err != nil {
+		message := err.Error()
+		job.Status = "failed"
+		job.Error = &message
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+
+	if err := config.DB.Save(&job).Error; err != nil {
+		log.Printf("failed to save product import %d: %v", jobID, err)
+	}
+
+	if imported {
+		media.QueueRenditions()
+	}
+}
+
+func newImporter(db *gorm.DB, rows []*Row) (*importer, error) {
+	paths, err := CategoryPaths(db)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		db:         db,
+		categories: make(map[string]uint, len(paths)),
+		existing:   map[string]*models.Product{},
+		fileSKUs:   map[string]*Row{},
+		images:     map[uint]map[string]bool{},
+	}
+	for id, path := range paths {
+		imp.categories[normalizeCategoryPath(path)] = id
+	}
+
+	var skus []string
+	for _, row := range rows {
+		skus = append(skus, row.SKU, row.ParentSKU)
+	}
+
+	var products []*models.Product
+	if err := db.Preload("Images").Where("sku IN ?", skus).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		imp.existing[product.SKU] = product
+		imp.images[product.ID] = map[string]bool{}
+		for _, image := range product.Images {
+			// Files exported from the catalogue carry the stored URL, imported ones the source URL
+			imp.images[product.ID][image.URL] = true
+			if image.SourceURL != nil {
+				imp.images[product.ID][*image.SourceURL] = true
+			}
+		}
+	}
+
+	return imp, nil
+}
+
+// validate checks a row against the catalogue without writing anything
+func (imp *importer) validate(row *Row) []string {
+	var problems []string
+
+	if row.SKU == "" {
+		return []string{"sku is required"}
+	}
+	if len(row.SKU) > 150 {
+		problems = append(problems, "sku must be at most 150 characters")
+	}
+	if first, ok := imp.fileSKUs[row.SKU]; ok && first != row {
+		problems = append(problems, fmt.Sprintf("sku is duplicated on line %d", first.Line))
+	} else {
+		imp.fileSKUs[row.SKU] = row
+	}
+
+	existing := imp.existing[row.SKU]
+
+	if row.ParentSKU != "" {
+		if row.ParentSKU == row.SKU {
+			problems = append(problems, "parent_sku cannot be the product's own sku")
+		} else if !imp.isParent(row.ParentSKU) {
+			problems = append(problems, "parent_sku '"+row.ParentSKU+"' does not match a parent product")
+		}
+		if existing == nil && row.Price == nil {
+			problems = append(problems, "price is required for new variations")
+		}
+		if existing != nil && !existing.IsChild {
+			problems = append(problems, "sku belongs to a parent product and cannot become a variation")
+		}
+	} else {
+		if existing != nil && existing.IsChild {
+			problems = append(problems, "sku belongs to a variation, parent_sku is required")
+		}
+		if existing == nil {
+			if row.Name == nil {
+				problems = append(problems, "name is required for new products")
+			}
+			if row.Price == nil {
+				problems = append(problems, "price is required for new products")
+			}
+			if row.Currency == nil {
+				problems = append(problems, "currency is required for new products")
+			}
+			if row.CategoryPath == nil {
+				problems = append(problems, "category_path is required for new products")
+			}
+		}
+	}
+
+	if row.Price != nil && *row.Price < 0 {
+		problems = append(problems, "price cannot be negative")
+	}
+	if row.Currency != nil && len(*row.Currency) != 3 {
+		problems = append(problems, "currency must be a 3 letter code")
+	}
+	if row.Name != nil && len(*row.Name) > 150 {
+		problems = append(problems, "name must be at most 150 characters")
+	}
+	if row.Status != nil && *row.Status != "published" && *row.Status != "unpublished" {
+		problems = append(problems, "status must be published or unpublished")
+	}
+	if row.CategoryPath != nil {
+		if _, ok := imp.categories[normalizeCategoryPath(*row.CategoryPath)]; !ok {
+			problems = append(problems, "category '"+*row.CategoryPath+"' does not exist")
+		}
+	}
+	if row.SalePrice != nil && *row.SalePrice < 0 {
+		problems = append(problems, "sale_price cannot be negative")
+	}
+	if row.ParentSKU != "" && (row.SaleStartsAt != nil || row.SaleEndsAt != nil || row.PublishAt != nil || row.UnpublishAt != nil) {
+		problems = append(problems, "sale and publishing dates are taken from the parent product, leave them blank for variations")
+	}
+	if row.Stock != nil && *row.Stock < 0 {
+		problems = append(problems, "stock cannot be negative")
+	}
+	if row.Stock != nil && row.ParentSKU != "" {
+		problems = append(problems, "stock is tracked on the parent product, leave it blank for variations")
+	}
+	for _, url := range row.ImageURLs {
+		attached := existing != nil && imp.images[existing.ID][url]
+		if !attached && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			problems = append(problems, "image url '"+url+"' must be an absolute http(s) URL")
+		}
+	}
+
+	return problems
+}
+
+// isParent reports whether sku is a parent product in the file or in the catalogue
+func (imp *importer) isParent(sku string) bool {
+	if row, ok := imp.fileSKUs[sku]; ok {
+		return row.ParentSKU == ""
+	}
+	product := imp.existing[sku]
+	return product != nil && !product.IsChild
+}
+
+// apply upserts a validated row, returning whether the product was created
+func (imp *importer) apply(ctx context.Context, row *Row) (bool, error) {
+	product := imp.existing[row.SKU]
+	created := product == nil
+
+	if created {
+		product = &models.Product{SKU: row.SKU, Status: toPtr("unpublished")}
+	}
+
+	if row.ParentSKU != "" {
+		parent := imp.existing[row.ParentSKU]
+		if parent == nil {
+			return false, errors.New("parent product '" + row.ParentSKU + "' failed to import")
+		}
+		// Variations share the parent's details, as in CreateProduct
+		product.IsChild = true
+		product.ParentID = &parent.ID
+		product.Name = parent.Name
+		product.Description = parent.Description
+		product.Brand = parent.Brand
+		product.Currency = parent.Currency
+		product.CategoryID = parent.CategoryID
+		product.Status = parent.Status
+		product.PublishAt = parent.PublishAt
+		product.UnpublishAt = parent.UnpublishAt
+		product.SaleStartsAt = parent.SaleStartsAt
+		product.SaleEndsAt = parent.SaleEndsAt
+		if product.Barcode == nil {
+			product.Barcode = parent.Barcode
+		}
+	}
+
+	if row.Name != nil && row.ParentSKU == "" {
+		product.Name = *row.Name
+	}
+	if row.Description != nil && row.ParentSKU == "" {
+		product.Description = *row.Description
+	}
+	if row.Brand != nil && row.ParentSKU == "" {
+		product.Brand = row.Brand
+	}
+	if row.Barcode != nil {
+		product.Barcode = row.Barcode
+	}
+	if row.Price != nil {
+		product.Price = *row.Price
+	}
+	if row.Currency != nil && row.ParentSKU == "" {
+		product.Currency = strings.ToUpper(*row.Currency)
+	}
+	if row.CategoryPath != nil && row.ParentSKU == "" {
+		product.CategoryID = imp.categories[normalizeCategoryPath(*row.CategoryPath)]
+	}
+	if row.Status != nil && row.ParentSKU == "" {
+		product.Status = row.Status
+	}
+	if row.Featured != nil {
+		product.Featured = *row.Featured
+	}
+	if row.Size != nil {
+		product.Size = *row.Size
+	}
+	if row.SalePrice != nil {
+		product.SalePrice = row.SalePrice
+	}
+	if row.ParentSKU == "" {
+		if row.SaleStartsAt != nil {
+			product.SaleStartsAt = row.SaleStartsAt
+		}
+		if row.SaleEndsAt != nil {
+			product.SaleEndsAt = row.SaleEndsAt
+		}
+		if row.PublishAt != nil {
+			product.PublishAt = row.PublishAt
+		}
+		if row.UnpublishAt != nil {
+			product.UnpublishAt = row.UnpublishAt
+		}
+	}
+
+	images, err := imp.downloadImages(ctx, product.ID, row.ImageURLs)
+	if err != nil {
+		return false, err
+	}
+
+	err = imp.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Images", "Category").Save(product).Error; err != nil {
+			return err
+		}
+
+		if row.Stock != nil {
+			if err := setStock(tx, product.ID, *row.Stock); err != nil {
+				return err
+			}
+		} else if created && !product.IsChild {
+			if err := setStock(tx, product.ID, 0); err != nil {
+				return err
+			}
+		}
+
+		for _, image := range images {
+			image.ProductID = product.ID
+		}
+		if len(images) > 0 {
+			return tx.Create(&images).Error
+		}
+		return nil
+	})
+	if err != nil {
+		for _, image := range images {
+			storage.Remove(ctx, image.StorageKey)
+		}
+		return false, err
+	}
+
+	imp.existing[product.SKU] = product
+	if imp.images[product.ID] == nil {
+		imp.images[product.ID] = map[string]bool{}
+	}
+	for _, image := range images {
+		imp.images[product.ID][*image.SourceURL] = true
+	}
+	return created, nil
+}
+
+// setStock sets the absolute stock level of a product, creating its inventory record if needed
+func setStock(tx *gorm.DB, productID uint, stock int) error {
+	var inventory models.Inventory
+	err := tx.Where("product_id = ?", productID).First(&inventory).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Create(&models.Inventory{
+			ProductID:  productID,
+			StockLevel: stock,
+			InOpen:     0,
+			ChangeType: "restock",
+			ChangeDate: time.Now(),
+		}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	inventory.StockLevel = stock
+	inventory.ChangeType = "restock"
+	inventory.ChangeDate = time.Now()
+	return tx.Save(&inventory).Error
+}
+
+// downloadImages fetches and stores the image URLs that are not already attached to the product
+func (imp *importer) downloadImages(ctx context.Context, productID uint, urls []string) ([]*models.ProductImage, error) {
+	var images []*models.ProductImage
+	seen := map[string]bool{}
+
+	for _, url := range urls {
+		if imp.images[productID][url] || seen[url] {
+			continue
+		}
+		seen[url] = true
+
+		key, err := fetchImage(ctx, url)
+		if err != nil {
+			for _, image := range images {
+				storage.Remove(ctx, image.StorageKey)
+			}
+			return nil, fmt.Errorf("image %s: %w", url, err)
+		}
+		source := url
+		images = append(images, &models.ProductImage{StorageKey: key, SourceURL: &source})
+	}
+
+	return images, nil
+}
+
+var errForbiddenImageURL = errors.New("image URLs must be public http or https addresses")
+
+// imageClient only connects to public addresses, checked on the resolved IP of every connection so that
+// neither a redirect nor a DNS answer can point an uploaded spreadsheet at internal services
+var imageClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return errForbiddenImageURL
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+			return errForbiddenImageURL
+		}
+		return nil
+	},
+}
+
+// isPublicIP reports whether an address is reachable on the internet rather than a loopback, private,
+// link local (e.g. cloud metadata) or otherwise special address
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func fetchImage(ctx context.Context, url string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+		return "", errForbiddenImageURL
+	}
+
+	response, err := imageClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download failed with status %d", response.StatusCode)
+	}
+
+	limits := imaging.DefaultLimits()
+	if limits.MaxBytes <= 0 {
+		limits.MaxBytes = imaging.DefaultMaxBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, int64(limits.MaxBytes)+1))
+	if err != nil {
+		return "", err
+	}
+
+	clean, _, err := imaging.Sanitize(data, limits)
+	if err != nil {
+		return "", err
+	}
+
+	return storage.SaveBytes(ctx, "products", clean)
+}
+
+func toPtr(s string) *string {
+	return &s
+}
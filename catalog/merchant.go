@@ -0,0 +1,240 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const googleNamespace = "http://base.google.com/ns/1.0"
+
+// merchantItem is a sellable product, a variation or a product without variations, as listed in the merchant feeds
+type merchantItem struct {
+	ID           uint
+	SKU          string
+	Size         string
+	Barcode      *string
+	Currency     string
+	Price        float64
+	SalePrice    *float64
+	SaleStartsAt *time.Time
+	SaleEndsAt   *time.Time
+	OnSale       bool
+	Available    int
+	ParentID     uint
+	ParentSKU    string
+	Name         string
+	Description  string
+	Brand        *string
+	Slug         *string
+	CategoryID   uint
+	UpdatedAt    time.Time
+
+	CategoryPath string   `gorm:"-"`
+	ImageURLs    []string `gorm:"-"`
+}
+
+// merchantItems loads every published variation, and every published product without variations, with its stock
+func merchantItems(db *gorm.DB) ([]*merchantItem, error) {
+	var items []*merchantItem
+	err := db.Table("products AS items").
+		Select(`items.id, items.sku, items.size, items.barcode, items.currency,
+				items.price, items.sale_price, items.sale_starts_at, items.sale_ends_at,
+				COALESCE(` + utils.SaleActiveSQL("items") + `, false) AS on_sale,
+				` + utils.AvailableStockSQL("items") + ` AS available,
+				parent.id AS parent_id, parent.sku AS parent_sku, parent.name, parent.description, parent.brand, parent.slug, parent.category_id,
+				GREATEST(items.updated_at, parent.updated_at) AS updated_at`).
+		Joins("JOIN products AS parent ON parent.id = COALESCE(items.parent_id, items.id) AND parent.deleted_at IS NULL").
+		Where("items.deleted_at IS NULL").
+		Where(utils.PublishedSQL("parent")).
+		Where(`items.is_child = true OR NOT EXISTS (
+				SELECT 1 FROM products AS variations WHERE variations.parent_id = items.id AND variations.is_child = true AND variations.deleted_at IS NULL
+			)`).
+		Order("parent.id, items.id").
+		Scan(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := CategoryPaths(db)
+	if err != nil {
+		return nil, err
+	}
+
+	parentIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		item.CategoryPath = paths[item.CategoryID]
+		parentIDs = append(parentIDs, item.ParentID)
+	}
+
+	var images []*models.ProductImage
+	if err := db.Where("product_id IN ?", parentIDs).Order("id").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	urls := map[uint][]string{}
+	for _, image := range images {
+		if image.URL != "" {
+			urls[image.ProductID] = append(urls[image.ProductID], absoluteURL(image.URL))
+		}
+	}
+	for _, item := range items {
+		item.ImageURLs = urls[item.ParentID]
+	}
+
+	return items, nil
+}
+
+// merchantBrand is the brand of the item, or MERCHANT_BRAND for products without one
+func merchantBrand(item *merchantItem) string {
+	if item.Brand != nil && *item.Brand != "" {
+		return *item.Brand
+	}
+	return os.Getenv("MERCHANT_BRAND")
+}
+
+// Fields of a Google Merchant item in the order of the CSV feed
+var merchantColumns = []string{
+	"id", "item_group_id", "title", "description", "link", "image_link", "additional_image_link",
+	"availability", "price", "sale_price", "sale_price_effective_date", "brand", "gtin",
+	"identifier_exists", "condition", "product_type", "size",
+}
+
+// merchantFields returns the Google Merchant attributes of an item by column name
+func merchantFields(item *merchantItem) map[string]string {
+	fields := map[string]string{
+		"id":          item.SKU,
+		"title":       item.Name,
+		"description": item.Description,
+		"price":       formatMerchantPrice(item.Price, item.Currency),
+		"brand":       merchantBrand(item),
+		"condition":   "new",
+		"size":        item.Size,
+	}
+
+	if item.ID != item.ParentID {
+		fields["item_group_id"] = item.ParentSKU
+	}
+	if item.Size != "" && item.ID != item.ParentID {
+		fields["title"] = item.Name + " - " + item.Size
+	}
+	if item.Slug != nil {
+		fields["link"] = config.StorefrontURL() + "/products/" + *item.Slug
+	}
+	if len(item.ImageURLs) > 0 {
+		fields["image_link"] = item.ImageURLs[0]
+		fields["additional_image_link"] = strings.Join(item.ImageURLs[1:], ",")
+	}
+
+	fields["availability"] = "out_of_stock"
+	if item.Available > 0 {
+		fields["availability"] = "in_stock"
+	}
+
+	if item.OnSale {
+		fields["sale_price"] = formatMerchantPrice(*item.SalePrice, item.Currency)
+		if item.SaleStartsAt != nil || item.SaleEndsAt != nil {
+			start, end := "", ""
+			if item.SaleStartsAt != nil {
+				start = item.SaleStartsAt.UTC().Format(time.RFC3339)
+			}
+			if item.SaleEndsAt != nil {
+				end = item.SaleEndsAt.UTC().Format(time.RFC3339)
+			}
+			fields["sale_price_effective_date"] = start + "/" + end
+		}
+	}
+
+	if item.Barcode != nil && *item.Barcode != "" {
+		fields["gtin"] = *item.Barcode
+	} else {
+		fields["identifier_exists"] = "no"
+	}
+
+	fields["product_type"] = item.CategoryPath
+
+	return fields
+}
+
+// formatMerchantPrice formats a price as Google expects it, e.g. "12.50 USD"
+func formatMerchantPrice(price float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", price, strings.ToUpper(currency))
+}
+
+// merchantXML renders the items as an RSS 2.0 feed with the Google Merchant namespace
+func merchantXML(items []*merchantItem) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<rss version="2.0" xmlns:g="` + googleNamespace + `">` + "\n<channel>\n")
+	title := os.Getenv("MERCHANT_FEED_TITLE")
+	if title == "" {
+		title = "Products"
+	}
+	writeXMLElement(&b, "  ", "title", title)
+	writeXMLElement(&b, "  ", "link", config.StorefrontURL())
+	writeXMLElement(&b, "  ", "description", "Product feed")
+
+	for _, item := range items {
+		fields := merchantFields(item)
+
+		b.WriteString("  <item>\n")
+		for _, column := range merchantColumns {
+			if fields[column] == "" {
+				continue
+			}
+			switch column {
+			case "title", "description", "link":
+				writeXMLElement(&b, "    ", column, fields[column])
+			case "additional_image_link":
+				for _, url := range strings.Split(fields[column], ",") {
+					writeXMLElement(&b, "    ", "g:"+column, url)
+				}
+			default:
+				writeXMLElement(&b, "    ", "g:"+column, fields[column])
+			}
+		}
+		b.WriteString("  </item>\n")
+	}
+
+	b.WriteString("</channel>\n</rss>\n")
+	return b.Bytes(), nil
+}
+
+// writeXMLElement writes <name>value</name> on its own line with the value escaped
+func writeXMLElement(b *bytes.Buffer, indent string, name string, value string) {
+	b.WriteString(indent + "<" + name + ">")
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</" + name + ">\n")
+}
+
+// merchantCSV renders the items as a comma separated feed with a header row
+func merchantCSV(items []*merchantItem) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+
+	if err := w.Write(merchantColumns); err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		fields := merchantFields(item)
+
+		record := make([]string, len(merchantColumns))
+		for i, column := range merchantColumns {
+			record[i] = fields[column]
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AffinityThresholds limit which product pairs count as frequently bought together
+type AffinityThresholds struct {
+	MinOrders     int     // Orders that must contain both products
+	MinSupport    float64 // Share of all orders that must contain both products
+	MinConfidence float64 // Share of the orders of a product that must also contain the other
+	Window        time.Duration
+}
+
+// DefaultAffinityThresholds reads the thresholds from FBT_MIN_ORDERS, FBT_MIN_SUPPORT, FBT_MIN_CONFIDENCE and FBT_WINDOW_DAYS
+func DefaultAffinityThresholds() AffinityThresholds {
+	thresholds := AffinityThresholds{MinOrders: 2, MinSupport: 0.001, MinConfidence: 0.05, Window: 180 * 24 * time.Hour}
+
+	if v, err := strconv.Atoi(os.Getenv("FBT_MIN_ORDERS")); err == nil && v > 0 {
+		thresholds.MinOrders = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("FBT_MIN_SUPPORT"), 64); err == nil && v >= 0 {
+		thresholds.MinSupport = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("FBT_MIN_CONFIDENCE"), 64); err == nil && v >= 0 {
+		thresholds.MinConfidence = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("FBT_WINDOW_DAYS")); err == nil && v > 0 {
+		thresholds.Window = time.Duration(v) * 24 * time.Hour
+	}
+
+	return thresholds
+}
+
+// StartRecommendations recomputes frequently bought together pairs every night at RECOMMENDATIONS_HOUR (default 3)
+func StartRecommendations() {
+	hour, err := strconv.Atoi(os.Getenv("RECOMMENDATIONS_HOUR"))
+	if err != nil || hour < 0 || hour > 23 {
+		hour = 3
+	}
+
+	compute := func(ctx context.Context) error {
+		return ComputeAffinities(ctx, DefaultAffinityThresholds())
+	}
+	jobs.Daily("product affinities", time.Duration(hour)*time.Hour, compute)
+
+	// Don't leave a fresh deployment without pairs until the first night
+	var computed int64
+	if err := config.DB.Model(&models.ProductAffinity{}).Count(&computed).Error; err == nil && computed == 0 {
+		jobs.Enqueue("product affinities", compute)
+	}
+}
+
+// ComputeAffinities replaces the frequently bought together pairs with the co-occurrence of parent products
+// in orders that were not cancelled, keeping the pairs that pass the thresholds
+func ComputeAffinities(ctx context.Context, thresholds AffinityThresholds) error {
+	since := time.Now().Add(-thresholds.Window)
+
+	var pairs int64
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.ProductAffinity{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Exec(`WITH lines AS (
+				SELECT DISTINCT order_items.order_id, COALESCE(products.parent_id, products.id) AS product_id
+				FROM order_items
+				JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL AND orders.order_status <> 'cancelled'
+				JOIN products ON products.id = order_items.product_id
+				WHERE orders.created_at >= ?
+			),
+			totals AS (
+				SELECT COUNT(DISTINCT order_id)::float AS orders FROM lines
+			),
+			singles AS (
+				SELECT product_id, COUNT(*) AS orders FROM lines GROUP BY product_id
+			),
+			pairs AS (
+				SELECT a.product_id, b.product_id AS related_product_id, COUNT(*) AS orders
+				FROM lines a
+				JOIN lines b ON a.order_id = b.order_id AND a.product_id <> b.product_id
+				GROUP BY a.product_id, b.product_id
+			)
+			INSERT INTO product_affinities (product_id, related_product_id, orders, support, confidence, computed_at)
+			SELECT pairs.product_id, pairs.related_product_id, pairs.orders,
+				pairs.orders / totals.orders,
+				pairs.orders::float / singles.orders,
+				NOW()
+			FROM pairs
+			JOIN singles ON singles.product_id = pairs.product_id
+			CROSS JOIN totals
+			WHERE pairs.orders >= ?
+				AND pairs.orders / totals.orders >= ?
+				AND pairs.orders::float / singles.orders >= ?`,
+			since, thresholds.MinOrders, thresholds.MinSupport, thresholds.MinConfidence)
+		pairs = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Computed %d frequently bought together pairs", pairs)
+	return nil
+}
@@ -0,0 +1,64 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/events"
+	"backend/jobs"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StartScheduler applies scheduled publish_at and unpublish_at times every minute
+func StartScheduler() {
+	jobs.Every("product schedule", time.Minute, ApplySchedule)
+	jobs.Enqueue("product schedule", ApplySchedule)
+}
+
+// ApplySchedule flips the status of products whose publish or unpublish time has passed,
+// copies it to their variations and emits product.published / product.unpublished events
+func ApplySchedule(ctx context.Context) error {
+	var published, unpublished []uint
+
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if published, err = applyStatus(tx, "published", "publish_at"); err != nil {
+			return err
+		}
+		unpublished, err = applyStatus(tx, "unpublished", "unpublish_at")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range published {
+		events.Publish(events.ProductPublished, events.ProductEvent{ProductID: id})
+	}
+	for _, id := range unpublished {
+		events.Publish(events.ProductUnpublished, events.ProductEvent{ProductID: id})
+	}
+
+	return nil
+}
+
+// applyStatus sets status on parent products whose column time has passed and clears it, returning their IDs
+func applyStatus(tx *gorm.DB, status string, column string) ([]uint, error) {
+	var ids []uint
+	if err := tx.Raw(`UPDATE products SET status = ?, `+column+` = NULL, updated_at = NOW()
+		WHERE `+column+` <= NOW() AND parent_id IS NULL AND deleted_at IS NULL
+		RETURNING id`, status).Scan(&ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if err := tx.Exec(`UPDATE products SET status = ?, `+column+` = NULL, updated_at = NOW()
+		WHERE parent_id IN ? AND deleted_at IS NULL`, status, ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,172 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"encoding/xml"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapURL is a <url> entry of a sitemap
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+
+	modifiedAt time.Time
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name      `xml:"sitemapindex"`
+	Xmlns    string        `xml:"xmlns,attr"`
+	Sitemaps []*sitemapURL `xml:"sitemap"`
+}
+
+// SitemapPageName is the name of a page of the sitemap, served under /sitemaps/
+func SitemapPageName(page int) string {
+	return "sitemap-" + strconv.Itoa(page) + ".xml"
+}
+
+// sitemapPageSize is the number of URLs per sitemap page, at most the 50,000 the protocol allows
+func sitemapPageSize() int {
+	size, err := strconv.Atoi(os.Getenv("SITEMAP_PAGE_SIZE"))
+	if err != nil || size <= 0 || size > 50000 {
+		return 50000
+	}
+	return size
+}
+
+// sitemapPages are the storefront paths of CMS pages, from SITEMAP_PAGES as a comma separated list
+func sitemapPages() []string {
+	pages := os.Getenv("SITEMAP_PAGES")
+	if pages == "" {
+		return []string{"/"}
+	}
+
+	var paths []string
+	for _, page := range strings.Split(pages, ",") {
+		if page = strings.TrimSpace(page); page != "" {
+			paths = append(paths, "/"+strings.TrimPrefix(page, "/"))
+		}
+	}
+	return paths
+}
+
+// sitemapURLs lists the CMS pages, categories and published products of the storefront
+func sitemapURLs(db *gorm.DB) ([]*sitemapURL, error) {
+	storefront := config.StorefrontURL()
+
+	var urls []*sitemapURL
+	for _, page := range sitemapPages() {
+		urls = append(urls, &sitemapURL{Loc: storefront + page})
+	}
+
+	var categories []*models.Category
+	if err := db.Select("id, slug, updated_at").Where("slug IS NOT NULL").Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		urls = append(urls, &sitemapURL{Loc: storefront + "/categories/" + *category.Slug, modifiedAt: category.UpdatedAt})
+	}
+
+	var products []*models.Product
+	if err := db.Select("id, slug, updated_at").
+		Where("slug IS NOT NULL AND is_child = false").
+		Where(utils.PublishedSQL("products")).
+		Order("id").
+		Find(&products).Error; err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		urls = append(urls, &sitemapURL{Loc: storefront + "/products/" + *product.Slug, modifiedAt: product.UpdatedAt})
+	}
+
+	for _, url := range urls {
+		if !url.modifiedAt.IsZero() {
+			url.LastMod = url.modifiedAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return urls, nil
+}
+
+// buildSitemaps renders the sitemap pages, and sitemap.xml as an index of them when there is more than one
+func buildSitemaps(db *gorm.DB) (map[string]*Feed, error) {
+	urls, err := sitemapURLs(db)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := map[string]*Feed{}
+	index := &sitemapIndex{Xmlns: sitemapNamespace}
+	size := sitemapPageSize()
+
+	for page, start := 1, 0; start < len(urls) || page == 1; page, start = page+1, start+size {
+		end := start + size
+		if end > len(urls) {
+			end = len(urls)
+		}
+		set := &sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls[start:end]}
+
+		var modifiedAt time.Time
+		for _, url := range set.URLs {
+			if url.modifiedAt.After(modifiedAt) {
+				modifiedAt = url.modifiedAt
+			}
+		}
+
+		body, err := marshalXML(set)
+		if err != nil {
+			return nil, err
+		}
+		name := SitemapPageName(page)
+		documents[name] = &Feed{Body: body, ContentType: "application/xml; charset=utf-8", ModifiedAt: modifiedAt}
+
+		entry := &sitemapURL{Loc: absoluteURL("/sitemaps/" + name), modifiedAt: modifiedAt}
+		if !modifiedAt.IsZero() {
+			entry.LastMod = modifiedAt.UTC().Format(time.RFC3339)
+		}
+		index.Sitemaps = append(index.Sitemaps, entry)
+	}
+
+	if len(index.Sitemaps) == 1 {
+		single := *documents[SitemapPageName(1)]
+		documents[SitemapFeed] = &single
+		return documents, nil
+	}
+
+	body, err := marshalXML(index)
+	if err != nil {
+		return nil, err
+	}
+	var modifiedAt time.Time
+	for _, entry := range index.Sitemaps {
+		if entry.modifiedAt.After(modifiedAt) {
+			modifiedAt = entry.modifiedAt
+		}
+	}
+	documents[SitemapFeed] = &Feed{Body: body, ContentType: "application/xml; charset=utf-8", ModifiedAt: modifiedAt}
+
+	return documents, nil
+}
+
+// marshalXML renders v as an indented XML document with its declaration
+func marshalXML(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported file format, expected csv or xlsx")
+
+// FormatFromFileName returns "csv" or "xlsx" based on the extension of a file name
+func FormatFromFileName(name string) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return "csv", nil
+	case ".xlsx":
+		return "xlsx", nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// ReadSheet reads every row of a csv file or of the first sheet of an xlsx workbook
+func ReadSheet(r io.Reader, format string) ([][]string, error) {
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		return reader.ReadAll()
+	case "xlsx":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.GetRows(f.GetSheetName(0))
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// WriteSheet writes rows as a csv file or as a single sheet xlsx workbook
+func WriteSheet(w io.Writer, format string, rows [][]string) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.WriteAll(rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case "xlsx":
+		f := excelize.NewFile()
+		defer f.Close()
+
+		sheet := f.GetSheetName(0)
+		for i, row := range rows {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return err
+			}
+			values := make([]interface{}, len(row))
+			for j, value := range row {
+				values[j] = value
+			}
+			if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+				return err
+			}
+		}
+		return f.Write(w)
+	}
+	return ErrUnsupportedFormat
+}
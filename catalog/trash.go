@@ -0,0 +1,70 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// StartTrash permanently deletes records that have been in the trash for longer than TRASH_RETENTION_DAYS
+// (default 30) every night
+func StartTrash() {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+
+	jobs.Daily("trash purge", 5*time.Hour, func(ctx context.Context) error {
+		return PurgeExpiredTrash(ctx, time.Now().AddDate(0, 0, -days))
+	})
+}
+
+// PurgeExpiredTrash purges every record deleted before the cutoff. Records that cannot be purged yet,
+// such as ordered products, are skipped and stay in the trash. Records that fail are logged and
+// returned together once the others are purged.
+func PurgeExpiredTrash(ctx context.Context, deletedBefore time.Time) error {
+	db := config.DB.WithContext(ctx)
+
+	purged, kept := 0, 0
+	var errs []error
+	for _, entity := range models.TrashEntities {
+		ids, err := models.ExpiredTrashIDs(db, entity, deletedBefore)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, id := range ids {
+			files, err := models.PurgeFromTrash(db, entity, id)
+			var conflict *models.TrashConflict
+			if errors.As(err, &conflict) {
+				kept++
+				continue
+			}
+			// The failing record sorts first again tomorrow, so it must not stop the records after it
+			if err != nil {
+				log.Printf("failed to purge %s %d from the trash: %v", entity, id, err)
+				errs = append(errs, fmt.Errorf("%s %d: %w", entity, id, err))
+				continue
+			}
+			purged++
+
+			for _, key := range files {
+				if err := storage.Remove(ctx, key); err != nil {
+					log.Printf("failed to remove %s from storage: %v", key, err)
+				}
+			}
+		}
+	}
+
+	log.Printf("Purged %d records from the trash, kept %d that are still referenced, %d failed", purged, kept, len(errs))
+	return errors.Join(errs...)
+}
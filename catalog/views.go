@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// viewDedupWindow folds repeated views of a product by the same viewer into one
+const viewDedupWindow = 30 * time.Minute
+
+// views holds the product views waiting to be written. They have their own bounded queue and worker so a
+// flood of page views is dropped rather than crowding out the shared background jobs.
+var views = make(chan models.ProductView, 1024)
+
+// StartViews writes the recorded product views, and removes views older than PRODUCT_VIEW_RETENTION_DAYS
+// (default 90) every night
+func StartViews() {
+	days, err := strconv.Atoi(os.Getenv("PRODUCT_VIEW_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 90
+	}
+
+	jobs.Daily("product view retention", 4*time.Hour, func(ctx context.Context) error {
+		return config.DB.WithContext(ctx).Where("viewed_at < ?", time.Now().AddDate(0, 0, -days)).Delete(&models.ProductView{}).Error
+	})
+
+	go func() {
+		for view := range views {
+			if err := saveView(config.DB, view); err != nil {
+				log.Printf("Failed to record a view of product %d: %v", view.ProductID, err)
+			}
+		}
+	}()
+}
+
+// RecordProductView queues a view of a product by a user or an anonymous session. Views are dropped while
+// the queue is full, they are only used for recommendations.
+func RecordProductView(userID *uint, sessionID *string, productID uint) {
+	if userID == nil && sessionID == nil {
+		return
+	}
+
+	select {
+	case views <- models.ProductView{ProductID: productID, UserID: userID, SessionID: sessionID, ViewedAt: time.Now()}:
+	default:
+	}
+}
+
+// saveView stores a view, or moves the time of the same viewer's view of the product within viewDedupWindow
+func saveView(db *gorm.DB, view models.ProductView) error {
+	recent := db.Model(&models.ProductView{}).Where("product_id = ? AND viewed_at > ?", view.ProductID, view.ViewedAt.Add(-viewDedupWindow))
+	if view.UserID != nil {
+		recent = recent.Where("user_id = ?", *view.UserID)
+	} else {
+		recent = recent.Where("session_id = ? AND user_id IS NULL", *view.SessionID)
+	}
+
+	result := recent.Update("viewed_at", view.ViewedAt)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return db.Create(&view).Error
+}
@@ -0,0 +1,80 @@
+// Command migrate-images moves product, category and banner images stored in
+// bytea columns into the configured blob store.
+//
+// It is safe to re-run: rows that already have a storage key are skipped and
+// the bytea column is cleared once the upload succeeded.
+package main
+
+import (
+	"backend/config"
+	"backend/storage"
+	"context"
+	"log"
+)
+
+const batchSize = 50
+
+var tables = map[string]string{
+	"product_images":  "products",
+	"category_images": "categories",
+	"content_images":  "content",
+}
+
+func main() {
+	config.ConnectDatabase()
+	if err := config.MigrateDatabase(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to initialise storage: %v", err)
+	}
+
+	ctx := context.Background()
+	for table, prefix := range tables {
+		moved, err := migrateTable(ctx, table, prefix)
+		if err != nil {
+			log.Fatalf("Failed to migrate %s after %d images: %v", table, moved, err)
+		}
+		log.Printf("Moved %d images from %s", moved, table)
+	}
+}
+
+func migrateTable(ctx context.Context, table string, prefix string) (int, error) {
+	var hasColumn bool
+	if err := config.DB.Raw(`SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns WHERE table_name = ? AND column_name = 'image'
+		)`, table).Scan(&hasColumn).Error; err != nil {
+		return 0, err
+	}
+	if !hasColumn {
+		return 0, nil
+	}
+
+	moved := 0
+	for {
+		var rows []struct {
+			ID    uint
+			Image []byte
+		}
+		if err := config.DB.Raw(`SELECT id, image FROM `+table+`
+			WHERE image IS NOT NULL AND (storage_key IS NULL OR storage_key = '')
+			ORDER BY id LIMIT ?`, batchSize).Scan(&rows).Error; err != nil {
+			return moved, err
+		}
+		if len(rows) == 0 {
+			return moved, nil
+		}
+
+		for _, row := range rows {
+			key, err := storage.SaveBytes(ctx, prefix, row.Image)
+			if err != nil {
+				return moved, err
+			}
+			if err := config.DB.Exec(`UPDATE `+table+` SET storage_key = ?, image = NULL WHERE id = ?`, key, row.ID).Error; err != nil {
+				storage.Remove(ctx, key)
+				return moved, err
+			}
+			moved++
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"backend/models"
 	"fmt"
 	"log"
 	"os"
@@ -48,30 +49,116 @@ func ConnectDatabase() {
 	} else {
 		log.Println("Database Connected Successfully !")
 	}
-	// log.Println("Attempting to migrate")
-	// db.AutoMigrate(
-	// 	models.CartItem{},
-	// 	models.Category{},
-	// 	models.CategoryImage{},
-	// 	models.ContentImage{},
-	// 	models.Coupon{},
-	// 	models.CouponUsageHistory{},
-	// 	models.Inventory{},
-	// 	models.Order{},
-	// 	models.OrderItem{},
-	// 	models.Payment{},
-	// 	models.PaymentOption{},
-	// 	models.Product{},
-	// 	models.ProductImage{},
-	// 	models.Review{},
-	// 	models.ShippingAddress{},
-	// 	models.ShoppingCart{},
-	// 	models.ShippingOptions{},
-	// 	models.User{},
-	// 	models.ProductAttribute{},
-	// 	models.WishList{},
-	// 	models.Shop{},
-	// )
-	// log.Println("Finished migration")
 	DB = db
 }
+
+// MigrateDatabase creates or updates the tables of every model
+func MigrateDatabase() error {
+	log.Println("Attempting to migrate")
+
+	// SKU, email and coupon code were unique across soft deleted rows too, partial indexes replace those
+	// constraints. Check constraints whose allowed values changed are dropped so AutoMigrate recreates them.
+	for _, statement := range []string{
+		"ALTER TABLE IF EXISTS products DROP CONSTRAINT IF EXISTS products_sku_key",
+		"ALTER TABLE IF EXISTS products DROP CONSTRAINT IF EXISTS uni_products_sku",
+		"DROP INDEX IF EXISTS idx_products_sku",
+		"ALTER TABLE IF EXISTS users DROP CONSTRAINT IF EXISTS users_email_key",
+		"ALTER TABLE IF EXISTS users DROP CONSTRAINT IF EXISTS uni_users_email",
+		"ALTER TABLE IF EXISTS coupons DROP CONSTRAINT IF EXISTS coupons_code_key",
+		"ALTER TABLE IF EXISTS coupons DROP CONSTRAINT IF EXISTS uni_coupons_code",
+		"ALTER TABLE IF EXISTS coupons DROP CONSTRAINT IF EXISTS chk_coupons_discount_type",
+		"ALTER TABLE IF EXISTS payments DROP CONSTRAINT IF EXISTS chk_payments_payment_method",
+		"ALTER TABLE IF EXISTS payments DROP CONSTRAINT IF EXISTS chk_payments_payment_status",
+		"ALTER TABLE IF EXISTS products DROP CONSTRAINT IF EXISTS chk_products_product_type",
+		"ALTER TABLE IF EXISTS store_credit_transactions DROP CONSTRAINT IF EXISTS chk_store_credit_transactions_kind",
+		// Guest checkouts have no user
+		"ALTER TABLE IF EXISTS orders ALTER COLUMN user_id DROP NOT NULL",
+		"ALTER TABLE IF EXISTS coupon_usage_histories ALTER COLUMN user_id DROP NOT NULL",
+		// Customers have one cart, the items of their other carts move into the one that is kept
+		"ALTER TABLE IF EXISTS shopping_carts ALTER COLUMN user_id DROP NOT NULL",
+		`DO $$ BEGIN
+			IF to_regclass('shopping_carts') IS NOT NULL AND to_regclass('cart_items') IS NOT NULL THEN
+				CREATE TEMP TABLE kept_carts ON COMMIT DROP AS
+					SELECT user_id, MIN(uuid::text)::uuid AS uuid FROM shopping_carts WHERE user_id IS NOT NULL GROUP BY user_id HAVING COUNT(*) > 1;
+				UPDATE cart_items SET cart_id = kept_carts.uuid
+					FROM shopping_carts, kept_carts
+					WHERE cart_items.cart_id = shopping_carts.uuid AND shopping_carts.user_id = kept_carts.user_id AND shopping_carts.uuid <> kept_carts.uuid;
+				DELETE FROM shopping_carts USING kept_carts
+					WHERE shopping_carts.user_id = kept_carts.user_id AND shopping_carts.uuid <> kept_carts.uuid;
+			END IF;
+		END $$`,
+		// A product has one line per cart, duplicate lines add up into the oldest
+		`DO $$ BEGIN
+			IF to_regclass('cart_items') IS NOT NULL THEN
+				UPDATE cart_items SET quantity = merged.quantity
+					FROM (SELECT MIN(id) AS id, SUM(quantity) AS quantity FROM cart_items GROUP BY cart_id, product_id HAVING COUNT(*) > 1) merged
+					WHERE cart_items.id = merged.id;
+				DELETE FROM cart_items USING cart_items kept
+					WHERE cart_items.cart_id = kept.cart_id AND cart_items.product_id = kept.product_id AND cart_items.id > kept.id;
+			END IF;
+		END $$`,
+	} {
+		if err := DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	err := DB.AutoMigrate(
+		models.CartItem{},
+		models.Category{},
+		models.CategoryImage{},
+		models.ContentImage{},
+		models.Coupon{},
+		models.CouponUsageHistory{},
+		models.Inventory{},
+		models.Order{},
+		models.OrderItem{},
+		models.Payment{},
+		models.PaymentOption{},
+		models.Product{},
+		models.ProductImage{},
+		models.Review{},
+		models.ShippingAddress{},
+		models.ShoppingCart{},
+		models.ShippingOptions{},
+		models.User{},
+		models.ProductAttribute{},
+		models.WishList{},
+		models.Shop{},
+		models.ProductImportJob{},
+		models.Revision{},
+		models.SlugRedirect{},
+		models.BundleComponent{},
+		models.ProductLink{},
+		models.ProductAffinity{},
+		models.ProductView{},
+		models.AttributeDefinition{},
+		models.CouponScope{},
+		models.OrderDiscount{},
+		models.Campaign{},
+		models.CampaignCode{},
+		models.Promotion{},
+		models.PromotionTier{},
+		models.GiftCard{},
+		models.GiftCardTransaction{},
+		models.StoreCreditTransaction{},
+		models.LoyaltyTransaction{},
+		models.LoyaltyRule{},
+		models.Referral{},
+		models.UserDevice{},
+		models.CartRecovery{},
+		models.ProductAlert{},
+		models.EmailVerification{},
+	)
+	if err != nil {
+		return err
+	}
+	if err := models.BackfillSlugs(DB); err != nil {
+		return err
+	}
+	if err := models.BackfillCategoryDepths(DB); err != nil {
+		return err
+	}
+	log.Println("Finished migration")
+	return nil
+}
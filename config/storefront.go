@@ -0,0 +1,16 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// StorefrontURL is the public address of the shop front end, used to build links to products and categories
+func StorefrontURL() string {
+	return strings.TrimSuffix(os.Getenv("STOREFRONT_URL"), "/")
+}
+
+// PublicAPIURL is the public address of this API, used to make relative links such as local image URLs absolute
+func PublicAPIURL() string {
+	return strings.TrimSuffix(os.Getenv("API_PUBLIC_URL"), "/")
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"backend/alerts"
+	"backend/carts"
+	"backend/config"
+	"backend/models"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetMyAlerts returns the back in stock and price drop alerts of the user
+func GetMyAlerts(c *gin.Context) {
+	var productAlerts []models.ProductAlert
+	if err := config.DB.Where("user_id = ?", c.GetUint("user_id")).Order("id DESC").Find(&productAlerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, productAlerts)
+}
+
+// SubscribeBackInStock asks for an email once an out of stock product or variation can be ordered again.
+// Subscribing again after the email asks for the next restock.
+func SubscribeBackInStock(c *gin.Context) {
+	var payload struct {
+		ProductID uint `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only products in the storefront can be followed
+	if err := priceOrderItems([]models.OrderItem{{ProductID: payload.ProductID}}, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	available, err := carts.Available(config.DB, []uint{payload.ProductID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if available[payload.ProductID] > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is in stock"})
+		return
+	}
+
+	alert := models.ProductAlert{UserID: c.GetUint("user_id"), ProductID: payload.ProductID, Kind: "back_in_stock"}
+	if err := config.DB.Omit("User", "Product").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "product_id"}, {Name: "kind"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"notified_at": nil}),
+	}).Create(&alert).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alert)
+}
+
+// DeleteAlert removes an alert of the user
+func DeleteAlert(c *gin.Context) {
+	result := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).Delete(&models.ProductAlert{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert removed"})
+}
+
+// UnsubscribeAlerts removes alerts with the unsubscribe token of an alert email, which needs no sign in
+func UnsubscribeAlerts(c *gin.Context) {
+	ok, err := alerts.Unsubscribe(config.DB, c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid unsubscribe link"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed"})
+}
+
+// SetWishlistPriceAlert turns price drop alerts on or off for a wishlist item. Drops are measured from the
+// price when the alert is turned on.
+func SetWishlistPriceAlert(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var wishlistItem models.WishList
+	if err := config.DB.Where("user_id = ?", userID).First(&wishlistItem, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wish-list item not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	var payload struct {
+		Enabled *bool `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !*payload.Enabled {
+		if err := config.DB.Where("user_id = ? AND product_id = ? AND kind = 'price_drop'", userID, wishlistItem.ProductID).Delete(&models.ProductAlert{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, wishlistItem)
+		return
+	}
+
+	var product models.Product
+	if err := config.DB.First(&product, wishlistItem.ProductID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	price := product.EffectivePrice(time.Now())
+	alert := models.ProductAlert{UserID: userID, ProductID: wishlistItem.ProductID, Kind: "price_drop", Price: &price}
+	if err := config.DB.Omit("User", "Product").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "product_id"}, {Name: "kind"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"price": price}),
+	}).Create(&alert).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	wishlistItem.PriceAlert = true
+	c.JSON(http.StatusOK, wishlistItem)
+}
@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateAttributeDefinition creates a new attribute definition for a category
+func CreateAttributeDefinition(c *gin.Context) {
+	var definition *models.AttributeDefinition
+
+	if err := c.ShouldBindJSON(&definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := config.DB.Create(&definition).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, definition)
+}
+
+// GetAttributeDefinitions retrieves the attribute definitions, with ?category_id= those that apply to a category including inherited ones
+func GetAttributeDefinitions(c *gin.Context) {
+	var definitions []*models.AttributeDefinition
+	model := config.DB.Order("position, name")
+
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		id, err := strconv.ParseUint(categoryID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+			return
+		}
+		lineage, err := models.CategoryLineage(config.DB, uint(id))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		model = model.Where("category_id IN ?", lineage)
+	}
+
+	if err := model.Find(&definitions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, definitions)
+}
+
+// UpdateAttributeDefinition updates an attribute definition by its ID
+func UpdateAttributeDefinition(c *gin.Context) {
+	definitionID := c.Param("id")
+	var definition *models.AttributeDefinition
+
+	if err := config.DB.First(&definition, definitionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attribute definition not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	previousType := definition.Type
+	if err := c.ShouldBindJSON(&definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if definition.Type != previousType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the type of an attribute cannot be changed"})
+		return
+	}
+
+	if err := config.DB.Save(&definition).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, definition)
+}
+
+// DeleteAttributeDefinition deletes an attribute definition and the product values using it
+func DeleteAttributeDefinition(c *gin.Context) {
+	definitionID := c.Param("id")
+	var definition *models.AttributeDefinition
+
+	if err := config.DB.First(&definition, definitionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attribute definition not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	err := config.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("definition_id = ?", definition.ID).Delete(&models.ProductAttribute{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&definition).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attribute definition deleted successfully"})
+}
+
+// attributeFilters turns attr[code]=value query parameters into product conditions. Numbers take a value or
+// a min..max range with either side optional, booleans true or false, text and enums comma separated values.
+func attributeFilters(c *gin.Context) (func(*gorm.DB) *gorm.DB, error) {
+	filters := c.QueryMap("attr")
+	codes := make([]string, 0, len(filters))
+	for code := range filters {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	type condition struct {
+		sql  string
+		args []interface{}
+	}
+	var conditions []condition
+
+	for _, code := range codes {
+		raw := strings.TrimSpace(filters[code])
+		if raw == "" {
+			continue
+		}
+
+		var definition models.AttributeDefinition
+		if err := config.DB.Where("code = ? AND filterable = true", code).First(&definition).Error; err != nil {
+			return nil, fmt.Errorf("unknown attribute filter %q", code)
+		}
+
+		exists := `EXISTS (SELECT 1 FROM product_attributes
+				JOIN attribute_definitions ON attribute_definitions.id = product_attributes.definition_id
+				WHERE product_attributes.product_id = products.id AND product_attributes.deleted_at IS NULL
+				AND attribute_definitions.code = ? AND `
+		args := []interface{}{code}
+
+		switch definition.Type {
+		case "number":
+			from, to, isRange := strings.Cut(raw, "..")
+			if !isRange {
+				to = from
+			}
+			var parts []string
+			for _, bound := range []struct {
+				value string
+				op    string
+			}{{from, ">="}, {to, "<="}} {
+				if bound.value == "" {
+					continue
+				}
+				value, err := strconv.ParseFloat(bound.value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("attribute filter %q must be a number or a min..max range", code)
+				}
+				parts = append(parts, "product_attributes.number_value "+bound.op+" ?")
+				args = append(args, value)
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			exists += strings.Join(parts, " AND ")
+		case "boolean":
+			value, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("attribute filter %q must be true or false", code)
+			}
+			exists += "product_attributes.boolean_value = ?"
+			args = append(args, value)
+		default:
+			var values []string
+			for _, value := range strings.Split(raw, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, strings.ToLower(value))
+				}
+			}
+			exists += "LOWER(product_attributes.text_value) IN ?"
+			args = append(args, values)
+		}
+
+		conditions = append(conditions, condition{sql: exists + ")", args: args})
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		for _, condition := range conditions {
+			db = db.Where(condition.sql, condition.args...)
+		}
+		return db
+	}, nil
+}
+
+// CompareProducts lines up the attributes of up to four products, ?ids=1,2,3
+func CompareProducts(c *gin.Context) {
+	var ids []uint
+	for _, value := range strings.Split(c.Query("ids"), ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids must be a comma separated list of product IDs"})
+			return
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(ids) < 2 || len(ids) > 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compare between 2 and 4 products"})
+		return
+	}
+
+	var cards []*productCard
+	if err := productCardQuery().Where("products.id IN ?", ids).Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byID := map[uint]*productCard{}
+	for _, card := range cards {
+		byID[card.ID] = card
+	}
+	products := make([]*productCard, 0, len(ids))
+	for _, id := range ids {
+		if card := byID[id]; card != nil {
+			products = append(products, card)
+		}
+	}
+	if len(products) != len(ids) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var attributes []*models.ProductAttribute
+	if err := config.DB.Preload("Definition").Where("product_id IN ?", ids).Order("id").Find(&attributes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type Row struct {
+		Code    *string
+		Name    string
+		Unit    *string
+		Values  []*string // Display value per product, in the order of Products
+		Differs bool
+
+		position int
+	}
+
+	column := map[uint]int{}
+	for i, product := range products {
+		column[product.ID] = i
+	}
+
+	rows := map[string]*Row{}
+	var keys []string
+	for _, attribute := range attributes {
+		// Structured attributes line up by code, free text ones by name
+		key := "name:" + strings.ToLower(attribute.Name)
+		row := &Row{Name: attribute.Name, position: 1 << 30}
+		if attribute.Definition != nil {
+			key = "code:" + attribute.Definition.Code
+			row = &Row{Code: &attribute.Definition.Code, Name: attribute.Definition.Name, Unit: attribute.Definition.Unit, position: attribute.Definition.Position}
+		}
+
+		if rows[key] == nil {
+			row.Values = make([]*string, len(products))
+			rows[key] = row
+			keys = append(keys, key)
+		}
+		value := attribute.Description
+		rows[key].Values[column[attribute.ProductID]] = &value
+	}
+
+	result := make([]*Row, 0, len(keys))
+	for _, key := range keys {
+		row := rows[key]
+		for _, value := range row.Values[1:] {
+			if (value == nil) != (row.Values[0] == nil) || (value != nil && *value != *row.Values[0]) {
+				row.Differs = true
+			}
+		}
+		result = append(result, row)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].position < result[j].position })
+
+	c.JSON(http.StatusOK, gin.H{"Products": products, "Attributes": result})
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"backend/catalog"
+	"backend/config"
+	"backend/models"
+	"backend/promotions"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// GetCampaigns lists the campaigns, newest first
+func GetCampaigns(c *gin.Context) {
+	model := config.DB.Model(&models.Campaign{}).Preload("Scopes").Order("created_at DESC")
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&[]models.Campaign{})
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// GetCampaign returns a campaign with its redemption statistics
+func GetCampaign(c *gin.Context) {
+	var campaign models.Campaign
+	if err := config.DB.Preload("Scopes").First(&campaign, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	stats, err := promotions.GetCampaignStats(config.DB, campaign.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaign": campaign, "stats": stats})
+}
+
+// CreateCampaign creates a campaign with its discount rules, codes are generated separately
+func CreateCampaign(c *gin.Context) {
+	var campaign models.Campaign
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range campaign.Scopes {
+		campaign.Scopes[i].ID, campaign.Scopes[i].CouponID, campaign.Scopes[i].PromotionID = 0, nil, nil
+	}
+	if err := config.DB.Create(&campaign).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create campaign", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// UpdateCampaign changes the rules of a campaign, which apply to all its codes. Scopes replace the
+// existing scopes when given.
+func UpdateCampaign(c *gin.Context) {
+	var campaign models.Campaign
+	if err := config.DB.Preload("Scopes").First(&campaign, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	if err := c.ShouldBindJSON(&campaign); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Scopes").Save(&campaign).Error; err != nil {
+			return err
+		}
+		return replaceScopes(tx, "campaign_id", campaign.ID, campaign.Scopes)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Campaign updated"})
+}
+
+// DeleteCampaign deletes a campaign, its codes stop working
+func DeleteCampaign(c *gin.Context) {
+	if err := config.DB.Delete(&models.Campaign{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete campaign"})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{"message": "Campaign deleted"})
+}
+
+// GenerateCampaignCodes creates Count unique codes for a campaign. In the Pattern X stands for a letter or
+// digit and # for a digit, e.g. Prefix "SUMMER-" with Pattern "XXXX-XXXX".
+func GenerateCampaignCodes(c *gin.Context) {
+	var campaign models.Campaign
+	if err := config.DB.First(&campaign, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	var payload struct {
+		Count   int `binding:"required,min=1,max=50000"`
+		Prefix  string
+		Pattern string
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := promotions.GenerateCampaignCodes(config.DB, campaign.ID, payload.Count, payload.Prefix, payload.Pattern)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, utils.ErrInvalidCodePattern) || errors.Is(err, promotions.ErrCodeSpaceTooSmall) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Codes generated", "count": payload.Count})
+}
+
+// campaignCodesQuery selects the codes of a campaign, optionally only the unused, redeemed or revoked ones
+func campaignCodesQuery(c *gin.Context) (*gorm.DB, bool) {
+	query := config.DB.Model(&models.CampaignCode{}).Where("campaign_id = ?", c.Param("id"))
+	switch c.Query("status") {
+	case "":
+	case "unused":
+		query = query.Where("redeemed_at IS NULL AND revoked_at IS NULL")
+	case "redeemed":
+		query = query.Where("redeemed_at IS NOT NULL")
+	case "revoked":
+		query = query.Where("revoked_at IS NOT NULL AND redeemed_at IS NULL")
+	default:
+		return nil, false
+	}
+	return query.Order("id"), true
+}
+
+// GetCampaignCodes lists the codes of a campaign, ?status=unused|redeemed|revoked filters them
+func GetCampaignCodes(c *gin.Context) {
+	model, ok := campaignCodesQuery(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be unused, redeemed or revoked"})
+		return
+	}
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&[]models.CampaignCode{})
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// ExportCampaignCodes downloads the codes of a campaign as csv (default) or xlsx, ?status filters them
+// like GetCampaignCodes
+func ExportCampaignCodes(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+
+	contentTypes := map[string]string{
+		"csv":  "text/csv",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": catalog.ErrUnsupportedFormat.Error()})
+		return
+	}
+
+	var campaign models.Campaign
+	if err := config.DB.First(&campaign, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+
+	query, ok := campaignCodesQuery(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be unused, redeemed or revoked"})
+		return
+	}
+	var codes []models.CampaignCode
+	if err := query.Find(&codes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows := [][]string{{"code", "status", "redeemed_at", "order_id"}}
+	for _, code := range codes {
+		redeemedAt, orderID := "", ""
+		if code.RedeemedAt != nil {
+			redeemedAt = code.RedeemedAt.Format(time.RFC3339)
+		}
+		if code.OrderID != nil {
+			orderID = strconv.FormatUint(uint64(*code.OrderID), 10)
+		}
+		rows = append(rows, []string{code.Code, code.Status(), redeemedAt, orderID})
+	}
+
+	fileName := "campaign-" + strconv.FormatUint(uint64(campaign.ID), 10) + "-codes." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	if err := catalog.WriteSheet(c.Writer, format, rows); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RevokeCampaignCode stops a single code of a campaign from being used, redeemed codes cannot be revoked
+func RevokeCampaignCode(c *gin.Context) {
+	var code models.CampaignCode
+	if err := config.DB.Where("campaign_id = ?", c.Param("id")).First(&code, c.Param("code_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+
+	// The condition keeps a code that is redeemed at the same time from being revoked
+	now := time.Now()
+	result := config.DB.Model(&code).Where("redeemed_at IS NULL").Update("revoked_at", gorm.Expr("COALESCE(revoked_at, ?)", now))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Code has already been redeemed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code revoked"})
+}
@@ -1,44 +1,82 @@
 package controllers
 
 import (
+	"backend/carts"
 	"backend/config"
 	"backend/models"
+	"errors"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// CreateShoppingCart creates a new shopping cart for a user
-func CreateShoppingCart(c *gin.Context) {
-	var shoppingCart *models.ShoppingCart
+// currentCart returns the cart of the signed in user, or the anonymous cart of the cart cookie or
+// X-Cart-ID header. With create a missing cart is made, an anonymous one is handed to the client in the
+// cookie and the header.
+func currentCart(c *gin.Context, create bool) (*models.ShoppingCart, error) {
+	if userID := c.GetUint("user_id"); userID != 0 {
+		if create {
+			return carts.ForUser(config.DB, userID)
+		}
+		var cart models.ShoppingCart
+		return &cart, config.DB.Where("user_id = ?", userID).First(&cart).Error
+	}
 
-	if err := c.BindJSON(&shoppingCart); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	if id, ok := requestCartID(c); ok {
+		cart, err := carts.Guest(config.DB, id)
+		if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) || !create {
+			return cart, err
+		}
+	}
+	if !create {
+		return nil, gorm.ErrRecordNotFound
 	}
+	cart, err := carts.NewGuest(config.DB)
+	if err != nil {
+		return nil, err
+	}
+	c.SetCookie(carts.CookieName, cart.UUID.String(), int(carts.GuestTTL().Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Header(carts.Header, cart.UUID.String())
+	return cart, nil
+}
 
-	// Ensure UUID is generated
-	shoppingCart.UUID = uuid.New()
+// requestCartID reads the UUID of an anonymous cart from the X-Cart-ID header or the cart cookie
+func requestCartID(c *gin.Context) (uuid.UUID, bool) {
+	value := c.GetHeader(carts.Header)
+	if value == "" {
+		value, _ = c.Cookie(carts.CookieName)
+	}
+	id, err := uuid.Parse(value)
+	return id, err == nil
+}
 
-	// Save the shopping cart to the database
-	if err := config.DB.Create(&shoppingCart).Error; err != nil {
+// CreateShoppingCart returns the cart of the user or visitor, creating it when they have none
+func CreateShoppingCart(c *gin.Context) {
+	cart, err := currentCart(c, true)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "shopping cart created successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "shopping cart created successfully", "cart_id": cart.UUID})
 }
 
-// GetShoppingCartByUserID retrieves the shopping cart by user ID and includes its items
+// GetShoppingCartByUserID retrieves the shopping cart of the user or visitor with its items priced now:
+// line totals, automatic promotions, estimated shipping for the payment_method query parameter (the
+// cheapest option without one), tax and the total, and warnings about lines that cannot be ordered as they
+// are
 func GetShoppingCartByUserID(c *gin.Context) {
-	userID := c.GetUint("user_id")
-	var shoppingCart *models.ShoppingCart
-
-	// Use Preload to load associated CartItems
-	if err := config.DB.Where("user_id = ?", userID).Preload("CartItems").Preload("CartItems.Product").First(&shoppingCart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	cart, err := currentCart(c, false)
+	if err == nil {
+		err = config.DB.Preload("CartItems").Preload("CartItems.Product").First(cart).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Shopping cart not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -46,7 +84,13 @@ func GetShoppingCartByUserID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, shoppingCart)
+	summary, err := carts.Summarize(config.DB, cart, c.Query("payment_method"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
 }
 
 func GetWishlistByUserID(c *gin.Context) {
@@ -63,16 +107,24 @@ func GetWishlistByUserID(c *gin.Context) {
 		return
 	}
 
+	// Show which items have price drop alerts
+	var alerted []uint
+	if err := config.DB.Model(&models.ProductAlert{}).Where("user_id = ? AND kind = 'price_drop'", userID).Pluck("product_id", &alerted).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, item := range wishList {
+		item.PriceAlert = slices.Contains(alerted, item.ProductID)
+	}
+
 	c.JSON(http.StatusOK, wishList)
 }
 
-// DeleteShoppingCart deletes a shopping cart by UUID
+// DeleteShoppingCart deletes the cart of the user or visitor by UUID
 func DeleteShoppingCart(c *gin.Context) {
-	cartUUID := c.Param("uuid")
-	var shoppingCart *models.ShoppingCart
-
-	if err := config.DB.Where("uuid = ?", cartUUID).First(&shoppingCart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	cart, err := currentCart(c, false)
+	if err != nil || cart.UUID.String() != c.Param("uuid") {
+		if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Shopping cart not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -80,7 +132,13 @@ func DeleteShoppingCart(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Delete(&shoppingCart).Error; err != nil {
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_id = ?", cart.UUID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(cart).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -100,11 +158,31 @@ func ClearWishlist(c *gin.Context) {
 		}
 		return
 	}
+	// Price drop alerts only exist for wishlist items
+	if err := config.DB.Where("user_id = ? AND kind = 'price_drop'", userID).Delete(&models.ProductAlert{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "wish-list cleared successfully"})
 }
 
-// CreateCartItem adds a new item to the cart
+// checkCartQuantity responds with an error when less than quantity of a product is available
+func checkCartQuantity(c *gin.Context, productID uint, quantity int) bool {
+	available, err := carts.Available(config.DB, []uint{productID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if quantity > available[productID] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough stock", "available": max(available[productID], 0)})
+		return false
+	}
+	return true
+}
+
+// AddCartItem adds a product to the cart of the user or visitor, creating the cart when needed. A product
+// already in the cart has the quantity added to its line.
 func AddCartItem(c *gin.Context) {
 	var cartItem *models.CartItem
 
@@ -113,14 +191,62 @@ func AddCartItem(c *gin.Context) {
 		return
 	}
 
-	// Check if UUID is valid
-	if cartItem.CartID == uuid.Nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Cart ID"})
+	if cartItem.ProductID == 0 || cartItem.Quantity < 1 || cartItem.Quantity > maxItemQuantity {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ProductID and a Quantity between 1 and 1000 are required"})
 		return
 	}
 
-	// Save CartItem to the database
-	if err := config.DB.Create(&cartItem).Error; err != nil {
+	items := []models.OrderItem{{ProductID: cartItem.ProductID, Quantity: cartItem.Quantity}}
+	if err := priceOrderItems(items, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := currentCart(c, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	price := items[0].PriceAtPurchase
+	productID, added := cartItem.ProductID, cartItem.Quantity
+	available := 0
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Locking the cart serialises concurrent adds to it, so the stock check holds until the line is written
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("uuid").Where("uuid = ?", cart.UUID).First(&models.ShoppingCart{}).Error; err != nil {
+			return err
+		}
+		var existing models.CartItem
+		if err := tx.Where("cart_id = ? AND product_id = ?", cart.UUID, productID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		stock, err := carts.Available(tx, []uint{productID})
+		if err != nil {
+			return err
+		}
+		if existing.Quantity+added > stock[productID] {
+			available = max(stock[productID], 0)
+			return errNotEnoughStock
+		}
+
+		// The price when the product was first added stays, so the cart can warn about changes since
+		*cartItem = models.CartItem{CartID: cart.UUID, ProductID: productID, Quantity: added, PriceAtAdd: &price}
+		if err := tx.Omit("Cart", "Product").Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "cart_id"}, {Name: "product_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("cart_items.quantity + ?", added)}),
+		}).Create(cartItem).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("cart_id = ? AND product_id = ?", cart.UUID, productID).First(cartItem).Error; err != nil {
+			return err
+		}
+		return carts.Touch(tx, cart)
+	})
+	if errors.Is(err, errNotEnoughStock) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough stock", "available": available})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -128,6 +254,66 @@ func AddCartItem(c *gin.Context) {
 	c.JSON(http.StatusOK, cartItem)
 }
 
+// RestoreShoppingCart brings back the cart of an abandoned cart email link into the cart of the user or
+// visitor, e.g. on another device, and returns it with the coupon code of the email if any
+func RestoreShoppingCart(c *gin.Context) {
+	id, ok := carts.RecoveryID(c.Param("token"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid cart link"})
+		return
+	}
+
+	cart, err := currentCart(c, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recovery, err := carts.Restore(config.DB, id, cart)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid cart link"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := config.DB.Preload("CartItems").Preload("CartItems.Product").First(cart).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	summary, err := carts.Summarize(config.DB, cart, c.Query("payment_method"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"cart": summary}
+	if recovery.CampaignCode != nil && recovery.CampaignCode.RedeemedAt == nil && recovery.CampaignCode.RevokedAt == nil {
+		response["coupon_code"] = recovery.CampaignCode.Code
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// findCartItem looks up an item of the cart of the user or visitor, responding with an error when it is not
+// found
+func findCartItem(c *gin.Context) (*models.ShoppingCart, *models.CartItem, bool) {
+	cart, err := currentCart(c, false)
+	var cartItem models.CartItem
+	if err == nil {
+		err = config.DB.Where("cart_id = ?", cart.UUID).First(&cartItem, c.Param("id")).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "CartItem not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, nil, false
+	}
+	return cart, &cartItem, true
+}
+
 func AddWishlistItem(c *gin.Context) {
 	var wishlistItem struct {
 		ProductID uint `gorm:"not null"`
@@ -154,20 +340,28 @@ func AddWishlistItem(c *gin.Context) {
 
 // UpdateCartItem updates the quantity of a cart item
 func UpdateCartItem(c *gin.Context) {
-	var cartItem *models.CartItem
-	cartItemID := c.Param("id")
-
-	if err := config.DB.First(&cartItem, cartItemID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "CartItem not found"})
+	cart, cartItem, ok := findCartItem(c)
+	if !ok {
 		return
 	}
 
-	if err := c.BindJSON(&cartItem); err != nil {
+	var payload struct {
+		Quantity int `binding:"required,min=1,max=1000"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !checkCartQuantity(c, cartItem.ProductID, payload.Quantity) {
+		return
+	}
+	cartItem.Quantity = payload.Quantity
 
-	if err := config.DB.Save(&cartItem).Error; err != nil {
+	if err := config.DB.Model(cartItem).Update("quantity", cartItem.Quantity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := carts.Touch(config.DB, cart); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -175,17 +369,18 @@ func UpdateCartItem(c *gin.Context) {
 	c.JSON(http.StatusOK, cartItem)
 }
 
-// DeleteCartItem deletes a cart item by ID
+// RemoveCartItem deletes an item of the cart by ID
 func RemoveCartItem(c *gin.Context) {
-	cartItemID := c.Param("id")
-	var cartItem *models.CartItem
-
-	if err := config.DB.First(&cartItem, cartItemID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "CartItem not found"})
+	cart, cartItem, ok := findCartItem(c)
+	if !ok {
 		return
 	}
 
-	if err := config.DB.Delete(&cartItem).Error; err != nil {
+	if err := config.DB.Delete(cartItem).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := carts.Touch(config.DB, cart); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -206,6 +401,13 @@ func RemoveWishlistItem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// Unless the product is still on the wishlist, its price drop alert goes with it
+	if err := config.DB.Where("user_id = ? AND product_id = ? AND kind = 'price_drop'", wishlistItem.UserID, wishlistItem.ProductID).
+		Where("NOT EXISTS (SELECT 1 FROM wish_lists WHERE wish_lists.user_id = product_alerts.user_id AND wish_lists.product_id = product_alerts.product_id)").
+		Delete(&models.ProductAlert{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "item removed successfully"})
 }
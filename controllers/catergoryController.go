@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"backend/config"
+	"backend/media"
 	"backend/models"
 	"errors"
 	"net/http"
@@ -21,15 +22,26 @@ func CreateCategory(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Create(&category).Error; err != nil {
-		if errors.Is(err, gorm.ErrCheckConstraintViolated) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "CategoryType must be in ['parent', 'child']"})
+	// The upload is stored first and removed again when the category cannot be written
+	if category.Image != nil {
+		if err := category.Image.StoreUpload(c.Request.Context()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+
+	if err := config.DB.WithContext(c).Create(&category).Error; err != nil {
+		if category.Image != nil {
+			removeStoredFiles(c, []string{category.Image.StorageKey})
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if category.Image != nil {
+		media.QueueRenditions()
+	}
+
 	// Return the newly created category
 	c.JSON(http.StatusCreated, gin.H{"message": "category added successfully"})
 	// Insert the category into the database
@@ -52,7 +64,7 @@ func GetCategories(c *gin.Context) {
 	}
 
 	// Use Preload to load associated Products for each category
-	if err := config.DB.Preload("Products").Preload("Image").Where(querystring).Find(&categories).Error; err != nil {
+	if err := config.DB.Preload("Products").Preload("Image").Where(querystring).Order("depth, sort_order, name").Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -61,50 +73,54 @@ func GetCategories(c *gin.Context) {
 	c.JSON(http.StatusOK, categories)
 }
 
+// GetNestedCategories returns the whole category tree with product counts that include subcategories
 func GetNestedCategories(c *gin.Context) {
 
 	type Category struct {
-		ID       uint        `json:"id"`
-		Name     string      `json:"name"`
-		ParentID *uint       `json:"parent_id"` // Nullable for top-level categories
-		Level    int         `json:"level"`
-		Path     []int       `json:"-"` // Path to use for ordering; ignore in JSON
-		Children []*Category `json:"children,omitempty"`
+		ID           uint        `json:"id"`
+		Name         string      `json:"name"`
+		Slug         *string     `json:"slug"`
+		ParentID     *uint       `json:"parent_id"` // Nullable for top-level categories
+		Level        int         `json:"level"`
+		SortOrder    int         `json:"sort_order"`
+		ProductCount int         `json:"product_count"`
+		Children     []*Category `json:"children,omitempty"`
 	}
 	var categories []*Category
 
-	query := `
-		WITH RECURSIVE category_hierarchy AS (
-			SELECT id, name, parent_id, 1 AS level, ARRAY[id] AS path
-			FROM categories
-			WHERE parent_id IS NULL
-			UNION ALL
-			SELECT c.id, c.name, c.parent_id, ch.level + 1, ch.path || c.id
-			FROM categories c
-			JOIN category_hierarchy ch ON c.parent_id = ch.id
-		)
-		SELECT id, name, parent_id, level, path
-		FROM category_hierarchy
-		ORDER BY path;`
-
-	if err := config.DB.Raw(query).Scan(&categories).Error; err != nil {
+	if err := config.DB.Model(&models.Category{}).
+		Select("id, name, slug, parent_id, depth + 1 AS level, sort_order").
+		Order("depth, sort_order, name, id").
+		Scan(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	counts, err := models.CategoryProductCounts(config.DB)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	buildCategoryTree := func(categories []*Category) []*Category {
 		categoryMap := make(map[uint]*Category)
-		var rootCategories []*Category
+		rootCategories := []*Category{}
 
-		for i := range categories {
-			category := categories[i]           // Current category
-			categoryMap[category.ID] = category // Store in map
+		for _, category := range categories {
+			category.ProductCount = counts[category.ID]
+			categoryMap[category.ID] = category
+		}
 
-			if category.ParentID == nil {
-				rootCategories = append(rootCategories, category) // Add to root if no parent
+		// Link in a second pass so children never have to come after their parents
+		for _, category := range categories {
+			var parent *Category
+			if category.ParentID != nil {
+				parent = categoryMap[*category.ParentID]
+			}
+			if parent == nil {
+				rootCategories = append(rootCategories, category)
 			} else {
-				parent := categoryMap[*category.ParentID]
-				parent.Children = append(parent.Children, category) // Append to parent’s children
+				parent.Children = append(parent.Children, category)
 			}
 		}
 
@@ -119,7 +135,7 @@ func GetSubCategories(c *gin.Context) {
 	var categories []*models.Category
 
 	// Use Preload to load associated Products for each category
-	if err := config.DB.Preload("Products").Where("parent_id = ?", parentID).Find(&categories).Error; err != nil {
+	if err := config.DB.Preload("Products").Where("parent_id = ?", parentID).Order("sort_order, name").Find(&categories).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -128,14 +144,17 @@ func GetSubCategories(c *gin.Context) {
 	c.JSON(http.StatusOK, categories)
 }
 
-// GetCategory retrieves a single category by its ID
+// GetCategory retrieves a single category by its ID or slug
 func GetCategory(c *gin.Context) {
 	categoryID := c.Param("id")
 	var category *models.Category
 
 	// Use Preload to load associated Products for this category
-	if err := config.DB.Preload("Products").First(&category, categoryID).Error; err != nil {
+	if err := config.DB.Preload("Products").Where(idOrSlugColumn("categories", categoryID)+" = ?", categoryID).First(&category).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
+			if redirectOldSlug(c, "category", "categories", categoryID) {
+				return
+			}
 			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -143,6 +162,20 @@ func GetCategory(c *gin.Context) {
 		return
 	}
 
+	counts, err := models.CategoryProductCounts(config.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	category.ProductCount = counts[category.ID]
+	if category.Breadcrumbs, err = models.CategoryBreadcrumbs(config.DB, category.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	category.MetaTitle = metaTitle(category.MetaTitle, category.Name.String)
+	category.CanonicalURL = canonicalURL(category.CanonicalURL, "categories", category.Slug)
+
 	// Return the category
 	c.JSON(http.StatusOK, category)
 }
@@ -169,17 +202,117 @@ func UpdateCategory(c *gin.Context) {
 		return
 	}
 
+	// An existing image is left as it is, a new base64 image is stored first and replaces it
+	upload := category.Image
+	if upload != nil && (upload.ID != 0 || upload.Image == "") {
+		upload = nil
+	}
+	var previous models.CategoryImage
+	if upload != nil {
+		if err := upload.StoreUpload(c.Request.Context()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		upload.CategoryID = category.ID
+		if err := config.DB.Where("category_id = ?", category.ID).Limit(1).Find(&previous).Error; err != nil {
+			removeStoredFiles(c, []string{upload.StorageKey})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Save the updated category
-	if err := config.DB.Save(&category).Error; err != nil {
+	err := config.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Image").Save(&category).Error; err != nil {
+			return err
+		}
+		if upload == nil {
+			return nil
+		}
+		if previous.ID != 0 {
+			if err := tx.Delete(&previous).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(upload).Error
+	})
+	if err != nil {
+		if upload != nil {
+			removeStoredFiles(c, []string{upload.StorageKey})
+		}
+		if errors.Is(err, models.ErrCategoryCycle) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if upload != nil {
+		if previous.ID != 0 {
+			removeStoredFiles(c, append(previous.Renditions.StorageKeys(), previous.StorageKey))
+		}
+		media.QueueRenditions()
+	}
 
 	// Return the updated category
 	c.JSON(http.StatusOK, category)
 }
 
-// DeleteCategory deletes a category by its ID
+// MoveCategory moves a category, with its subcategories, below another category or to the root
+func MoveCategory(c *gin.Context) {
+	categoryID := c.Param("id")
+	var category *models.Category
+
+	if err := config.DB.First(&category, categoryID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	var payload struct {
+		ParentID  *uint // Empty to move to the root
+		SortOrder *int
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.MoveCategory(config.DB.WithContext(c), category, payload.ParentID, payload.SortOrder); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, category)
+}
+
+// ReorderCategories sets the order of sibling categories to the order of the given IDs
+func ReorderCategories(c *gin.Context) {
+	var payload struct {
+		ParentID *uint
+		IDs      []uint `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return models.ReorderCategories(tx, payload.ParentID, payload.IDs)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "categories reordered"})
+}
+
+// DeleteCategory deletes a category by its ID. A category with subcategories or products can only be
+// deleted with ?reassign_to=<category id>, which moves them to that category first.
 func DeleteCategory(c *gin.Context) {
 
 	categoryID := c.Param("id")
@@ -195,8 +328,60 @@ func DeleteCategory(c *gin.Context) {
 		return
 	}
 
-	// Delete the category from the database
-	if err := config.DB.Delete(&category).Error; err != nil {
+	var children []*models.Category
+	var products int64
+	if err := config.DB.Where("parent_id = ?", category.ID).Find(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target *models.Category
+	if reassignTo := c.Query("reassign_to"); reassignTo != "" {
+		if err := config.DB.First(&target, reassignTo).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "category to reassign to not found"})
+			return
+		}
+		descendants, err := models.CategoryDescendantIDs(config.DB, category.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, id := range descendants {
+			if id == target.ID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cannot reassign to the deleted category or one of its subcategories"})
+				return
+			}
+		}
+	} else if len(children) > 0 || products > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "category has subcategories or products, pass reassign_to to move them",
+			"subcategories": len(children),
+			"products":      products,
+		})
+		return
+	}
+
+	err := config.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if target != nil {
+			for _, child := range children {
+				if err := models.MoveCategory(tx, child, &target.ID, nil); err != nil {
+					return err
+				}
+			}
+			// Variations follow their parent, so every row of the category moves
+			if err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).UpdateColumn("category_id", target.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		// Delete the category from the database
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"backend/config"
+	"backend/media"
 	"backend/models"
 	"net/http"
 
@@ -18,12 +19,26 @@ func AddBannerImages(c *gin.Context) {
 		return
 	}
 
+	// Uploads are stored first and removed again when the rows cannot be written
+	var keys []string
+	for _, image := range content {
+		if err := image.StoreUpload(c.Request.Context()); err != nil {
+			removeStoredFiles(c, keys)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		keys = append(keys, image.StorageKey)
+	}
+
 	// Insert the category into the database
 	if err := config.DB.Create(&content).Error; err != nil {
+		removeStoredFiles(c, keys)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	media.QueueRenditions()
+
 	// Return the newly created category
 	c.JSON(http.StatusCreated, gin.H{"message": "content added successfully"})
 }
@@ -41,7 +56,7 @@ func GetBannerImages(c *gin.Context) {
 		for _, image := range content {
 			switch image.Position {
 			case "banner":
-				response["banner"] = append(response["banner"].([]string), image.Image)
+				response["banner"] = append(response["banner"].([]string), image.URL)
 			}
 
 		}
@@ -67,7 +82,7 @@ func GetDashboardBannerImages(c *gin.Context) {
 
 				response = append(response, Dto{
 					ID:    image.ID,
-					Image: image.Image,
+					Image: image.URL,
 				})
 			}
 
@@ -78,9 +93,17 @@ func GetDashboardBannerImages(c *gin.Context) {
 
 func DeleteBannerImage(c *gin.Context) {
 	id := c.Param("id")
-	if err := config.DB.Delete(&models.ContentImage{}, id).Error; err != nil {
+	var content *models.ContentImage
+
+	if err := config.DB.First(&content, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+		return
+	}
+
+	if err := config.DB.Delete(&content).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete content"})
 		return
 	}
+	removeStoredFiles(c, append(content.Renditions.StorageKeys(), content.StorageKey))
 	c.JSON(http.StatusNoContent, gin.H{"message": "Content deleted"})
 }
@@ -3,16 +3,18 @@ package controllers
 import (
 	"backend/config"
 	"backend/models"
+	"backend/promotions"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // Get all coupons
 func GetCoupons(c *gin.Context) {
 	var coupons []models.Coupon
-	if err := config.DB.Find(&coupons).Error; err != nil {
+	if err := config.DB.Preload("Scopes").Order("priority DESC, id").Find(&coupons).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
 		return
 	}
@@ -23,7 +25,7 @@ func GetCoupons(c *gin.Context) {
 func GetCoupon(c *gin.Context) {
 	id := c.Param("id")
 	var coupon models.Coupon
-	if err := config.DB.First(&coupon, id).Error; err != nil {
+	if err := config.DB.Preload("Scopes").First(&coupon, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
 		return
 	}
@@ -38,17 +40,17 @@ func CreateCoupon(c *gin.Context) {
 		return
 	}
 	if err := config.DB.Create(&coupon).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create coupon", "message": err.Error()})
 		return
 	}
 	c.JSON(http.StatusCreated, coupon)
 }
 
-// Update a coupon
+// Update a coupon, Scopes replace the existing scopes when given
 func UpdateCoupon(c *gin.Context) {
 	id := c.Param("id")
 	var coupon models.Coupon
-	if err := config.DB.First(&coupon, id).Error; err != nil {
+	if err := config.DB.Preload("Scopes").First(&coupon, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
 		return
 	}
@@ -56,13 +58,44 @@ func UpdateCoupon(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := config.DB.Save(&coupon).Error; err != nil {
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Scopes").Save(&coupon).Error; err != nil {
+			return err
+		}
+		return replaceScopes(tx, "coupon_id", coupon.ID, coupon.Scopes)
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Coupon updated"})
 }
 
+// replaceScopes swaps the scopes of a coupon, campaign or promotion, column is coupon_id, campaign_id or
+// promotion_id
+func replaceScopes(tx *gorm.DB, column string, id uint, scopes []models.CouponScope) error {
+	if err := tx.Where(column+" = ?", id).Delete(&models.CouponScope{}).Error; err != nil {
+		return err
+	}
+	for i := range scopes {
+		scopes[i].ID = 0
+		scopes[i].CouponID, scopes[i].CampaignID, scopes[i].PromotionID = nil, nil, nil
+		switch column {
+		case "campaign_id":
+			scopes[i].CampaignID = &id
+		case "promotion_id":
+			scopes[i].PromotionID = &id
+		default:
+			scopes[i].CouponID = &id
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	return tx.Create(&scopes).Error
+}
+
 // Delete a coupon
 func DeleteCoupon(c *gin.Context) {
 	id := c.Param("id")
@@ -73,46 +106,78 @@ func DeleteCoupon(c *gin.Context) {
 	c.JSON(http.StatusNoContent, gin.H{"message": "Coupon deleted"})
 }
 
-// Apply a coupon
-func ApplyCoupon(c *gin.Context, CouponCode string, userID uint) *models.Coupon {
+// ValidateCoupon previews the coupon codes on a cart without using them up. It returns whether every
+// code applies, the discount per line and the reasons codes were rejected.
+func ValidateCoupon(c *gin.Context) {
+	var payload struct {
+		Code          string
+		Codes         []string
+		PaymentMethod *string // Used to estimate the shipping cost for free shipping coupons
+		Items         []struct {
+			ProductID uint `binding:"required"`
+			Quantity  int  `binding:"required,min=1,max=1000"`
+		} `binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	var coupon models.Coupon
-	if err := config.DB.Where("code = ? AND is_active = true", CouponCode).First(&coupon).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found or inactive"})
-		return nil
+	order := models.Order{Coupon: payload.Code, Coupons: payload.Codes}
+	codes := order.CouponCodes()
+	if len(codes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
 	}
 
-	// Check if coupon has expired
-	if coupon.ExpirationDate != nil && time.Now().After(*coupon.ExpirationDate) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon has expired"})
-		return nil
+	items := make([]models.OrderItem, len(payload.Items))
+	for i, item := range payload.Items {
+		items[i] = models.OrderItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	if err := checkOrderItems(items); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	if err := priceOrderItems(items, now); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Check usage limits
-	var usageCount int64
-	config.DB.Model(&models.CouponUsageHistory{}).Where("coupon_id = ?", coupon.ID).Count(&usageCount)
-	if coupon.UsageLimit != nil && usageCount >= int64(*coupon.UsageLimit) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon usage limit reached"})
-		return nil
+	shipping := 0.0
+	if payload.PaymentMethod != nil {
+		var option models.ShippingOptions
+		if err := config.DB.Where("payment_method = ?", *payload.PaymentMethod).First(&option).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment method"})
+			return
+		}
+		shipping = option.ShippingCost
 	}
 
-	var userUsageCount int64
-	config.DB.Model(&models.CouponUsageHistory{}).Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).Count(&userUsageCount)
-	if userUsageCount >= int64(coupon.UsageLimitPerUser) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User coupon usage limit reached"})
-		return nil
+	var userID *uint
+	if id := c.GetUint("user_id"); id != 0 {
+		userID = &id
 	}
 
-	// Log coupon usage
-	usageHistory := models.CouponUsageHistory{
-		CouponID: coupon.ID,
-		UserID:   userID,
-		UsedAt:   time.Now(),
+	pricing, err := evaluateOrderItems(items, promotions.Cart{UserID: userID, Shipping: shipping}, codes, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	if err := config.DB.Create(&usageHistory).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log coupon usage"})
-		return nil
+
+	reasons := make([]string, len(pricing.Rejected))
+	for i, rejection := range pricing.Rejected {
+		reasons[i] = rejection.Code + ": " + rejection.Reason
 	}
 
-	return &coupon
+	c.JSON(http.StatusOK, gin.H{
+		"valid":           len(pricing.Rejected) == 0,
+		"subtotal":        pricing.Subtotal,
+		"discount_amount": pricing.DiscountAmount,
+		"shipping":        pricing.Shipping,
+		"total":           pricing.Total,
+		"discounts":       pricing.Discounts,
+		"rejected":        pricing.Rejected,
+		"reasons":         reasons,
+	})
 }
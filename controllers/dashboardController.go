@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"backend/carts"
 	"backend/config"
 	"backend/models"
 	"net/http"
@@ -67,13 +68,15 @@ func GetTopSellingProducts(c *gin.Context) {
 // GetMonthlySales returns total sales for each month of the current year
 func GetMonthlySales(c *gin.Context) {
 	var monthlySales struct {
-		Revenue    float64
-		Total      int
-		Completed  int
-		Pending    int
-		Cancelled  int
-		LowStock   int
-		OutOfStock int
+		Revenue             float64 // Taken by payment methods, gift cards and store credit were paid for before
+		GiftCardRedeemed    float64
+		StoreCreditRedeemed float64
+		Total               int
+		Completed           int
+		Pending             int
+		Cancelled           int
+		LowStock            int
+		OutOfStock          int
 	}
 
 	currentMonth := int(time.Now().Month())
@@ -100,11 +103,13 @@ func GetMonthlySales(c *gin.Context) {
 	}
 	if err := config.DB.Raw(`
 		SELECT
-			SUM(amount) as revenue
+			SUM(CASE WHEN payment_method NOT IN ? THEN amount ELSE 0 END) as revenue,
+			SUM(CASE WHEN payment_method = 'gift_card' THEN amount ELSE 0 END) as gift_card_redeemed,
+			SUM(CASE WHEN payment_method = 'store_credit' THEN amount ELSE 0 END) as store_credit_redeemed
 		FROM payments
 		WHERE payment_status = 'completed' AND
 		EXTRACT(MONTH FROM payment_date) = ? AND
-		EXTRACT(YEAR FROM payment_date) = ?`, currentMonth, currentYear).Find(&monthlySales).Error; err != nil {
+		EXTRACT(YEAR FROM payment_date) = ?`, models.TenderMethods, currentMonth, currentYear).Find(&monthlySales).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve monthly sales"})
 		return
 	}
@@ -124,16 +129,19 @@ func GetMonthlySales(c *gin.Context) {
 	}
 	// Return the result
 	c.JSON(http.StatusOK, gin.H{
-		"Revenue":    monthlySales.Revenue,
-		"Completed":  monthlySales.Completed,
-		"Pending":    monthlySales.Pending,
-		"Cancelled":  monthlySales.Cancelled,
-		"LowStock":   monthlySales.LowStock,
-		"OutOfStock": monthlySales.OutOfStock,
+		"Revenue":             monthlySales.Revenue,
+		"GiftCardRedeemed":    monthlySales.GiftCardRedeemed,
+		"StoreCreditRedeemed": monthlySales.StoreCreditRedeemed,
+		"Completed":           monthlySales.Completed,
+		"Pending":             monthlySales.Pending,
+		"Cancelled":           monthlySales.Cancelled,
+		"LowStock":            monthlySales.LowStock,
+		"OutOfStock":          monthlySales.OutOfStock,
 	})
 }
 
-// GetYearlyRevenue returns the revenue for the past 12 months
+// GetYearlyRevenue returns the revenue for the past 12 months, the completed payments of the orders
+// without those paid with gift cards or store credit
 func GetYearlyRevenue(c *gin.Context) {
 	var yearlyRevenue []struct {
 		Month   string  `json:"month"`
@@ -148,12 +156,12 @@ func GetYearlyRevenue(c *gin.Context) {
 	if err := config.DB.Raw(`
 		SELECT 
 			TO_CHAR(DATE_TRUNC('month', orders.created_at), 'Mon YYYY') AS month, 
-			SUM(total_price) AS revenue
+			SUM(payments.amount) AS revenue
 		FROM orders
-		LEFT JOIN payments ON payments.order_id = orders.id
-		WHERE orders.created_at BETWEEN ? AND ? AND payments.payment_status = 'completed'
+		JOIN payments ON payments.order_id = orders.id
+		WHERE orders.created_at BETWEEN ? AND ? AND payments.payment_status = 'completed' AND payments.payment_method NOT IN ?
 		GROUP BY month
-		ORDER BY month ASC`, startDate, now).Scan(&yearlyRevenue).Error; err != nil {
+		ORDER BY month ASC`, startDate, now, models.TenderMethods).Scan(&yearlyRevenue).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve yearly revenue"})
 		return
 	}
@@ -161,3 +169,20 @@ func GetYearlyRevenue(c *gin.Context) {
 	// Return the result
 	c.JSON(http.StatusOK, gin.H{"yearly_revenue": yearlyRevenue})
 }
+
+// GetAbandonedCartStats returns the abandonment and recovery rates of customer carts over the past days
+// (default 30)
+func GetAbandonedCartStats(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+
+	stats, err := carts.GetRecoveryStats(config.DB, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve abandoned carts"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
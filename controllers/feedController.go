@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"backend/catalog"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sitemapPagePattern = regexp.MustCompile(`^sitemap-[1-9][0-9]*\.xml$`)
+
+// serveFeed writes a cached feed, answering conditional requests with 304 Not Modified
+func serveFeed(c *gin.Context, name string) {
+	feed, err := catalog.GetFeed(c, name)
+	if err != nil {
+		if errors.Is(err, catalog.ErrFeedNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Last-Modified", feed.ModifiedAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "public, max-age=300")
+
+	if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !feed.ModifiedAt.After(since) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, feed.ContentType, feed.Body)
+}
+
+// GetSitemap serves sitemap.xml, an index of the sitemap pages when the catalogue needs more than one
+func GetSitemap(c *gin.Context) {
+	serveFeed(c, catalog.SitemapFeed)
+}
+
+// GetSitemapPage serves a page of the sitemap such as sitemap-2.xml
+func GetSitemapPage(c *gin.Context) {
+	name := c.Param("page")
+	if !sitemapPagePattern.MatchString(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+	serveFeed(c, name)
+}
+
+// GetGoogleMerchantFeed serves the Google Merchant product feed as RSS
+func GetGoogleMerchantFeed(c *gin.Context) {
+	serveFeed(c, catalog.GoogleMerchantXMLFeed)
+}
+
+// GetGoogleMerchantCSV serves the Google Merchant product feed as CSV
+func GetGoogleMerchantCSV(c *gin.Context) {
+	serveFeed(c, catalog.GoogleMerchantCSVFeed)
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/payments"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// GetGiftCards lists the gift cards, newest first, ?code= finds cards by part of their code
+func GetGiftCards(c *gin.Context) {
+	model := config.DB.Model(&models.GiftCard{}).Order("created_at DESC")
+	if code := strings.TrimSpace(c.Query("code")); code != "" {
+		model = model.Where("code ILIKE ?", "%"+code+"%")
+	}
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&[]models.GiftCard{})
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// GetGiftCard returns a gift card with its transactions
+func GetGiftCard(c *gin.Context) {
+	var card models.GiftCard
+	if err := config.DB.Preload("Transactions", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&card, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// GetMyGiftCards lists the gift cards the user bought
+func GetMyGiftCards(c *gin.Context) {
+	var cards []models.GiftCard
+	if err := config.DB.Where("purchaser_id = ?", c.GetUint("user_id")).Order("created_at DESC").Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// CheckGiftCardBalance returns the balance of the gift card with the ?code=. Unknown codes get the same
+// answer as cards that cannot be used, so the endpoint does not tell which codes exist.
+func CheckGiftCardBalance(c *gin.Context) {
+	code := strings.ToUpper(strings.TrimSpace(c.Query("code")))
+	response := gin.H{"code": code, "balance": 0, "usable": false, "reason": "gift card cannot be used"}
+
+	var card models.GiftCard
+	if err := config.DB.Where("code = ?", code).First(&card).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if card.Usable(time.Now()) == nil {
+		response = gin.H{
+			"code":       card.Code,
+			"balance":    card.Balance,
+			"currency":   card.Currency,
+			"expires_at": card.ExpiresAt,
+			"usable":     true,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateGiftCard issues a gift card, e.g. sold in store or given away. A code is generated when none is given.
+func CreateGiftCard(c *gin.Context) {
+	var payload struct {
+		Code           string
+		InitialBalance float64 `binding:"required,gt=0"`
+		Currency       string  `binding:"required,len=3"`
+		ExpiresAt      *time.Time
+		RecipientEmail *string `binding:"omitempty,email"`
+		Note           string
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	card := models.GiftCard{
+		Code:           payload.Code,
+		InitialBalance: payload.InitialBalance,
+		Currency:       payload.Currency,
+		ExpiresAt:      payload.ExpiresAt,
+		RecipientEmail: payload.RecipientEmail,
+		Note:           payload.Note,
+	}
+	if err := payments.IssueGiftCard(config.DB, &card); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to issue gift card", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, card)
+}
+
+// UpdateGiftCard activates or deactivates a gift card and changes its expiry, recipient and note
+func UpdateGiftCard(c *gin.Context) {
+	var card models.GiftCard
+	if err := config.DB.First(&card, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+
+	var payload struct {
+		IsActive       *bool
+		ExpiresAt      *time.Time
+		RecipientEmail *string `binding:"omitempty,email"`
+		Note           *string
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if payload.IsActive != nil {
+		updates["is_active"] = *payload.IsActive
+	}
+	if payload.ExpiresAt != nil {
+		updates["expires_at"] = *payload.ExpiresAt
+	}
+	if payload.RecipientEmail != nil {
+		updates["recipient_email"] = *payload.RecipientEmail
+	}
+	if payload.Note != nil {
+		updates["note"] = *payload.Note
+	}
+	if err := config.DB.Model(&card).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// AdjustGiftCard corrects the balance of a gift card, a negative Amount takes it off
+func AdjustGiftCard(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var payload struct {
+		Amount float64 `binding:"required"`
+		Note   string  `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var card *models.GiftCard
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		card, err = payments.AdjustGiftCard(tx, uint(id), payload.Amount, payload.Note)
+		return err
+	})
+	var tenderErr *payments.TenderError
+	switch {
+	case errors.Is(err, payments.ErrGiftCardNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+	case errors.As(err, &tenderErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, card)
+	}
+}
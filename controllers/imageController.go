@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/imaging"
+	"backend/media"
+	"backend/models"
+	"backend/storage"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// saveUploadedFiles stores every file of a multipart field under prefix and returns their keys
+func saveUploadedFiles(c *gin.Context, field string, prefix string) ([]string, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	files := form.File[field]
+	if len(files) == 0 {
+		return nil, errors.New("no files uploaded in field '" + field + "'")
+	}
+
+	var keys []string
+	for _, file := range files {
+		key, err := saveUploadedFile(c, file, prefix)
+		if err != nil {
+			removeStoredFiles(c, keys)
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// saveUploadedFile validates an uploaded image, strips its metadata and stores it under prefix
+func saveUploadedFile(c *gin.Context, file *multipart.FileHeader, prefix string) (string, error) {
+	limits := imaging.DefaultLimits()
+	if limits.MaxBytes > 0 && file.Size > int64(limits.MaxBytes) {
+		return "", errors.New(file.Filename + ": " + imaging.ErrTooLarge.Error())
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+
+	clean, _, err := imaging.Sanitize(data, limits)
+	if err != nil {
+		return "", errors.New(file.Filename + ": " + err.Error())
+	}
+
+	return storage.SaveBytes(c.Request.Context(), prefix, clean)
+}
+
+// removeStoredFiles cleans up blobs whose database rows could not be written
+func removeStoredFiles(c *gin.Context, keys []string) {
+	for _, key := range keys {
+		if err := storage.Remove(c.Request.Context(), key); err != nil {
+			log.Printf("failed to remove %s from storage: %v", key, err)
+		}
+	}
+}
+
+// UploadProductImages attaches multipart uploaded images to a product
+func UploadProductImages(c *gin.Context) {
+	productID := c.Param("id")
+	var product *models.Product
+
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	keys, err := saveUploadedFiles(c, "images", "products")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var images []*models.ProductImage
+	for _, key := range keys {
+		images = append(images, &models.ProductImage{ProductID: product.ID, StorageKey: key})
+	}
+
+	if err := config.DB.Create(&images).Error; err != nil {
+		removeStoredFiles(c, keys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	media.QueueRenditions()
+	for _, image := range images {
+		image.URL = storage.URL(image.StorageKey)
+	}
+
+	c.JSON(http.StatusCreated, images)
+}
+
+// DeleteProductImage removes a product image and its stored file
+func DeleteProductImage(c *gin.Context) {
+	imageID := c.Param("id")
+	var image *models.ProductImage
+
+	if err := config.DB.First(&image, imageID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := config.DB.Delete(&image).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	removeStoredFiles(c, append(image.Renditions.StorageKeys(), image.StorageKey))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+// UploadCategoryImage sets or replaces the image of a category
+func UploadCategoryImage(c *gin.Context) {
+	categoryID := c.Param("id")
+	var category *models.Category
+
+	if err := config.DB.Preload("Image").First(&category, categoryID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := saveUploadedFile(c, file, "categories")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	image := &models.CategoryImage{CategoryID: category.ID, StorageKey: key}
+	previous := category.Image
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if previous != nil {
+			if err := tx.Delete(previous).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(image).Error
+	})
+	if err != nil {
+		removeStoredFiles(c, []string{key})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if previous != nil {
+		removeStoredFiles(c, append(previous.Renditions.StorageKeys(), previous.StorageKey))
+	}
+
+	media.QueueRenditions()
+	image.URL = storage.URL(image.StorageKey)
+	c.JSON(http.StatusCreated, image)
+}
+
+// UploadBannerImages adds multipart uploaded banner images
+func UploadBannerImages(c *gin.Context) {
+	keys, err := saveUploadedFiles(c, "images", "content")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var content []*models.ContentImage
+	for _, key := range keys {
+		content = append(content, &models.ContentImage{Position: "banner", StorageKey: key})
+	}
+
+	if err := config.DB.Create(&content).Error; err != nil {
+		removeStoredFiles(c, keys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	media.QueueRenditions()
+	c.JSON(http.StatusCreated, gin.H{"message": "content added successfully"})
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/loyalty"
+	"backend/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// loyaltyStatement responds with the points and tier of a customer and their transactions, newest first
+func loyaltyStatement(c *gin.Context, userID uint) {
+	now := time.Now()
+	balance, err := loyalty.Balance(config.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tier, next, qualifying, err := loyalty.TierOf(config.DB, userID, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	expiring, err := loyalty.Expiring(config.DB, userID, now.AddDate(0, 0, 30))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	model := config.DB.Model(&models.LoyaltyTransaction{}).Where("user_id = ?", userID).Order("id DESC")
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&[]models.LoyaltyTransaction{})
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance":           balance,
+		"value":             float64(balance) * loyalty.PointValue(),
+		"point_value":       loyalty.PointValue(),
+		"min_redeem_points": loyalty.MinRedeemPoints(),
+		"expiring_soon":     expiring, // Points expiring in the next 30 days
+		"tier":              tier,
+		"next_tier":         next,
+		"tier_points":       qualifying, // Points earned in the last 12 months
+		"transactions":      page,
+	})
+}
+
+// GetMyLoyalty returns the points, tier and points history of the user
+func GetMyLoyalty(c *gin.Context) {
+	loyaltyStatement(c, c.GetUint("user_id"))
+}
+
+// GetUserLoyalty returns the points, tier and points history of a customer
+func GetUserLoyalty(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	loyaltyStatement(c, uint(userID))
+}
+
+// AdjustLoyaltyPoints adds points to a customer or takes them off with negative points
+func AdjustLoyaltyPoints(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var payload struct {
+		Points int    `binding:"required"`
+		Reason string `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entry *models.LoyaltyTransaction
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		entry, err = loyalty.Adjust(tx, uint(userID), payload.Points, payload.Reason)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, entry)
+	}
+}
+
+// GetLoyaltyRules lists the earn rules of the loyalty programme
+func GetLoyaltyRules(c *gin.Context) {
+	var rules []models.LoyaltyRule
+	if err := config.DB.Order("kind, id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateLoyaltyRule creates an earn rule
+func CreateLoyaltyRule(c *gin.Context) {
+	var rule models.LoyaltyRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create loyalty rule", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateLoyaltyRule updates an earn rule
+func UpdateLoyaltyRule(c *gin.Context) {
+	var rule models.LoyaltyRule
+	if err := config.DB.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loyalty rule not found"})
+		return
+	}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := config.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteLoyaltyRule deletes an earn rule, points already earned with it are kept
+func DeleteLoyaltyRule(c *gin.Context) {
+	if err := config.DB.Delete(&models.LoyaltyRule{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete loyalty rule"})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{"message": "Loyalty rule deleted"})
+}
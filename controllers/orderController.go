@@ -1,21 +1,34 @@
 package controllers
 
 import (
+	"backend/carts"
 	"backend/config"
+	"backend/events"
+	"backend/loyalty"
 	"backend/models"
+	"backend/payments"
+	"backend/promotions"
+	"backend/referrals"
 	"backend/serializers"
 	"backend/utils"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/morkid/paginate"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// CreateOrder creates a new order with order items and updates the inventory
+// CreateOrder creates a new order with order items and updates the inventory. Without a signed in user
+// it is a guest checkout, which needs an email, phone number and shipping address and returns a lookup
+// token to track and pay for the order.
 func CreateOrder(c *gin.Context) {
 	var order *models.Order
 	var shipping_option *models.ShippingOptions
@@ -31,7 +44,12 @@ func CreateOrder(c *gin.Context) {
 		return
 
 	}
-	order.UserID = c.GetUint("user_id")
+	if userID := c.GetUint("user_id"); userID != 0 {
+		order.UserID, order.GuestEmail, order.GuestPhone = &userID, nil, nil
+	} else if err := validateGuestCheckout(order); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	order.OrderStatus = "pending"
 	order.ItemPrice = 0.0
 
@@ -40,6 +58,39 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// Quantities are checked against the stock before any discount is worked out for them
+	if err := checkOrderItems(order.OrderItems); err != nil {
+		if errors.Is(err, errNotEnoughStock) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough stock available"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	now := time.Now()
+	if err := priceOrderItems(order.OrderItems, now); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	cart := promotions.Cart{UserID: order.UserID, Shipping: shipping_option.ShippingCost, Points: order.LoyaltyPoints}
+	if order.GuestEmail != nil {
+		cart.GuestEmail = *order.GuestEmail
+	}
+	pricing, err := evaluateOrderItems(order.OrderItems, cart, order.CouponCodes(), now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(pricing.Rejected) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to apply coupon", "rejected": pricing.Rejected})
+		return
+	}
+	for i := range order.OrderItems {
+		order.OrderItems[i].DiscountAmount = pricing.LineDiscount(i)
+	}
+
 	// Start a database transaction
 	tx := config.DB.Begin()
 
@@ -50,64 +101,71 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("lenth of order items: %d\n", len(order.OrderItems))
-
 	// Loop through the order items and create them, also update inventory for each product
 	for _, item := range order.OrderItems {
-		order.ItemPrice += item.PriceAtPurchase * float64(item.Quantity)
-
-		var ParentID uint
-		tx.Model(&models.Product{}).Select("parent_id").Where("id = ?", item.ProductID).First(&ParentID)
-		if ParentID == 0 {
-			ParentID = item.ProductID
-		}
-		// Fetch the existing inventory record for the product
-		var inventory models.Inventory
-		if err := tx.Where("product_id = ?", ParentID).First(&inventory).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch inventory"})
-			return
-		}
-
-		// Check if there's enough stock to fulfill the order
-		if inventory.StockLevel < item.Quantity+inventory.InOpen {
+		// Bundles reserve stock on each of their components
+		if err := reserveStock(tx, item.ProductID, item.Quantity); err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough stock available"})
+			if errors.Is(err, errNotEnoughStock) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough stock available"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
 			return
 		}
 
-		// Update inventory: subtract ordered quantity from InOpen and StockLevel
-		inventory.InOpen += item.Quantity
-		inventory.ChangeType = "purchase"
-		inventory.ChangeDate = time.Now()
+	}
 
-		if err := tx.Save(&inventory).Error; err != nil {
+	// Keep which discount applied to which line
+	if discounts := pricing.OrderDiscounts(order); len(discounts) > 0 {
+		if err := tx.Create(&discounts).Error; err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save order discounts"})
 			return
 		}
-
 	}
-
-	if order.Coupon != "" {
-		coupon := ApplyCoupon(c, order.Coupon, order.UserID)
-		if coupon == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Failed to apply coupon"})
+	// Coupons are only redeemed with the order, a failed order does not use them up
+	if err := pricing.Redeem(tx, order); err != nil {
+		tx.Rollback()
+		if errors.Is(err, promotions.ErrCouponUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to apply coupon", "message": err.Error()})
 			return
-
 		}
-		if coupon.DiscountType == "percentage" {
-			order.DiscountAmount = order.DiscountAmount + order.ItemPrice*(coupon.DiscountValue/100)
-		} else {
-			order.DiscountAmount = order.DiscountAmount + coupon.DiscountValue
+		if errors.Is(err, promotions.ErrPromotionUnavailable) || errors.Is(err, loyalty.ErrNotEnoughPoints) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Order total changed, please review the order", "message": err.Error()})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log coupon usage"})
+		return
 	}
 
+	// Checking out stops the abandoned cart emails
+	if err := carts.RecordCheckout(tx, order); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the abandoned cart"})
+		return
+	}
+
+	order.ItemPrice = pricing.Subtotal
+	order.DiscountAmount = pricing.DiscountAmount
 	order.ShippingCost = shipping_option.ShippingCost
-	order.TotalPrice = order.ItemPrice - order.DiscountAmount + order.ShippingCost
+	order.TotalPrice = pricing.Total
+
+	// Gift cards and store credit pay first, the payment method covers what is left
+	remaining, err := payments.ApplyTenders(tx, order, now)
+	if err != nil {
+		tx.Rollback()
+		var tenderErr *payments.TenderError
+		if errors.As(err, &tenderErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to apply gift card or store credit", "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply gift card or store credit"})
+		return
+	}
 
 	order.PaymentDetails.OrderID = order.ID
-	order.PaymentDetails.Amount = order.TotalPrice
+	order.PaymentDetails.Amount = remaining
 	order.PaymentDetails.TransanctionID = toPtr(utils.GenerateTransactionID())
 	order.PaymentDetails.PaymentStatus = "pending"
 
@@ -117,17 +175,193 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
-	if err := tx.Save(&order.PaymentDetails).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment details"})
-		return
+	if remaining > 0 {
+		if err := tx.Save(&order.PaymentDetails).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment details"})
+			return
+		}
 	}
 
 	// Commit the transaction
 	tx.Commit()
 
 	// Return the created order and inventory updates
-	c.JSON(http.StatusOK, gin.H{"message": "order created successfully", "OrderID": order.OrderIdentifier})
+	response := gin.H{"message": "order created successfully", "OrderID": order.OrderIdentifier, "AmountDue": remaining}
+	if order.UserID == nil {
+		response["LookupToken"] = order.LookupToken()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// validateGuestCheckout checks the contact details of a guest order and normalises its email. Loyalty
+// points and store credit belong to an account, so guests cannot use them.
+func validateGuestCheckout(order *models.Order) error {
+	order.UserID = nil
+	if order.GuestEmail == nil || order.GuestPhone == nil || strings.TrimSpace(*order.GuestPhone) == "" || strings.TrimSpace(order.OrderShippingAddress) == "" {
+		return errors.New("guest checkout needs a GuestEmail, GuestPhone and OrderShippingAddress")
+	}
+	email, err := mail.ParseAddress(strings.TrimSpace(*order.GuestEmail))
+	if err != nil || len(email.Address) > 100 {
+		return errors.New("invalid GuestEmail")
+	}
+	order.GuestEmail = toPtr(strings.ToLower(email.Address))
+	order.GuestPhone = toPtr(strings.TrimSpace(*order.GuestPhone))
+	if len(*order.GuestPhone) > 20 {
+		return errors.New("invalid GuestPhone")
+	}
+	if order.LoyaltyPoints > 0 || order.StoreCredit > 0 {
+		return errors.New("sign in to use loyalty points or store credit")
+	}
+	return nil
+}
+
+var errNotEnoughStock = errors.New("not enough stock available")
+
+// reserveStock moves quantity of a product into InOpen on its inventory record, or its parent's when it has none.
+// For a bundle the stock of every component is reserved instead.
+func reserveStock(tx *gorm.DB, productID uint, quantity int) error {
+	var product models.Product
+	if err := tx.Preload("BundleComponents").First(&product, productID).Error; err != nil {
+		return err
+	}
+
+	if product.IsBundle() {
+		if len(product.BundleComponents) == 0 {
+			return errNotEnoughStock
+		}
+		for _, component := range product.BundleComponents {
+			if err := reserveStock(tx, component.ComponentID, quantity*component.Quantity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Fetch the existing inventory record for the product
+	var inventory models.Inventory
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", product.ID).First(&inventory).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && product.ParentID != nil {
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", *product.ParentID).First(&inventory).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	// Check if there's enough stock to fulfill the order
+	if inventory.StockLevel < quantity+inventory.InOpen {
+		return errNotEnoughStock
+	}
+
+	inventory.InOpen += quantity
+	inventory.ChangeType = "purchase"
+	inventory.ChangeDate = time.Now()
+
+	return tx.Save(&inventory).Error
+}
+
+// maxItemQuantity is the most of one product a single order or cart line can hold
+const maxItemQuantity = 1000
+
+// checkOrderItems rejects quantities outside 1 to maxItemQuantity and products that do not have the
+// quantity asked for available. reserveStock checks again under lock when the order is placed.
+func checkOrderItems(items []models.OrderItem) error {
+	wanted := map[uint]int{}
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		if item.Quantity < 1 || item.Quantity > maxItemQuantity {
+			return fmt.Errorf("quantity must be between 1 and %d", maxItemQuantity)
+		}
+		if _, ok := wanted[item.ProductID]; !ok {
+			ids = append(ids, item.ProductID)
+		}
+		wanted[item.ProductID] += item.Quantity
+	}
+
+	available, err := carts.Available(config.DB, ids)
+	if err != nil {
+		return err
+	}
+	for id, quantity := range wanted {
+		if quantity > available[id] {
+			return errNotEnoughStock
+		}
+	}
+	return nil
+}
+
+// releaseStock gives back quantity of a product reserved by reserveStock, from the same inventory record
+func releaseStock(tx *gorm.DB, productID uint, quantity int) error {
+	var product models.Product
+	if err := tx.Unscoped().Preload("BundleComponents").First(&product, productID).Error; err != nil {
+		return err
+	}
+
+	if product.IsBundle() {
+		for _, component := range product.BundleComponents {
+			if err := releaseStock(tx, component.ComponentID, quantity*component.Quantity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var inventory models.Inventory
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", product.ID).First(&inventory).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && product.ParentID != nil {
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", *product.ParentID).First(&inventory).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Nothing to give back to a product whose inventory was removed since
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	inventory.InOpen = max(inventory.InOpen-quantity, 0)
+	inventory.ChangeType = "restock"
+	inventory.ChangeDate = time.Now()
+	return tx.Save(&inventory).Error
+}
+
+// priceOrderItems sets the purchase price of each item from the catalogue, honouring sale windows,
+// and rejects products that are not published at that time
+func priceOrderItems(items []models.OrderItem, at time.Time) error {
+	for i := range items {
+		var product models.Product
+		if err := config.DB.First(&product, items[i].ProductID).Error; err != nil {
+			return fmt.Errorf("product %d not found", items[i].ProductID)
+		}
+
+		// Variations follow the publishing schedule of their parent
+		listed := product
+		if product.ParentID != nil {
+			var parent models.Product
+			if err := config.DB.First(&parent, *product.ParentID).Error; err != nil {
+				return fmt.Errorf("product %d not found", items[i].ProductID)
+			}
+			listed = parent
+		}
+		if !listed.IsPublished(at) {
+			return fmt.Errorf("product %s is not available", product.Name)
+		}
+
+		items[i].PriceAtPurchase = product.EffectivePrice(at)
+	}
+
+	return nil
+}
+
+// evaluateOrderItems applies the running promotions, the coupon codes and the redeemed loyalty points to
+// priced order items, the lines of the cart are made from the items
+func evaluateOrderItems(items []models.OrderItem, cart promotions.Cart, codes []string, at time.Time) (*promotions.Result, error) {
+	lines, err := promotions.OrderLines(config.DB, items)
+	if err != nil {
+		return nil, err
+	}
+	cart.Lines = lines
+	return promotions.Evaluate(config.DB, cart, codes, at)
 }
 
 // GetOrder retrieves an order by ID along with its items
@@ -136,7 +370,7 @@ func GetOrderByID(c *gin.Context) {
 	var order *serializers.OrderResponse
 
 	// Preload OrderItems to include them in the response
-	if err := config.DB.Model(&models.Order{}).Preload("User").Preload("PaymentDetails").Preload("OrderItems.Product").First(&order, orderID).Error; err != nil {
+	if err := config.DB.Model(&models.Order{}).Preload("User").Preload("PaymentDetails", "payment_method NOT IN ?", models.TenderMethods).Preload("Payments").Preload("OrderItems.Product").Preload("Discounts").First(&order, orderID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		} else {
@@ -149,6 +383,117 @@ func GetOrderByID(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
+// GetGuestOrder tracks a guest order by its lookup token
+func GetGuestOrder(c *gin.Context) {
+	id, ok := models.GuestOrderID(c.Param("token"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var order *serializers.OrderResponse
+	if err := config.DB.Model(&models.Order{}).Preload("PaymentDetails", "payment_method NOT IN ?", models.TenderMethods).Preload("Payments").Preload("OrderItems.Product").Preload("Discounts").First(&order, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// CreateGuestPayment records a pending payment for the guest order of a lookup token. The customer only
+// chooses the method and their payment reference, the amount is what is left to pay on the order.
+func CreateGuestPayment(c *gin.Context) {
+	id, ok := models.GuestOrderID(c.Param("token"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	var payload struct {
+		PaymentMethod string `binding:"required"`
+		Reference     string `binding:"max=11"` // Transaction ID of the payment provider, generated when empty
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, method := range models.TenderMethods {
+		if payload.PaymentMethod == method {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Gift cards are applied when placing the order"})
+			return
+		}
+	}
+
+	var order models.Order
+	if err := config.DB.Select("id", "order_status", "total_price").First(&order, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if order.OrderStatus == "cancelled" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has been cancelled"})
+		return
+	}
+
+	var paid float64
+	if err := config.DB.Model(&models.Payment{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("order_id = ? AND payment_status = 'completed'", order.ID).
+		Scan(&paid).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	outstanding := math.Round((order.TotalPrice-paid)*100) / 100
+	if outstanding <= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has already been paid"})
+		return
+	}
+
+	reference := strings.TrimSpace(payload.Reference)
+	if reference == "" {
+		reference = utils.GenerateTransactionID()
+	}
+	payment := models.Payment{
+		PaymentMethod:  payload.PaymentMethod,
+		PaymentStatus:  "pending",
+		Amount:         outstanding,
+		TransanctionID: &reference,
+		OrderID:        order.ID,
+	}
+	if err := config.DB.Create(&payment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"payment": payment})
+}
+
+// ClaimGuestOrders attaches the guest orders of the user's email to their account once the email is
+// verified. Until then it sends a verification link to the email, a lookup token is no proof of it.
+func ClaimGuestOrders(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		if err := sendEmailVerification(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the verification email"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Verify your email to attach its guest orders, a link was sent to it"})
+		return
+	}
+
+	attached, err := models.AttachGuestOrders(config.DB, user.ID, user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Guest orders attached", "orders": attached})
+}
+
 func GetOrders(c *gin.Context) {
 	var order []*serializers.OrderResponse
 	var model *gorm.DB
@@ -156,11 +501,11 @@ func GetOrders(c *gin.Context) {
 	if c.GetString("role") == "admin" {
 
 		// Preload OrderItems to include them in the response
-		model = config.DB.Model(&models.Order{}).Preload("User").Preload("PaymentDetails").Preload("OrderItems.Product").Order("created_at DESC")
+		model = config.DB.Model(&models.Order{}).Preload("User").Preload("PaymentDetails", "payment_method NOT IN ?", models.TenderMethods).Preload("Payments").Preload("OrderItems.Product").Preload("Discounts").Order("created_at DESC")
 
 	} else {
 		// Preload OrderItems to include them in the response
-		model = config.DB.Model(&models.Order{}).Preload("User").Preload("OrderItems.Product").Where("user_id = ?", c.GetUint("user_id")).Order("created_at DESC")
+		model = config.DB.Model(&models.Order{}).Preload("User").Preload("OrderItems.Product").Preload("Discounts").Where("user_id = ?", c.GetUint("user_id")).Order("created_at DESC")
 
 	}
 
@@ -181,13 +526,8 @@ func DispatchOrder(c *gin.Context) {
 
 	orderID := c.Param("id")
 
-	// Fetch the category from the database
-	if err := config.DB.Model(&models.Order{}).Where("id = ?", orderID).Update("order_status", "shipped").Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+	if err := setOrderStatus(orderID, "shipped"); err != nil {
+		orderStatusError(c, err)
 		return
 	}
 
@@ -200,13 +540,8 @@ func CancelOrder(c *gin.Context) {
 
 	orderID := c.Param("id")
 
-	// Fetch the category from the database
-	if err := config.DB.Model(&models.Order{}).Where("id = ?", orderID).Update("order_status", "cancelled").Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+	if err := setOrderStatus(orderID, "cancelled"); err != nil {
+		orderStatusError(c, err)
 		return
 	}
 
@@ -214,7 +549,101 @@ func CancelOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "order cancelled"})
 }
 
-// CancelOrder updates an order status to cancelled by its ID
+// orderTransitions are the statuses an order can move to from each status. Orders only move forward,
+// delivered and cancelled orders are final.
+var orderTransitions = map[string][]string{
+	"pending": {"shipped", "delivered", "cancelled"},
+	"shipped": {"delivered", "cancelled"},
+}
+
+var (
+	errUnknownOrderStatus    = errors.New("order status must be one of pending, shipped, delivered or cancelled")
+	errOrderStatusTransition = errors.New("order status cannot change")
+	orderStatuses            = []string{"pending", "shipped", "delivered", "cancelled"}
+)
+
+// orderStatusError writes the response of a failed setOrderStatus
+func orderStatusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.Is(err, errUnknownOrderStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errOrderStatusTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// setOrderStatus changes the status of an order along orderTransitions, setting the status it already
+// has changes nothing. Cancelling an order releases its reserved stock and gives back its coupon uses,
+// loyalty points, gift card and store credit payments, delivering it issues the gift cards bought on it,
+// awards the loyalty points and rewards the customer's referrer.
+func setOrderStatus(orderID string, status string) error {
+	if !slices.Contains(orderStatuses, status) {
+		return errUnknownOrderStatus
+	}
+	var released []models.OrderItem
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "order_status").First(&order, orderID).Error; err != nil {
+			return err
+		}
+		if order.OrderStatus == status {
+			return nil
+		}
+		if !slices.Contains(orderTransitions[order.OrderStatus], status) {
+			return fmt.Errorf("%w from %s to %s", errOrderStatusTransition, order.OrderStatus, status)
+		}
+		if err := tx.Model(&order).Update("order_status", status).Error; err != nil {
+			return err
+		}
+		switch status {
+		case "cancelled":
+			if err := tx.Where("order_id = ?", order.ID).Find(&released).Error; err != nil {
+				return err
+			}
+			for _, item := range released {
+				if err := releaseStock(tx, item.ProductID, item.Quantity); err != nil {
+					return err
+				}
+			}
+			if err := promotions.ReverseRedemptions(tx, order.ID); err != nil {
+				return err
+			}
+			if err := loyalty.ReverseOrder(tx, order.ID); err != nil {
+				return err
+			}
+			return payments.ReverseTenders(tx, order.ID)
+		case "delivered":
+			if _, err := payments.IssueOrderGiftCards(tx, order.ID); err != nil {
+				return err
+			}
+			// Points are earned on what was paid for the items once the order is delivered
+			var delivered models.Order
+			if err := tx.Preload("OrderItems").First(&delivered, order.ID).Error; err != nil {
+				return err
+			}
+			if _, err := loyalty.EarnForOrder(tx, &delivered); err != nil {
+				return err
+			}
+			return referrals.RewardForOrder(tx, order.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Customers waiting for the products are told once the stock is back
+	for _, item := range released {
+		events.Publish(events.ProductRestocked, events.ProductEvent{ProductID: item.ProductID})
+	}
+	return nil
+}
+
+// UpdateOrderStatus moves an order to the given status
 func UpdateOrderStatus(c *gin.Context) {
 
 	orderID := c.Param("id")
@@ -228,12 +657,8 @@ func UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Model(&models.Order{}).Where("id = ?", orderID).Update("order_status", payload.OrderStatus).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+	if err := setOrderStatus(orderID, payload.OrderStatus); err != nil {
+		orderStatusError(c, err)
 		return
 	}
 
@@ -251,6 +676,19 @@ func RestockProduct(c *gin.Context) {
 		return
 	}
 
+	// Customers waiting for the product are told when it can be ordered again
+	before, err := carts.Available(config.DB, []uint{inventoryRequest.ProductID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	publishRestocked := func() {
+		after, err := carts.Available(config.DB, []uint{inventoryRequest.ProductID})
+		if err == nil && before[inventoryRequest.ProductID] <= 0 && after[inventoryRequest.ProductID] > 0 {
+			events.Publish(events.ProductRestocked, events.ProductEvent{ProductID: inventoryRequest.ProductID})
+		}
+	}
+
 	var existingInventory models.Inventory
 
 	// Check if an inventory record already exists for the given product
@@ -265,6 +703,7 @@ func RestockProduct(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create inventory record"})
 				return
 			}
+			publishRestocked()
 			c.JSON(http.StatusOK, gin.H{"message": "Stock added successfully", "inventory": inventoryRequest})
 		} else {
 			// Handle other database errors
@@ -282,6 +721,7 @@ func RestockProduct(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory record"})
 			return
 		}
+		publishRestocked()
 
 		// Return success response
 		c.JSON(http.StatusOK, gin.H{"message": "Stock updated successfully", "inventory": existingInventory})
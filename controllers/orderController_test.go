@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestOrderTransitions(t *testing.T) {
+	allowed := map[[2]string]bool{
+		{"pending", "shipped"}:     true,
+		{"pending", "delivered"}:   true,
+		{"pending", "cancelled"}:   true,
+		{"shipped", "delivered"}:   true,
+		{"shipped", "cancelled"}:   true,
+		{"shipped", "pending"}:     false,
+		{"delivered", "cancelled"}: false,
+		{"delivered", "shipped"}:   false,
+		{"cancelled", "pending"}:   false,
+		{"cancelled", "shipped"}:   false,
+	}
+	for _, from := range orderStatuses {
+		for _, to := range orderStatuses {
+			if from == to {
+				continue
+			}
+			got := slices.Contains(orderTransitions[from], to)
+			if got != allowed[[2]string{from, to}] {
+				t.Errorf("%s to %s allowed %v, want %v", from, to, got, allowed[[2]string{from, to}])
+			}
+		}
+	}
+}
+
+func TestOrderStatusError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{errUnknownOrderStatus, http.StatusBadRequest},
+		{fmt.Errorf("%w from delivered to cancelled", errOrderStatusTransition), http.StatusConflict},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		orderStatusError(c, tt.err)
+		if rec.Code != tt.want {
+			t.Errorf("orderStatusError(%v) status %d, want %d", tt.err, rec.Code, tt.want)
+		}
+	}
+}
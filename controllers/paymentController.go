@@ -71,13 +71,14 @@ func GetAllPayments(c *gin.Context) {
 
 	type Payment struct {
 		gorm.Model
-		PaymentMethod  string  `gorm:"size:50;not null;check:payment_method IN ('cash_on_delivery', 'paypal')"`
-		PaymentStatus  string  `gorm:"size:50;not null;check:payment_status IN ('pending', 'completed', 'failed')"`
+		PaymentMethod  string  `gorm:"size:50;not null"`
+		PaymentStatus  string  `gorm:"size:50;not null"`
 		Amount         float64 `gorm:"type:decimal(10,2);not null"`
 		TransanctionID *string `gorm:"size:11;not null"`
 		PaymentDate    *time.Time
 		OrderID        uint  `gorm:"not null"`
 		Order          Order `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+		GiftCardID     *uint
 	}
 	var payments []*Payment
 
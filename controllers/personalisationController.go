@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// viewer returns the logged in user or the anonymous session making the request, set by OptionalAuthMiddleware
+func viewer(c *gin.Context) (*uint, *string) {
+	var userID *uint
+	var sessionID *string
+
+	if id := c.GetUint("user_id"); id != 0 {
+		userID = &id
+	}
+	if id := c.GetString("session_id"); id != "" {
+		sessionID = &id
+	}
+	return userID, sessionID
+}
+
+// viewerCondition matches product views of the user, or of the session for anonymous viewers
+func viewerCondition(userID *uint, sessionID *string) (string, []interface{}) {
+	if userID != nil {
+		return "product_views.user_id = ?", []interface{}{*userID}
+	}
+	return "product_views.session_id = ? AND product_views.user_id IS NULL", []interface{}{*sessionID}
+}
+
+// GetRecentlyViewedProducts returns the products the user or session viewed last, most recent first
+func GetRecentlyViewedProducts(c *gin.Context) {
+	userID, sessionID := viewer(c)
+	if userID == nil && sessionID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "log in or send an X-Session-ID header"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "12"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 12
+	}
+
+	condition, args := viewerCondition(userID, sessionID)
+	products := []*productCard{}
+
+	if err := productCardQuery().
+		Joins("JOIN (SELECT product_id, MAX(viewed_at) AS viewed_at FROM product_views WHERE "+condition+" GROUP BY product_id) views ON views.product_id = products.id", args...).
+		Order("views.viewed_at DESC").
+		Limit(limit).
+		Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
+// GetPersonalisedFeed ranks published, in stock products by the categories the user viewed, wished for and bought,
+// blended with trending and new arrivals. Users without any history get the trending products.
+func GetPersonalisedFeed(c *gin.Context) {
+	userID, sessionID := viewer(c)
+	if userID == nil && sessionID == nil {
+		GetTrendingProducts(c)
+		return
+	}
+
+	// Category weights: a view counts once, a purchase twice and a wishlist entry three times
+	condition, args := viewerCondition(userID, sessionID)
+	signals := `SELECT products.category_id, COUNT(*)::float AS weight
+				FROM product_views JOIN products ON products.id = product_views.product_id
+				WHERE ` + condition + ` GROUP BY products.category_id`
+	if userID != nil {
+		signals += `
+				UNION ALL
+				SELECT products.category_id, 3 * COUNT(*)::float
+				FROM wish_lists JOIN products ON products.id = wish_lists.product_id
+				WHERE wish_lists.user_id = ? GROUP BY products.category_id
+				UNION ALL
+				SELECT products.category_id, 2 * COUNT(*)::float
+				FROM order_items
+				JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL
+				JOIN products ON products.id = order_items.product_id
+				WHERE orders.user_id = ? GROUP BY products.category_id`
+		args = append(args, *userID, *userID)
+	}
+
+	var hasSignals bool
+	if err := config.DB.Raw("SELECT EXISTS ("+signals+")", args...).Scan(&hasSignals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasSignals {
+		GetTrendingProducts(c)
+		return
+	}
+
+	type Inventory struct {
+		ProductID  uint           `gorm:"not null" json:"-"`
+		Product    models.Product `gorm:"foreignKey:ProductID" json:"-"`
+		StockLevel int            `gorm:"not null"`
+	}
+	type Product struct {
+		gorm.Model
+		Name               string  `gorm:"size:150;not null"`
+		Description        string  `gorm:"type:text"`
+		SKU                string  `gorm:"size:150;not null;unique;index"`
+		Barcode            *string `gorm:"size:150"`
+		Price              float64 `gorm:"type:decimal(10,2);not null"`
+		CompareAtPrice     float64 // Regular price, higher than Price while on sale
+		OnSale             bool
+		DiscountPercentage float64
+		Currency           string                `gorm:"size:3; not null"`
+		Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+		CategoryID         uint                  `gorm:"not null"`
+		Category           models.Category       `gorm:"foreignKey:CategoryID"`
+		Status             *string               `gorm:"not null;check:status IN ('published', 'unpublished')"`
+		Inventory          *Inventory            `gorm:"foreignKey:ProductID"`
+		TotalReviews       int
+		Rating             int
+		Slug               *string
+		ProductType        string
+		Available          int      // Quantity that can be ordered, for bundles limited by their components
+		Badges             []string `gorm:"-"` // Of the automatic promotions running on the product
+	}
+
+	var products []*Product
+
+	// Score: 60% category affinity, 30% orders in the last 30 days, 10% for arrivals of the last 30 days
+	model := config.DB.Model(&products).Preload("Category").Preload("Inventory").Preload("Images").
+		Select(`products.id, 
+				products.created_at, 
+				products.updated_at, 
+				products.deleted_at, 
+				products.name, 
+				products.description, 
+				products.sku, 
+				products.barcode, 
+				`+utils.ListingPricingSelect()+`,
+				products.currency, 
+				products.category_id, 
+				products.status, 
+				products.slug, 
+				products.product_type, 
+				`+utils.AvailableStockSQL("products")+` AS available,
+				(SELECT COUNT(*) FROM reviews WHERE reviews.product_id = products.id) AS total_reviews,
+				(SELECT AVG(rating)::int FROM reviews WHERE reviews.product_id = products.id) AS rating`).
+		Joins(`LEFT JOIN (
+				SELECT category_id, SUM(weight) / SUM(SUM(weight)) OVER () AS share
+				FROM (`+signals+`) signals GROUP BY category_id
+			) affinity ON affinity.category_id = products.category_id`, args...).
+		Joins(`LEFT JOIN (
+				SELECT product_id, orders::float / MAX(orders) OVER () AS share
+				FROM (
+					SELECT COALESCE(products.parent_id, products.id) AS product_id, COUNT(DISTINCT order_items.order_id) AS orders
+					FROM order_items
+					JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL AND orders.created_at > NOW() - INTERVAL '30 days'
+					JOIN products ON products.id = order_items.product_id
+					GROUP BY 1
+				) counts
+			) trending ON trending.product_id = products.id`).
+		Joins(utils.VariationPricingJoin()).
+		Where("products.is_child = false").
+		Where(utils.PublishedSQL("products")).
+		Where("(" + utils.AvailableStockSQL("products") + ") > 0").
+		Order(`0.6 * COALESCE(affinity.share, 0)
+				+ 0.3 * COALESCE(trending.share, 0)
+				+ CASE WHEN products.created_at > NOW() - INTERVAL '30 days' THEN 0.1 ELSE 0 END DESC, products.created_at DESC`)
+
+	if userID != nil {
+		// Don't suggest what the user already bought
+		model = model.Where(`products.id NOT IN (
+				SELECT COALESCE(products.parent_id, products.id) FROM order_items
+				JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL
+				JOIN products ON products.id = order_items.product_id
+				WHERE orders.user_id = ?
+			)`, *userID)
+	}
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&products)
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	badges := promotionBadges(ids)
+	for _, product := range products {
+		product.Badges = badges[product.ID]
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
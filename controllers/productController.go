@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"backend/catalog"
 	"backend/config"
+	"backend/events"
+	"backend/media"
 	"backend/models"
 	"backend/utils"
 	"encoding/json"
@@ -10,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/lib/pq"
 	"github.com/morkid/paginate"
 	"gorm.io/gorm"
@@ -18,8 +22,9 @@ import (
 // CreateProduct creates a new product
 func CreateProduct(c *gin.Context) {
 	type Variation struct {
-		Size  string
-		Price float64
+		Size      string
+		Price     float64
+		SalePrice *float64
 	}
 	var payload struct {
 		Name        string  `gorm:"size:150;not null"`
@@ -35,9 +40,21 @@ func CreateProduct(c *gin.Context) {
 		IsChild     bool    `gorm:"default:false"`
 		ParentID    *uint
 		Size        string
-		BrandID     *uint
+		Brand       *string
 		Variations  []Variation
 		Images      []models.ProductImage `gorm:"foreignKey:ProductID"`
+
+		ProductType    string
+		BundlePricing  *string
+		BundleDiscount float64
+		Components     []models.BundleComponent
+		Attributes     []models.ProductAttribute
+
+		PublishAt    *time.Time
+		UnpublishAt  *time.Time
+		SalePrice    *float64
+		SaleStartsAt *time.Time
+		SaleEndsAt   *time.Time
 	}
 
 	if err := c.BindJSON(&payload); err != nil {
@@ -45,12 +62,34 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
-	tx := config.DB.Begin()
+	if payload.ProductType == "bundle" && len(payload.Variations) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bundles cannot have variations"})
+		return
+	}
+
+	// Uploads are stored before the transaction and removed again unless it commits
+	var keys []string
+	committed := false
+	defer func() {
+		if !committed {
+			removeStoredFiles(c, keys)
+		}
+	}()
+	for i := range payload.Images {
+		if err := payload.Images[i].StoreUpload(c.Request.Context()); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		keys = append(keys, payload.Images[i].StorageKey)
+	}
+
+	tx := config.DB.WithContext(c).Begin()
 	parent := models.Product{
 		Name:        payload.Name,
 		Description: payload.Description,
 		SKU:         payload.SKU,
 		Barcode:     payload.Barcode,
+		Brand:       payload.Brand,
 		Price:       payload.Price,
 		Currency:    payload.Currency,
 		CategoryID:  payload.CategoryID,
@@ -58,11 +97,46 @@ func CreateProduct(c *gin.Context) {
 		Featured:    payload.Featured,
 		Size:        payload.Size,
 		Images:      payload.Images,
+
+		PublishAt:    payload.PublishAt,
+		UnpublishAt:  payload.UnpublishAt,
+		SalePrice:    payload.SalePrice,
+		SaleStartsAt: payload.SaleStartsAt,
+		SaleEndsAt:   payload.SaleEndsAt,
+
+		ProductType:    payload.ProductType,
+		BundlePricing:  payload.BundlePricing,
+		BundleDiscount: payload.BundleDiscount,
 	}
 
 	if err := tx.Create(&parent).Error; err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product", "message": err.Error()})
+		return
+	}
+
+	if err := models.SetProductAttributes(tx, &parent, payload.Attributes); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A bundle has no stock of its own, its availability comes from its components
+	if parent.IsBundle() {
+		if err := models.SetBundleComponents(tx, &parent, payload.Components); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		committed = true
+		media.QueueRenditions()
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Product added successfully"})
 		return
 	}
 
@@ -75,6 +149,7 @@ func CreateProduct(c *gin.Context) {
 				Description: parent.Description,
 				SKU:         parent.SKU + "-" + variation.Size,
 				Barcode:     parent.Barcode,
+				Brand:       parent.Brand,
 				Price:       variation.Price,
 				Currency:    parent.Currency,
 				CategoryID:  parent.CategoryID,
@@ -82,6 +157,15 @@ func CreateProduct(c *gin.Context) {
 				IsChild:     true,
 				ParentID:    &parent.ID,
 				Size:        variation.Size,
+
+				PublishAt:    parent.PublishAt,
+				UnpublishAt:  parent.UnpublishAt,
+				SalePrice:    variation.SalePrice,
+				SaleStartsAt: parent.SaleStartsAt,
+				SaleEndsAt:   parent.SaleEndsAt,
+
+				// Gift card denominations are gift cards too
+				ProductType: parent.ProductType,
 			})
 		}
 
@@ -109,7 +193,12 @@ func CreateProduct(c *gin.Context) {
 		return
 	}
 
-	tx.Commit()
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	committed = true
+	media.QueueRenditions()
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Product added successfully"})
 }
@@ -131,16 +220,18 @@ func SearchProducts(c *gin.Context) {
 	type Product struct {
 		ID   uint   `gorm:"primarykey"`
 		Name string `gorm:"size:150;not null"`
+		Slug *string
 		// Description  string          `gorm:"type:text"`
 	}
 
 	var products []*Product
 
 	config.DB.Model(&products).
-		Select(`products.name, products.id`).
+		Select(`products.name, products.id, products.slug`).
 		Joins("LEFT JOIN categories ON products.category_id = categories.id").
 		Where(searchQuery).
-		Where("products.status = ? AND products.parent_id IS NULL", "published").
+		Where(utils.PublishedSQL("products")).
+		Where("products.parent_id IS NULL").
 		Group("products.id").Find(&products)
 
 	c.JSON(http.StatusOK, &products)
@@ -152,6 +243,11 @@ func GetProducts(c *gin.Context) {
 		return
 	}
 	querystring := utils.ProductQueryParameterToMap(params)
+	filters, err := attributeFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	type Inventory struct {
 		ProductID  uint           `gorm:"not null" json:"-"`
@@ -161,19 +257,26 @@ func GetProducts(c *gin.Context) {
 
 	type Product struct {
 		gorm.Model
-		Name         string          `gorm:"size:150;not null"`
-		Description  string          `gorm:"type:text"`
-		SKU          string          `gorm:"size:150;not null;unique;index"`
-		Barcode      *string         `gorm:"size:150"`
-		Price        float64         `gorm:"type:decimal(10,2);not null"`
-		Currency     string          `gorm:"size:3; not null"`
-		CategoryID   uint            `gorm:"not null"`
-		Category     models.Category `gorm:"foreignKey:CategoryID"`
-		Status       *string         `gorm:"not null;check:status IN ('published', 'unpublished')"`
-		Inventory    *Inventory      `gorm:"foreignKey:ProductID"`
-		TotalReviews int
-		Rating       int
-		Images       []models.ProductImage `gorm:"foreignKey:ProductID"`
+		Name               string  `gorm:"size:150;not null"`
+		Description        string  `gorm:"type:text"`
+		SKU                string  `gorm:"size:150;not null;unique;index"`
+		Barcode            *string `gorm:"size:150"`
+		Price              float64 `gorm:"type:decimal(10,2);not null"`
+		CompareAtPrice     float64 // Regular price, higher than Price while on sale
+		OnSale             bool
+		DiscountPercentage float64
+		Currency           string          `gorm:"size:3; not null"`
+		CategoryID         uint            `gorm:"not null"`
+		Category           models.Category `gorm:"foreignKey:CategoryID"`
+		Status             *string         `gorm:"not null;check:status IN ('published', 'unpublished')"`
+		Inventory          *Inventory      `gorm:"foreignKey:ProductID"`
+		TotalReviews       int
+		Rating             int
+		Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+		Slug               *string
+		ProductType        string
+		Available          int      // Quantity that can be ordered, for bundles limited by their components
+		Badges             []string `gorm:"-"` // Of the automatic promotions running on the product
 	}
 
 	var products []*Product
@@ -188,7 +291,7 @@ func GetProducts(c *gin.Context) {
 				products.description, 
 				products.sku, 
 				products.barcode, 
-				p.price,
+				`+utils.ListingPricingSelect()+`,
 				products.currency, 
 				products.category_id, 
 				products.status, 
@@ -196,14 +299,18 @@ func GetProducts(c *gin.Context) {
 				products.is_child, 
 				products.parent_id, 
 				products.size, 
+				products.slug, 
+				products.product_type, 
+				`+utils.AvailableStockSQL("products")+` AS available,
 				count(reviews.id) as total_reviews,
 				AVG(reviews.rating)::int as rating
 			`).
 		Joins("LEFT JOIN reviews ON products.id = reviews.product_id").
-		Joins("LEFT JOIN (SELECT parent_id, MIN(price) AS price from products WHERE is_child = true group by parent_id) p ON p.parent_id = products.id ").
+		Joins(utils.VariationPricingJoin()).
 		Where(querystring).
+		Scopes(filters).
 		Where("is_child = ?", false).
-		Group("products.id, p.parent_id, p.price")
+		Group("products.id, p.parent_id, p.price, p.regular_price")
 
 	pg := paginate.New()
 	page := pg.With(model).Request(c.Request).Response(&products)
@@ -213,6 +320,15 @@ func GetProducts(c *gin.Context) {
 		return
 	}
 
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	badges := promotionBadges(ids)
+	for _, product := range products {
+		product.Badges = badges[product.ID]
+	}
+
 	c.JSON(http.StatusOK, &page)
 }
 func GetNewArrivalProducts(c *gin.Context) {
@@ -229,19 +345,26 @@ func GetNewArrivalProducts(c *gin.Context) {
 	}
 	type Product struct {
 		gorm.Model
-		Name         string                `gorm:"size:150;not null"`
-		Description  string                `gorm:"type:text"`
-		SKU          string                `gorm:"size:150;not null;unique;index"`
-		Barcode      *string               `gorm:"size:150"`
-		Price        float64               `gorm:"type:decimal(10,2);not null"`
-		Currency     string                `gorm:"size:3; not null"`
-		Images       []models.ProductImage `gorm:"foreignKey:ProductID"`
-		CategoryID   uint                  `gorm:"not null"`
-		Category     models.Category       `gorm:"foreignKey:CategoryID"`
-		Status       *string               `gorm:"not null;check:status IN ('published', 'unpublished')"`
-		Inventory    *Inventory            `gorm:"foreignKey:ProductID"`
-		TotalReviews int
-		Rating       int
+		Name               string  `gorm:"size:150;not null"`
+		Description        string  `gorm:"type:text"`
+		SKU                string  `gorm:"size:150;not null;unique;index"`
+		Barcode            *string `gorm:"size:150"`
+		Price              float64 `gorm:"type:decimal(10,2);not null"`
+		CompareAtPrice     float64 // Regular price, higher than Price while on sale
+		OnSale             bool
+		DiscountPercentage float64
+		Currency           string                `gorm:"size:3; not null"`
+		Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+		CategoryID         uint                  `gorm:"not null"`
+		Category           models.Category       `gorm:"foreignKey:CategoryID"`
+		Status             *string               `gorm:"not null;check:status IN ('published', 'unpublished')"`
+		Inventory          *Inventory            `gorm:"foreignKey:ProductID"`
+		TotalReviews       int
+		Rating             int
+		Slug               *string
+		ProductType        string
+		Available          int      // Quantity that can be ordered, for bundles limited by their components
+		Badges             []string `gorm:"-"` // Of the automatic promotions running on the product
 	}
 
 	var products []*Product
@@ -249,13 +372,16 @@ func GetNewArrivalProducts(c *gin.Context) {
 
 	model = config.DB.Model(&products).Preload("Category").Preload("Inventory").Preload("Images").
 		Select(`products.*, 
+				` + utils.ListingPricingSelect() + `,
+				` + utils.AvailableStockSQL("products") + ` AS available,
 				count(reviews.id) as total_reviews,
 				AVG(reviews.rating)::int as rating
 			`).
 		Joins("LEFT JOIN reviews ON products.id = reviews.product_id").
+		Joins(utils.VariationPricingJoin()).
 		Where(querstring).
 		Where("is_child = false").
-		Group("products.id").
+		Group("products.id, p.parent_id, p.price, p.regular_price").
 		Order("products.created_at DESC")
 
 	pg := paginate.New()
@@ -266,6 +392,15 @@ func GetNewArrivalProducts(c *gin.Context) {
 		return
 	}
 
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	badges := promotionBadges(ids)
+	for _, product := range products {
+		product.Badges = badges[product.ID]
+	}
+
 	c.JSON(http.StatusOK, &page)
 }
 func GetTrendingProducts(c *gin.Context) {
@@ -282,19 +417,26 @@ func GetTrendingProducts(c *gin.Context) {
 	}
 	type Product struct {
 		gorm.Model
-		Name         string                `gorm:"size:150;not null"`
-		Description  string                `gorm:"type:text"`
-		SKU          string                `gorm:"size:150;not null;unique;index"`
-		Barcode      *string               `gorm:"size:150"`
-		Price        float64               `gorm:"type:decimal(10,2);not null"`
-		Currency     string                `gorm:"size:3; not null"`
-		Images       []models.ProductImage `gorm:"foreignKey:ProductID"`
-		CategoryID   uint                  `gorm:"not null"`
-		Category     models.Category       `gorm:"foreignKey:CategoryID"`
-		Status       *string               `gorm:"not null;check:status IN ('published', 'unpublished')"`
-		Inventory    *Inventory            `gorm:"foreignKey:ProductID"`
-		TotalReviews int
-		Rating       int
+		Name               string  `gorm:"size:150;not null"`
+		Description        string  `gorm:"type:text"`
+		SKU                string  `gorm:"size:150;not null;unique;index"`
+		Barcode            *string `gorm:"size:150"`
+		Price              float64 `gorm:"type:decimal(10,2);not null"`
+		CompareAtPrice     float64 // Regular price, higher than Price while on sale
+		OnSale             bool
+		DiscountPercentage float64
+		Currency           string                `gorm:"size:3; not null"`
+		Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+		CategoryID         uint                  `gorm:"not null"`
+		Category           models.Category       `gorm:"foreignKey:CategoryID"`
+		Status             *string               `gorm:"not null;check:status IN ('published', 'unpublished')"`
+		Inventory          *Inventory            `gorm:"foreignKey:ProductID"`
+		TotalReviews       int
+		Rating             int
+		Slug               *string
+		ProductType        string
+		Available          int      // Quantity that can be ordered, for bundles limited by their components
+		Badges             []string `gorm:"-"` // Of the automatic promotions running on the product
 	}
 
 	var products []*Product
@@ -309,7 +451,7 @@ func GetTrendingProducts(c *gin.Context) {
 				products.description, 
 				products.sku, 
 				products.barcode, 
-				p.price,
+				`+utils.ListingPricingSelect()+`,
 				products.currency, 
 				products.category_id, 
 				products.status, 
@@ -317,15 +459,18 @@ func GetTrendingProducts(c *gin.Context) {
 				products.is_child, 
 				products.parent_id, 
 				products.size, 
+				products.slug, 
+				products.product_type, 
+				`+utils.AvailableStockSQL("products")+` AS available,
 				count(reviews.id) as total_reviews,
 				AVG(reviews.rating)::int as rating
 			`).
 		Joins("LEFT JOIN reviews ON products.id = reviews.product_id").
-		Joins("LEFT JOIN (SELECT parent_id, MIN(price) AS price from products WHERE is_child = true group by parent_id) p ON p.parent_id = products.id ").
+		Joins(utils.VariationPricingJoin()).
 		Joins("LEFT JOIN order_items on products.id = order_items.product_id").
 		Where(querstring).
 		Where("is_child = ?", false).
-		Group("products.id, p.parent_id, p.price").
+		Group("products.id, p.parent_id, p.price, p.regular_price").
 		Order("COUNT(distinct order_items.order_id) DESC")
 
 	pg := paginate.New()
@@ -336,10 +481,19 @@ func GetTrendingProducts(c *gin.Context) {
 		return
 	}
 
+	ids := make([]uint, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	badges := promotionBadges(ids)
+	for _, product := range products {
+		product.Badges = badges[product.ID]
+	}
+
 	c.JSON(http.StatusOK, &page)
 }
 
-// GetProduct retrieves a single product by its ID
+// GetProduct retrieves a single product by its ID or slug
 func GetSingleProduct(c *gin.Context) {
 	productID := c.Param("id")
 
@@ -362,26 +516,84 @@ func GetSingleProduct(c *gin.Context) {
 	}
 	type Product struct {
 		gorm.Model
-		Name         string          `gorm:"size:150;not null"`
-		Description  string          `gorm:"type:text"`
-		SKU          string          `gorm:"size:150;not null;unique;index"`
-		Barcode      *string         `gorm:"size:150"`
-		Price        float64         `gorm:"type:decimal(10,2);not null"`
-		Currency     string          `gorm:"size:3; not null"`
-		CategoryID   uint            `gorm:"not null"`
-		Category     models.Category `gorm:"foreignKey:CategoryID"`
-		Status       *string         `gorm:"not null;check:status IN ('published', 'unpublished')"`
-		Inventory    *Inventory      `gorm:"foreignKey:ProductID"`
-		TotalReviews int
-		Rating       int
-		Variation    json.RawMessage
-		Images       []models.ProductImage `gorm:"foreignKey:ProductID"`
+		Name               string  `gorm:"size:150;not null"`
+		Description        string  `gorm:"type:text"`
+		SKU                string  `gorm:"size:150;not null;unique;index"`
+		Barcode            *string `gorm:"size:150"`
+		Price              float64 `gorm:"type:decimal(10,2);not null"`
+		CompareAtPrice     float64 // Regular price, higher than Price while on sale
+		OnSale             bool
+		DiscountPercentage float64
+		Brand              *string
+		SalePrice          *float64
+		SaleStartsAt       *time.Time
+		SaleEndsAt         *time.Time
+		PublishAt          *time.Time
+		UnpublishAt        *time.Time
+		Currency           string          `gorm:"size:3; not null"`
+		CategoryID         uint            `gorm:"not null"`
+		Category           models.Category `gorm:"foreignKey:CategoryID"`
+		Status             *string         `gorm:"not null;check:status IN ('published', 'unpublished')"`
+		Featured           bool
+		Size               string
+		Inventory          *Inventory `gorm:"foreignKey:ProductID"`
+		TotalReviews       int
+		Rating             int
+		Variation          json.RawMessage
+		Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+		Slug               *string
+		MetaTitle          *string
+		MetaDescription    *string
+		CanonicalURL       *string
+		ProductType        string
+		BundlePricing      *string
+		BundleDiscount     float64
+		BundleComponents   []models.BundleComponent  `gorm:"foreignKey:BundleID"`
+		Available          int                       // Quantity that can be ordered, for bundles limited by their components
+		Attributes         []models.ProductAttribute `gorm:"foreignKey:ProductID"`
 	}
 
 	var product *Product
 
-	model := config.DB.Debug().Model(&product).Preload("Category").Preload("Inventory").Preload("Images").
-		Select(`products.*, 
+	// Unpublished and scheduled products, and their variations, are only shown to admins
+	published := func(db *gorm.DB) *gorm.DB {
+		if c.GetString("role") == "admin" {
+			return db
+		}
+		return db.Joins("JOIN products AS listed ON listed.id = COALESCE(products.parent_id, products.id)").
+			Where(utils.PublishedSQL("listed"))
+	}
+
+	model := config.DB.Debug().Model(&product).Preload("Category").Preload("Inventory").Preload("Images").Preload("BundleComponents.Component").
+		Preload("Attributes", func(db *gorm.DB) *gorm.DB { return db.Preload("Definition").Order("product_attributes.id") }).
+		Select(`products.id, 
+				products.created_at, 
+				products.updated_at, 
+				products.deleted_at, 
+				products.name, 
+				products.description, 
+				products.sku, 
+				products.barcode, 
+				products.brand, 
+				`+utils.SalePricingSelect(utils.EffectivePriceSQL("products"), "products.price")+`,
+				products.sale_price, 
+				products.sale_starts_at, 
+				products.sale_ends_at, 
+				products.publish_at, 
+				products.unpublish_at, 
+				products.currency, 
+				products.category_id, 
+				products.status, 
+				products.featured, 
+				products.size, 
+				products.slug, 
+				products.meta_title, 
+				products.meta_description, 
+				products.canonical_url, 
+				products.product_type, 
+				products.bundle_pricing, 
+				products.bundle_discount, 
+				`+utils.AvailableStockSQL("products")+` AS available,
 				count(reviews.id) as total_reviews,
 				AVG(reviews.rating)::int as rating,
 				COALESCE(
@@ -389,7 +601,9 @@ func GetSingleProduct(c *gin.Context) {
 						json_build_object(
 						'id', variations.id,
 						'size', variations.size,
-						'price', variations.price
+						'price', `+utils.EffectivePriceSQL("variations")+`,
+						'compare_at_price', variations.price,
+						'on_sale', COALESCE(`+utils.SaleActiveSQL("variations")+`, false)
 						)
 					)FILTER (WHERE variations.deleted_at IS NULL),
             		'[]'
@@ -397,12 +611,16 @@ func GetSingleProduct(c *gin.Context) {
 			`).
 		Joins("LEFT JOIN reviews ON products.id = reviews.product_id").
 		Joins("LEFT JOIN products AS variations ON variations.parent_id = products.id AND variations.is_child = true").
-		Where("products.id = ?", productID).
+		Where(idOrSlugColumn("products", productID)+" = ?", productID).
+		Scopes(published).
 		Group("products.id").
 		First(&product)
 
 	if model.Error != nil {
 		if errors.Is(model.Error, gorm.ErrRecordNotFound) {
+			if redirectOldSlug(c, "product", "products", productID) {
+				return
+			}
 			c.JSON(http.StatusNotFound, gin.H{"message": "No product found"})
 			return
 
@@ -411,6 +629,12 @@ func GetSingleProduct(c *gin.Context) {
 		return
 	}
 
+	product.MetaTitle = metaTitle(product.MetaTitle, product.Name)
+	product.CanonicalURL = canonicalURL(product.CanonicalURL, "products", product.Slug)
+
+	userID, sessionID := viewer(c)
+	catalog.RecordProductView(userID, sessionID, product.ID)
+
 	c.JSON(http.StatusOK, &product)
 }
 
@@ -428,19 +652,117 @@ func UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	if err := c.ShouldBindJSON(&product); err != nil {
+	var payload struct {
+		Attributes []models.ProductAttribute // Replaces the structured attributes when given
+	}
+	previousPrice := product.EffectivePrice(time.Now())
+	if err := c.ShouldBindBodyWith(&product, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindBodyWith(&payload, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := config.DB.Save(&product).Error; err != nil {
+	// Existing images are managed by their own endpoints and left as they are. New base64 images are
+	// stored before the transaction and removed again unless it commits.
+	var uploads []models.ProductImage
+	var keys []string
+	committed := false
+	defer func() {
+		if !committed {
+			removeStoredFiles(c, keys)
+		}
+	}()
+	for _, image := range product.Images {
+		if image.ID != 0 || image.Image == "" {
+			continue
+		}
+		if err := image.StoreUpload(c.Request.Context()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		keys = append(keys, image.StorageKey)
+		image.ProductID = product.ID
+		uploads = append(uploads, image)
+	}
+
+	var attributesErr error
+	err := config.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Images").Save(&product).Error; err != nil {
+			return err
+		}
+		if len(uploads) > 0 {
+			if err := tx.Create(&uploads).Error; err != nil {
+				return err
+			}
+		}
+		if payload.Attributes != nil {
+			attributesErr = models.SetProductAttributes(tx, product, payload.Attributes)
+		} else {
+			// The product may have moved to a category with other required attributes
+			attributesErr = models.ValidateProductAttributes(tx, product)
+		}
+		return attributesErr
+	})
+	if attributesErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": attributesErr.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	committed = true
+	if len(uploads) > 0 {
+		media.QueueRenditions()
+	}
+	config.DB.Where("product_id = ?", product.ID).Find(&product.Images)
+
+	if product.EffectivePrice(time.Now()) < previousPrice {
+		events.Publish(events.ProductPriceDropped, events.ProductEvent{ProductID: product.ID})
+	}
 
 	c.JSON(http.StatusOK, product)
 }
 
+// SetBundleComponents replaces the component products of a bundle
+func SetBundleComponents(c *gin.Context) {
+	productID := c.Param("id")
+	var product models.Product
+
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	var payload struct {
+		Components []models.BundleComponent `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := config.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		return models.SetBundleComponents(tx, &product, payload.Components)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var components []models.BundleComponent
+	config.DB.Preload("Component").Where("bundle_id = ?", product.ID).Find(&components)
+
+	c.JSON(http.StatusOK, components)
+}
+
 // DeleteProduct deletes a product by its ID
 func DeleteProduct(c *gin.Context) {
 	productID := c.Param("id")
@@ -455,7 +777,7 @@ func DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Delete(&product).Error; err != nil {
+	if err := config.DB.WithContext(c).Delete(&product).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -472,7 +794,7 @@ func CreateProductAttribute(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Create(&productAttribute).Error; err != nil {
+	if err := config.DB.WithContext(c).Create(&productAttribute).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -480,11 +802,16 @@ func CreateProductAttribute(c *gin.Context) {
 	c.JSON(http.StatusOK, productAttribute)
 }
 
-// GetProductAttributes retrieves all product attributes
+// GetProductAttributes retrieves the product attributes, of a single product with ?product_id=
 func GetProductAttributes(c *gin.Context) {
 	var productAttributes []*models.ProductAttribute
 
-	if err := config.DB.Preload("Product").Find(&productAttributes).Error; err != nil {
+	model := config.DB.Preload("Definition")
+	if productID := c.Query("product_id"); productID != "" {
+		model = model.Where("product_id = ?", productID)
+	}
+
+	if err := model.Order("product_id, id").Find(&productAttributes).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -511,7 +838,7 @@ func UpdateProductAttribute(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Save(&productAttribute).Error; err != nil {
+	if err := config.DB.WithContext(c).Save(&productAttribute).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -533,7 +860,7 @@ func DeleteProductAttribute(c *gin.Context) {
 		return
 	}
 
-	if err := config.DB.Delete(&productAttribute).Error; err != nil {
+	if err := config.DB.WithContext(c).Delete(&productAttribute).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"backend/catalog"
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImportProducts validates an uploaded csv or xlsx file and upserts its products by SKU in the background.
+// With dry_run=true only the validation is performed.
+func ImportProducts(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format, err := catalog.FormatFromFileName(file.Filename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	lines, err := catalog.ReadSheet(src, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file: " + err.Error()})
+		return
+	}
+
+	rows, rowErrors, err := catalog.ParseRows(lines)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", c.PostForm("dry_run")))
+
+	job := models.ProductImportJob{
+		UserID:   c.GetUint("user_id"),
+		FileName: file.Filename,
+		DryRun:   dryRun,
+		Status:   "pending",
+	}
+	if err := config.DB.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	jobs.Enqueue(fmt.Sprintf("product import %d", job.ID), func(ctx context.Context) error {
+		catalog.RunImport(ctx, job.ID, rows, rowErrors)
+		return nil
+	})
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetProductImport returns the progress and results of a product import
+func GetProductImport(c *gin.Context) {
+	jobID := c.Param("id")
+	var job *models.ProductImportJob
+
+	if err := config.DB.First(&job, jobID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// ExportProducts downloads the catalogue in the import format, as csv (default) or xlsx
+func ExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+
+	contentTypes := map[string]string{
+		"csv":  "text/csv",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": catalog.ErrUnsupportedFormat.Error()})
+		return
+	}
+
+	rows, err := catalog.ExportRows(config.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := "products-" + time.Now().Format("20060102") + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	if err := catalog.WriteSheet(c.Writer, format, rows); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
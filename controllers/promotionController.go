@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/promotions"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetPromotions lists all automatic promotions, highest priority first
+func GetPromotions(c *gin.Context) {
+	var promotions []models.Promotion
+	if err := config.DB.Preload("Scopes").Preload("Tiers").Order("priority DESC, id").Find(&promotions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, promotions)
+}
+
+// GetActivePromotions lists the promotions running now for the storefront
+func GetActivePromotions(c *gin.Context) {
+	now := time.Now()
+	var promotions []models.Promotion
+	if err := config.DB.Preload("Scopes").Preload("Tiers").
+		Where("is_active = true AND start_date <= ? AND (expiration_date IS NULL OR expiration_date > ?)", now, now).
+		Order("priority DESC, id").
+		Find(&promotions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+	c.JSON(http.StatusOK, promotions)
+}
+
+// GetPromotion returns a promotion with its scopes and tiers
+func GetPromotion(c *gin.Context) {
+	var promotion models.Promotion
+	if err := config.DB.Preload("Scopes").Preload("Tiers").First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	c.JSON(http.StatusOK, promotion)
+}
+
+// CreatePromotion creates an automatic promotion with its scopes and tiers
+func CreatePromotion(c *gin.Context) {
+	var promotion models.Promotion
+	if err := c.ShouldBindJSON(&promotion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range promotion.Scopes {
+		promotion.Scopes[i].ID, promotion.Scopes[i].CouponID, promotion.Scopes[i].CampaignID = 0, nil, nil
+	}
+	for i := range promotion.Tiers {
+		promotion.Tiers[i].ID = 0
+	}
+	if err := config.DB.Create(&promotion).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create promotion", "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, promotion)
+}
+
+// UpdatePromotion changes a promotion, Scopes and Tiers replace the existing ones when given
+func UpdatePromotion(c *gin.Context) {
+	var promotion models.Promotion
+	if err := config.DB.Preload("Scopes").Preload("Tiers").First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	if err := c.ShouldBindJSON(&promotion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Scopes", "Tiers").Save(&promotion).Error; err != nil {
+			return err
+		}
+		if err := replaceScopes(tx, "promotion_id", promotion.ID, promotion.Scopes); err != nil {
+			return err
+		}
+		if err := tx.Where("promotion_id = ?", promotion.ID).Delete(&models.PromotionTier{}).Error; err != nil {
+			return err
+		}
+		for i := range promotion.Tiers {
+			promotion.Tiers[i].ID, promotion.Tiers[i].PromotionID = 0, promotion.ID
+		}
+		if len(promotion.Tiers) == 0 {
+			return nil
+		}
+		return tx.Create(&promotion.Tiers).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion updated"})
+}
+
+// DeletePromotion deletes a promotion, orders keep the discounts they got from it
+func DeletePromotion(c *gin.Context) {
+	if err := config.DB.Delete(&models.Promotion{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete promotion"})
+		return
+	}
+	c.JSON(http.StatusNoContent, gin.H{"message": "Promotion deleted"})
+}
+
+// promotionBadges returns the badges of the promotions running on listed products. Badges are left out
+// rather than failing the listing when they cannot be loaded.
+func promotionBadges(productIDs []uint) map[uint][]string {
+	badges, err := promotions.ListingBadges(config.DB, productIDs, time.Now())
+	if err != nil {
+		log.Printf("failed to load promotion badges: %v", err)
+	}
+	return badges
+}
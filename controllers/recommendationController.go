@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productCard is a product as listed in recommendations and recently viewed products
+type productCard struct {
+	ID                 uint `gorm:"primarykey"`
+	Name               string
+	Slug               *string
+	Price              float64
+	CompareAtPrice     float64 // Regular price, higher than Price while on sale
+	OnSale             bool
+	DiscountPercentage float64
+	Currency           string
+	Available          int
+	Images             []models.ProductImage `gorm:"foreignKey:ProductID"`
+}
+
+func (productCard) TableName() string {
+	return "products"
+}
+
+// productCardQuery selects published parent products with their current pricing and availability
+func productCardQuery() *gorm.DB {
+	return config.DB.Model(&productCard{}).Preload("Images").
+		Select(`products.id, 
+				products.name, 
+				products.slug, 
+				products.currency, 
+				` + utils.ListingPricingSelect() + `,
+				` + utils.AvailableStockSQL("products") + ` AS available`).
+		Joins(utils.VariationPricingJoin()).
+		Where("products.is_child = false").
+		Where(utils.PublishedSQL("products"))
+}
+
+// recommendedProducts lists published, in stock products joined to the given source of recommendations
+func recommendedProducts(join string, order string, limit int, args ...interface{}) ([]*productCard, error) {
+	products := []*productCard{}
+
+	err := productCardQuery().
+		Joins(join, args...).
+		Where("(" + utils.AvailableStockSQL("products") + ") > 0").
+		Order(order).
+		Limit(limit).
+		Find(&products).Error
+
+	return products, err
+}
+
+// GetProductRecommendations returns the curated related, up-sell and cross-sell products of a product
+// along with the products frequently bought together with it
+func GetProductRecommendations(c *gin.Context) {
+	productID := c.Param("id")
+
+	var product models.Product
+	if err := config.DB.Select("id").Where(idOrSlugColumn("products", productID)+" = ?", productID).First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 8
+	}
+
+	response := gin.H{}
+	for key, linkType := range map[string]string{"Related": "related", "Upsell": "upsell", "CrossSell": "cross_sell"} {
+		products, err := recommendedProducts(
+			"JOIN product_links ON product_links.linked_product_id = products.id AND product_links.product_id = ? AND product_links.link_type = ?",
+			"product_links.position, product_links.id", limit, product.ID, linkType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response[key] = products
+	}
+
+	products, err := recommendedProducts(
+		"JOIN product_affinities ON product_affinities.related_product_id = products.id AND product_affinities.product_id = ?",
+		"product_affinities.confidence DESC, product_affinities.orders DESC", limit, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response["FrequentlyBoughtTogether"] = products
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetProductLinks returns the curated links of a product for the admin panel
+func GetProductLinks(c *gin.Context) {
+	productID := c.Param("id")
+	var links []*models.ProductLink
+
+	if err := config.DB.Preload("LinkedProduct").Where("product_id = ?", productID).Order("link_type, position, id").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+// SetProductLinks replaces the curated related, up-sell and cross-sell links of a product.
+// Links are shown in the order they are given unless a Position is set.
+func SetProductLinks(c *gin.Context) {
+	productID := c.Param("id")
+	var product models.Product
+
+	if err := config.DB.First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	var payload struct {
+		Links []models.ProductLink
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range payload.Links {
+		link := &payload.Links[i]
+		if link.LinkedProductID == product.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a product cannot be linked to itself"})
+			return
+		}
+		link.ID = 0
+		link.ProductID = product.ID
+		if link.Position == 0 {
+			link.Position = i + 1
+		}
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.ProductLink{}).Error; err != nil {
+			return err
+		}
+		if len(payload.Links) == 0 {
+			return nil
+		}
+		return tx.Omit("Product", "LinkedProduct").Create(&payload.Links).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, payload.Links)
+}
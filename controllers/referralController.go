@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/referrals"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// GetMyReferrals returns the referral code of the user with the customers they referred and the rewards
+// they earned
+func GetMyReferrals(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	code, err := referrals.CodeFor(config.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var referred []struct {
+		Name         string
+		Status       string
+		RewardAmount float64
+		CreatedAt    time.Time
+	}
+	if err := config.DB.Model(&models.Referral{}).
+		Select("users.name, CASE WHEN referrals.status = 'flagged' THEN 'pending' ELSE referrals.status END AS status, referrals.reward_amount, referrals.created_at").
+		Joins("JOIN users ON users.id = referrals.referee_id").
+		Where("referrals.referrer_id = ?", userID).
+		Order("referrals.id DESC").
+		Scan(&referred).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	earned := 0.0
+	for _, referral := range referred {
+		earned += referral.RewardAmount
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":          code,
+		"reward_amount": referrals.RewardAmount(), // Store credit for each referred customer's first delivered order
+		"earned":        earned,
+		"referrals":     referred,
+	})
+}
+
+// GetReferrals lists the referrals newest first, filtered by ?status and ?referrer_id
+func GetReferrals(c *gin.Context) {
+	var list []struct {
+		models.Referral
+		ReferrerName  string
+		ReferrerEmail string
+		RefereeName   string
+		RefereeEmail  string
+	}
+
+	model := config.DB.Model(&models.Referral{}).
+		Select("referrals.*, referrer.name AS referrer_name, referrer.email AS referrer_email, referee.name AS referee_name, referee.email AS referee_email").
+		Joins("JOIN users AS referrer ON referrer.id = referrals.referrer_id").
+		Joins("JOIN users AS referee ON referee.id = referrals.referee_id").
+		Order("referrals.id DESC")
+	if status := c.Query("status"); status != "" {
+		model = model.Where("referrals.status = ?", status)
+	}
+	if referrerID := c.Query("referrer_id"); referrerID != "" {
+		model = model.Where("referrals.referrer_id = ?", referrerID)
+	}
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&list)
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// GetReferralStats reports the referrals by status, what they cost and the revenue they brought
+func GetReferralStats(c *gin.Context) {
+	stats, err := referrals.GetStats(config.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetReferralChain returns who referred a customer, up the chain, and everyone they brought in
+func GetReferralChain(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	chain, err := referrals.GetChain(config.DB, uint(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, chain)
+}
+
+// ReviewReferral approves or rejects a referral flagged by the fraud guards
+func ReviewReferral(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral ID"})
+		return
+	}
+	var payload struct {
+		Status string `binding:"required,oneof=approved rejected"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var referral *models.Referral
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		referral, err = referrals.Review(tx, uint(id), payload.Status == "approved")
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
+	case errors.Is(err, referrals.ErrNotReviewable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, referral)
+	}
+}
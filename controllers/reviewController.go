@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"backend/config"
+	"backend/loyalty"
 	"backend/models"
 	"backend/serializers"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
@@ -151,6 +153,13 @@ func UpdateReview(c *gin.Context) {
 		return
 	}
 
+	// Reviews approved by an admin earn the review bonus, customers cannot approve their own
+	if c.GetString("role") == "admin" {
+		if err := loyalty.AwardReview(config.DB, review); err != nil {
+			log.Printf("Failed to award the review bonus for review %d: %v", review.ID, err)
+		}
+	}
+
 	// Return the updated review
 	c.JSON(http.StatusOK, gin.H{"review": review})
 }
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// productRevisionScope matches the revisions of a product, its variations and its attributes
+func productRevisionScope(db *gorm.DB, productID uint) *gorm.DB {
+	variations := config.DB.Unscoped().Model(&models.Product{}).Select("id").Where("parent_id = ?", productID)
+
+	return db.Where(
+		config.DB.Where("entity_type = 'product' AND (entity_id = ? OR entity_id IN (?))", productID, variations).
+			Or("entity_type = 'product_attribute' AND (snapshot->>'ProductID')::int = ?", productID),
+	)
+}
+
+// GetProductHistory lists the revisions of a product, its variations and attributes, newest first
+func GetProductHistory(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var revisions []*struct {
+		models.Revision
+		ActorName *string
+	}
+
+	model := productRevisionScope(config.DB.Model(&models.Revision{}), uint(productID)).
+		Select("revisions.*, users.name AS actor_name").
+		Joins("LEFT JOIN users ON users.id = revisions.actor_id").
+		Order("revisions.created_at DESC, revisions.id DESC")
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&revisions)
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// RestoreProductRevision puts a product or one of its variations back to the state recorded in a revision,
+// undeleting it if needed
+func RestoreProductRevision(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var revision *models.Revision
+	if err := productRevisionScope(config.DB, uint(productID)).
+		Where("entity_type = 'product'").
+		First(&revision, c.Param("revision_id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	var snapshot models.Product
+	if err := json.Unmarshal(revision.Snapshot, &snapshot); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Revision snapshot is invalid"})
+		return
+	}
+
+	var product *models.Product
+	if err := config.DB.Unscoped().First(&product, revision.EntityID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product has been permanently deleted"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	product.RestoreRevision(&snapshot)
+
+	ctx := models.WithRevisionAction(c, "restore")
+	if err := config.DB.WithContext(ctx).Unscoped().
+		Select(append(models.ProductRevisionFields, "DeletedAt")).
+		Save(&product).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product restored", "product": product})
+}
+
+// GetPriceHistory returns the regular and sale price of a product after every change that affected them
+func GetPriceHistory(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Query("product_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_id is required"})
+		return
+	}
+
+	var series []struct {
+		ProductID uint      `json:"product_id"`
+		Date      time.Time `json:"date"`
+		Price     *float64  `json:"price"`
+		SalePrice *float64  `json:"sale_price"`
+	}
+
+	variations := config.DB.Unscoped().Model(&models.Product{}).Select("id").Where("parent_id = ?", productID)
+
+	if err := config.DB.Model(&models.Revision{}).
+		Select(`entity_id AS product_id,
+				created_at AS date,
+				(snapshot->>'Price')::numeric AS price,
+				(snapshot->>'SalePrice')::numeric AS sale_price`).
+		Where("entity_type = 'product' AND (entity_id = ? OR entity_id IN (?))", productID, variations).
+		Where("action IN ('create', 'restore') OR changes->'Price' IS NOT NULL OR changes->'SalePrice' IS NOT NULL").
+		Order("created_at ASC, id ASC").
+		Scan(&series).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"price_history": series})
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// idOrSlugColumn returns the column a path parameter should be matched against, numeric values are IDs and anything else a slug
+func idOrSlugColumn(table string, value string) string {
+	if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return table + ".id"
+	}
+	return table + ".slug"
+}
+
+// redirectOldSlug answers with a permanent redirect when slug used to belong to an entity, it reports whether it did
+func redirectOldSlug(c *gin.Context, entityType string, table string, slug string) bool {
+	if idOrSlugColumn(table, slug) != table+".slug" {
+		return false
+	}
+
+	current, err := models.FindSlugRedirect(config.DB, entityType, table, slug)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return true
+		}
+		return false
+	}
+
+	path := c.Request.URL.Path
+	index := strings.LastIndex(path, "/"+slug)
+	location := path[:index] + "/" + current + path[index+len(slug)+1:]
+	if c.Request.URL.RawQuery != "" {
+		location += "?" + c.Request.URL.RawQuery
+	}
+	c.Redirect(http.StatusMovedPermanently, location)
+	return true
+}
+
+// canonicalURL is the storefront address of a page unless an explicit one was set
+func canonicalURL(explicit *string, section string, slug *string) *string {
+	if explicit != nil && *explicit != "" {
+		return explicit
+	}
+	if slug == nil {
+		return nil
+	}
+	url := config.StorefrontURL() + "/" + section + "/" + *slug
+	return &url
+}
+
+// metaTitle falls back to the name when no meta title was set
+func metaTitle(explicit *string, name string) *string {
+	if explicit != nil && *explicit != "" {
+		return explicit
+	}
+	return &name
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/loyalty"
+	"backend/models"
+	"backend/payments"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// storeCreditStatement responds with the store credit of a customer and their transactions, newest first
+func storeCreditStatement(c *gin.Context, userID uint) {
+	balance, err := payments.StoreCreditBalance(config.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	model := config.DB.Model(&models.StoreCreditTransaction{}).Where("user_id = ?", userID).Order("id DESC")
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(&[]models.StoreCreditTransaction{})
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balance": balance, "transactions": page})
+}
+
+// GetMyStoreCredit returns the store credit of the user
+func GetMyStoreCredit(c *gin.Context) {
+	storeCreditStatement(c, c.GetUint("user_id"))
+}
+
+// GetUserStoreCredit returns the store credit of a customer
+func GetUserStoreCredit(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	storeCreditStatement(c, uint(userID))
+}
+
+// IssueStoreCredit gives a customer store credit, as a refund of one of their orders or from support.
+// Refunds take back the loyalty points earned on the refunded share of the order, and a full refund voids the
+// gift cards bought on it.
+func IssueStoreCredit(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var payload struct {
+		Amount  float64 `binding:"required,gt=0"`
+		Kind    string  `binding:"required,oneof=refund support"`
+		OrderID *uint   // Required for refunds
+		Reason  string  `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.Kind == "refund" && payload.OrderID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OrderID is required for refunds"})
+		return
+	}
+
+	issuedBy := c.GetUint("user_id")
+	entry := models.StoreCreditTransaction{
+		UserID:     uint(userID),
+		Kind:       payload.Kind,
+		Amount:     payload.Amount,
+		OrderID:    payload.OrderID,
+		Reason:     payload.Reason,
+		IssuedByID: &issuedBy,
+	}
+
+	errRefundTooHigh := errors.New("refunds cannot exceed the order total")
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if payload.OrderID != nil {
+			var order models.Order
+			if err := tx.Select("id", "total_price").Where("user_id = ?", userID).First(&order, *payload.OrderID).Error; err != nil {
+				return err
+			}
+			var refunded float64
+			if err := tx.Model(&models.StoreCreditTransaction{}).
+				Select("COALESCE(SUM(amount), 0)").
+				Where("order_id = ? AND kind = 'refund'", order.ID).
+				Scan(&refunded).Error; err != nil {
+				return err
+			}
+			if refunded+payload.Amount > order.TotalPrice {
+				return errRefundTooHigh
+			}
+			// The points earned on the refunded part of the order are taken back
+			if payload.Kind == "refund" && order.TotalPrice > 0 {
+				if err := loyalty.ReverseEarned(tx, order.ID, payload.Amount/order.TotalPrice, "Order refunded"); err != nil {
+					return err
+				}
+			}
+			// Delivered orders are never cancelled, a full refund voids what is left on the gift cards bought on them
+			if payload.Kind == "refund" && refunded+payload.Amount >= order.TotalPrice {
+				if err := payments.VoidOrderGiftCards(tx, order.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return payments.ChangeStoreCredit(tx, &entry)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer or order not found"})
+	case errors.Is(err, errRefundTooHigh):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, entry)
+	}
+}
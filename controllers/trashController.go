@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"backend/config"
+	"backend/models"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/morkid/paginate"
+	"gorm.io/gorm"
+)
+
+// trashError answers with the status matching an error of a trash operation
+func trashError(c *gin.Context, err error) {
+	var conflict *models.TrashConflict
+	switch {
+	case errors.Is(err, models.ErrUnknownTrashEntity):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found in the trash"})
+	case errors.As(err, &conflict):
+		c.JSON(http.StatusConflict, gin.H{"error": conflict.Reason})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetTrash lists the soft deleted records of an entity, most recently deleted first
+func GetTrash(c *gin.Context) {
+	model, records, err := models.TrashQuery(config.DB, c.Param("entity"))
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	pg := paginate.New()
+	page := pg.With(model).Request(c.Request).Response(records)
+
+	if page.Error {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": page.ErrorMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, &page)
+}
+
+// RestoreFromTrash brings a soft deleted record back, unless an active record took its SKU, email or code
+// or what it belongs to is itself in the trash
+func RestoreFromTrash(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	ctx := models.WithRevisionAction(c, "restore")
+	record, err := models.RestoreFromTrash(config.DB.WithContext(ctx), c.Param("entity"), uint(id))
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Restored from the trash", "record": record})
+}
+
+// PurgeFromTrash permanently deletes a soft deleted record
+func PurgeFromTrash(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	files, err := models.PurgeFromTrash(config.DB.WithContext(c), c.Param("entity"), uint(id))
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+
+	removeStoredFiles(c, files)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Permanently deleted"})
+}
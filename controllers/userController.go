@@ -1,11 +1,18 @@
 package controllers
 
 import (
+	"backend/carts"
 	"backend/config"
+	"backend/mailer"
 	"backend/models"
+	"backend/referrals"
 	"backend/utils"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +36,7 @@ func RegisterCustomer(c *gin.Context) {
 		Address     *string `json:"address"`
 		Password    string  `json:"password" binding:"required"`
 		PhoneNumber string  `json:"phone_number"`
+		Referral    string  `json:"referral_code"` // Referral code of the customer who invited them
 	}
 
 	// Bind the JSON input to the struct
@@ -53,12 +61,38 @@ func RegisterCustomer(c *gin.Context) {
 		Address:      input.Address,
 	}
 
-	if err := config.DB.Create(&user).Error; err != nil {
+	deviceID := c.GetHeader("X-Device-ID")
+	var referral *models.Referral
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if input.Referral != "" {
+			if referral, err = referrals.Refer(tx, &user, input.Referral, deviceID); err != nil {
+				return err
+			}
+		}
+		return referrals.RecordDevice(tx, user.ID, deviceID)
+	})
+	if errors.Is(err, referrals.ErrInvalidReferralCode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral code"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
+	// Guest orders placed with the email join the account once the customer follows the link
+	if err := sendEmailVerification(&user); err != nil {
+		log.Printf("Failed to send the email verification of user %d: %v", user.ID, err)
+	}
+
+	response := gin.H{"message": "User registered successfully"}
+	if referral != nil && referral.WelcomeCode != nil {
+		response["welcome_code"] = referral.WelcomeCode.Code
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func UpdateUser(c *gin.Context) {
@@ -75,10 +109,15 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	previousEmail := user.Email
 	if err := c.BindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// A new email has to be verified again before guest orders placed with it join the account
+	if !strings.EqualFold(strings.TrimSpace(user.Email), strings.TrimSpace(previousEmail)) {
+		user.EmailVerifiedAt = nil
+	}
 
 	if err := config.DB.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
@@ -88,6 +127,62 @@ func UpdateUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
 }
 
+// sendEmailVerification emails a one time link proving the email of the user is theirs
+func sendEmailVerification(user *models.User) error {
+	token, err := models.NewEmailVerification(config.DB, user)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address: %s/verify-email?token=%s\n\n"+
+		"Orders you placed as a guest with this address will then appear in your account. The link expires in %d hours.\n",
+		user.Name, config.StorefrontURL(), token, int(models.EmailVerificationTTL().Hours()))
+	return mailer.Send(user.Email, "Confirm your email address", body)
+}
+
+// RequestEmailVerification sends the signed in user a new link to verify their email
+func RequestEmailVerification(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Email already verified"})
+		return
+	}
+	if err := sendEmailVerification(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the verification email"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// VerifyEmail uses up an email verification link and attaches the guest orders placed with the email
+func VerifyEmail(c *gin.Context) {
+	var payload struct {
+		Token string `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var attached int64
+	err := config.DB.Transaction(func(tx *gorm.DB) (err error) {
+		_, attached, err = models.VerifyEmail(tx, payload.Token)
+		return err
+	})
+	if errors.Is(err, models.ErrInvalidEmailVerification) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "orders": attached})
+}
+
 // LoginUser handles user login
 func LoginUser(c *gin.Context) {
 	var input struct {
@@ -118,6 +213,24 @@ func LoginUser(c *gin.Context) {
 		return
 	}
 
+	if err := referrals.RecordDevice(config.DB, user.ID, c.GetHeader("X-Device-ID")); err != nil {
+		log.Printf("Failed to record the device of user %d: %v", user.ID, err)
+	}
+	// The cart the visitor filled before signing in joins their own
+	if cartID, ok := requestCartID(c); ok {
+		if err := carts.Merge(config.DB, cartID, user.ID); err != nil {
+			log.Printf("Failed to merge the cart of user %d: %v", user.ID, err)
+		} else {
+			c.SetCookie(carts.CookieName, "", -1, "/", "", c.Request.TLS != nil, true)
+		}
+	}
+	// Guest orders placed with a verified email since the last sign in join the account
+	if user.EmailVerifiedAt != nil {
+		if _, err := models.AttachGuestOrders(config.DB, user.ID, user.Email); err != nil {
+			log.Printf("Failed to attach the guest orders of user %d: %v", user.ID, err)
+		}
+	}
+
 	// Generate JWT token
 	token, err := utils.GenerateJWT(user.ID, user.Email, user.Role, user.Name)
 	if err != nil {
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"backend/jobs"
+	"context"
+	"sync"
+)
+
+const (
+	ProductPublished    = "product.published"
+	ProductUnpublished  = "product.unpublished"
+	ProductRestocked    = "product.restocked"     // Availability went from none to some
+	ProductPriceDropped = "product.price_dropped" // The current price went down
+)
+
+// Handler reacts to a published event
+type Handler func(ctx context.Context, payload interface{}) error
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string][]Handler{}
+)
+
+// Subscribe registers a handler for an event name
+func Subscribe(name string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	handlers[name] = append(handlers[name], handler)
+}
+
+// Publish hands an event to every subscribed handler, each run by a background worker
+func Publish(name string, payload interface{}) {
+	mu.RLock()
+	subscribed := handlers[name]
+	mu.RUnlock()
+
+	for _, handler := range subscribed {
+		handler := handler
+		jobs.Enqueue(name, func(ctx context.Context) error {
+			return handler(ctx, payload)
+		})
+	}
+}
+
+// ProductEvent is the payload of product.* events
+type ProductEvent struct {
+	ProductID uint
+}
@@ -0,0 +1,189 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrNotAnImage      = errors.New("file is not a supported image (jpeg, png, gif or webp)")
+	ErrTooLarge        = errors.New("image exceeds the maximum file size")
+	ErrTooManyPixels   = errors.New("image exceeds the maximum dimensions")
+	ErrUnknownFormat   = errors.New("unknown rendition format")
+	supportedMimeTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+)
+
+// Limits bounds the images accepted for upload
+type Limits struct {
+	MaxBytes  int
+	MaxWidth  int
+	MaxHeight int
+}
+
+// RenditionSpec describes a resized copy of an uploaded image
+type RenditionSpec struct {
+	Name  string
+	Width int
+}
+
+// DefaultMaxBytes is the largest image accepted when IMAGE_MAX_BYTES is not set
+const DefaultMaxBytes = 10 << 20
+
+// DefaultLimits reads IMAGE_MAX_BYTES, IMAGE_MAX_WIDTH and IMAGE_MAX_HEIGHT from the environment
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBytes:  envInt("IMAGE_MAX_BYTES", DefaultMaxBytes),
+		MaxWidth:  envInt("IMAGE_MAX_WIDTH", 6000),
+		MaxHeight: envInt("IMAGE_MAX_HEIGHT", 6000),
+	}
+}
+
+// Renditions reads IMAGE_RENDITIONS, a list of name:width pairs such as "thumbnail:160,card:480,zoom:1600"
+func Renditions() []RenditionSpec {
+	value := os.Getenv("IMAGE_RENDITIONS")
+	if value == "" {
+		value = "thumbnail:160,card:480,zoom:1600"
+	}
+
+	var specs []RenditionSpec
+	for _, part := range strings.Split(value, ",") {
+		name, width, found := strings.Cut(strings.TrimSpace(part), ":")
+		w, err := strconv.Atoi(width)
+		if !found || err != nil || w <= 0 {
+			continue
+		}
+		specs = append(specs, RenditionSpec{Name: name, Width: w})
+	}
+	return specs
+}
+
+// Formats reads IMAGE_RENDITION_FORMATS, defaulting to "jpeg,webp"
+func Formats() []string {
+	value := os.Getenv("IMAGE_RENDITION_FORMATS")
+	if value == "" {
+		value = "jpeg,webp"
+	}
+
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// Sanitize checks that data is an image within limits and re-encodes it, which drops EXIF and other metadata.
+// GIFs are returned untouched so animations survive.
+func Sanitize(data []byte, limits Limits) ([]byte, string, error) {
+	if limits.MaxBytes > 0 && len(data) > limits.MaxBytes {
+		return nil, "", ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !supportedMimeTypes[contentType] {
+		return nil, "", ErrNotAnImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrNotAnImage
+	}
+	if (limits.MaxWidth > 0 && config.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && config.Height > limits.MaxHeight) {
+		return nil, "", ErrTooManyPixels
+	}
+
+	if contentType == "image/gif" {
+		return data, contentType, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrNotAnImage
+	}
+
+	// JPEG and WebP originals are stored as JPEG, PNG keeps its transparency
+	format := "jpeg"
+	if contentType == "image/png" {
+		format = "png"
+	}
+
+	clean, err := Encode(img, format)
+	if err != nil {
+		return nil, "", err
+	}
+	return clean, http.DetectContentType(clean), nil
+}
+
+// Resize scales img down to width, keeping the aspect ratio. Images narrower than width are returned as is.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// Encode writes img in one of the "jpeg", "png" or "webp" formats
+func Encode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten draws img over a white background, as JPEG has no alpha channel
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
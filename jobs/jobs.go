@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Task is a unit of background work
+type Task func(ctx context.Context) error
+
+type job struct {
+	name     string
+	task     Task
+	attempts int
+}
+
+const maxAttempts = 3
+
+var (
+	queue   = make(chan job, 1024)
+	startMu sync.Mutex
+	started bool
+	ctx     = context.Background()
+)
+
+// Start launches the background workers. Tasks enqueued before Start are kept until workers are running.
+func Start(workers int) {
+	startMu.Lock()
+	defer startMu.Unlock()
+
+	if started {
+		return
+	}
+	started = true
+
+	for i := 0; i < workers; i++ {
+		go work()
+	}
+	log.Printf("Started %d background workers", workers)
+}
+
+// Enqueue schedules a task to be run by a background worker, retrying it with backoff on failure
+func Enqueue(name string, task Task) {
+	select {
+	case queue <- job{name: name, task: task}:
+	default:
+		log.Printf("job queue full, dropping %s", name)
+	}
+}
+
+// Every runs a task on a fixed interval until the process exits
+func Every(name string, interval time.Duration, task Task) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			Enqueue(name, task)
+		}
+	}()
+}
+
+// Daily runs a task once a day at the given offset from local midnight, e.g. 3*time.Hour for 03:00
+func Daily(name string, at time.Duration, task Task) {
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(at)
+			if !next.After(now) {
+				next = next.AddDate(0, 0, 1)
+			}
+
+			time.Sleep(time.Until(next))
+			Enqueue(name, task)
+		}
+	}()
+}
+
+func work() {
+	for j := range queue {
+		run(j)
+	}
+}
+
+func run(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("job %s panicked: %v", j.name, r)
+		}
+	}()
+
+	err := j.task(ctx)
+	if err == nil {
+		return
+	}
+
+	j.attempts++
+	if j.attempts >= maxAttempts {
+		log.Printf("job %s failed after %d attempts: %v", j.name, j.attempts, err)
+		return
+	}
+
+	log.Printf("job %s failed, retrying: %v", j.name, err)
+	time.AfterFunc(time.Duration(j.attempts*j.attempts)*5*time.Second, func() {
+		queue <- j
+	})
+}
@@ -0,0 +1,257 @@
+package loyalty
+
+import (
+	"backend/config"
+	"backend/jobs"
+	"backend/models"
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Start expires old points and awards the birthday bonuses every night
+func Start() {
+	jobs.Daily("loyalty points expiry", 3*time.Hour, func(ctx context.Context) error {
+		expired, err := ExpirePoints(config.DB.WithContext(ctx), time.Now())
+		log.Printf("Expired %d loyalty points", expired)
+		return err
+	})
+	jobs.Daily("loyalty birthday bonus", 6*time.Hour, func(ctx context.Context) error {
+		awarded, err := AwardBirthdays(config.DB.WithContext(ctx), time.Now())
+		log.Printf("Awarded the birthday bonus to %d customers", awarded)
+		return err
+	})
+}
+
+func activeRules(db *gorm.DB, kinds ...string) ([]models.LoyaltyRule, error) {
+	var rules []models.LoyaltyRule
+	err := db.Session(&gorm.Session{NewDB: true}).Where("is_active = true AND kind IN ?", kinds).Find(&rules).Error
+	return rules, err
+}
+
+// EarnForOrder awards the points of a delivered order, with its items loaded: the spend rules for what was
+// paid for its items after discounts, the category rules on top for items of their categories, times the
+// multiplier of the customer's tier. Shipping earns nothing. Points are only earned once per order.
+func EarnForOrder(tx *gorm.DB, order *models.Order) (int, error) {
+	// Guests have no account to earn points on
+	if order.UserID == nil {
+		return 0, nil
+	}
+	rules, err := activeRules(tx, "spend", "category")
+	if err != nil || len(rules) == 0 {
+		return 0, err
+	}
+
+	productIDs := make([]uint, len(order.OrderItems))
+	for i, item := range order.OrderItems {
+		productIDs[i] = item.ProductID
+	}
+	var products []models.Product
+	if err := tx.Select("id", "category_id").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return 0, err
+	}
+	categoryOf := map[uint]uint{}
+	for _, product := range products {
+		categoryOf[product.ID] = product.CategoryID
+	}
+
+	// Points per currency unit of each item
+	rates := make([]float64, len(order.OrderItems))
+	for _, rule := range rules {
+		categories := map[uint]bool{}
+		if rule.Kind == "category" {
+			ids, err := models.CategoryDescendantIDs(tx, *rule.CategoryID)
+			if err != nil {
+				return 0, err
+			}
+			for _, id := range ids {
+				categories[id] = true
+			}
+		}
+		for i, item := range order.OrderItems {
+			if rule.Kind == "spend" || categories[categoryOf[item.ProductID]] {
+				rates[i] += rule.PointsPerUnit
+			}
+		}
+	}
+
+	earned := 0.0
+	for i, item := range order.OrderItems {
+		paid := math.Max(item.PriceAtPurchase*float64(item.Quantity)-item.DiscountAmount, 0)
+		earned += paid * rates[i]
+	}
+
+	tier, _, _, err := TierOf(tx, *order.UserID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	points := int(math.Floor(earned * tier.Multiplier))
+	if points <= 0 {
+		return 0, nil
+	}
+
+	if err := lockCustomer(tx, *order.UserID); err != nil {
+		return 0, err
+	}
+	var earnedBefore int64
+	if err := tx.Model(&models.LoyaltyTransaction{}).Where("order_id = ? AND kind = 'earn'", order.ID).Count(&earnedBefore).Error; err != nil {
+		return 0, err
+	}
+	if earnedBefore > 0 {
+		return 0, nil
+	}
+	entry := models.LoyaltyTransaction{UserID: *order.UserID, Kind: "earn", Points: points, OrderID: &order.ID, Reason: "Order " + order.OrderIdentifier}
+	return points, record(tx, &entry, nil)
+}
+
+// AwardReview gives the review bonus for an approved review, once per customer and product
+func AwardReview(db *gorm.DB, review *models.Review) error {
+	if !review.Status {
+		return nil
+	}
+	rules, err := activeRules(db, "review")
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	points := 0
+	for _, rule := range rules {
+		points += rule.Points
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockCustomer(tx, review.UserID); err != nil {
+			return err
+		}
+		var awarded int64
+		if err := tx.Model(&models.LoyaltyTransaction{}).
+			Joins("JOIN reviews ON reviews.id = loyalty_transactions.review_id").
+			Where("loyalty_transactions.user_id = ? AND reviews.product_id = ?", review.UserID, review.ProductID).
+			Count(&awarded).Error; err != nil {
+			return err
+		}
+		if awarded > 0 {
+			return nil
+		}
+		return record(tx, &models.LoyaltyTransaction{UserID: review.UserID, Kind: "bonus", Points: points, ReviewID: &review.ID, Reason: "Review"}, nil)
+	})
+}
+
+// AwardBirthdays gives the birthday bonus to the customers whose birthday is on the given day, once a year.
+// Customers born on 29 February get it on 28 February in other years.
+func AwardBirthdays(db *gorm.DB, at time.Time) (int, error) {
+	rules, err := activeRules(db, "birthday")
+	if err != nil || len(rules) == 0 {
+		return 0, err
+	}
+	points := 0
+	for _, rule := range rules {
+		points += rule.Points
+	}
+
+	days := []int{at.Day()}
+	leap := time.Date(at.Year(), time.February, 29, 0, 0, 0, 0, time.UTC).Month() == time.February
+	if at.Month() == time.February && at.Day() == 28 && !leap {
+		days = append(days, 29)
+	}
+	var userIDs []uint
+	if err := db.Model(&models.User{}).
+		Where("EXTRACT(MONTH FROM birthday) = ? AND EXTRACT(DAY FROM birthday) IN ?", int(at.Month()), days).
+		Pluck("id", &userIDs).Error; err != nil {
+		return 0, err
+	}
+
+	reason := "Birthday " + strconv.Itoa(at.Year())
+	awarded := 0
+	for _, userID := range userIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockCustomer(tx, userID); err != nil {
+				return err
+			}
+			var given int64
+			if err := tx.Model(&models.LoyaltyTransaction{}).Where("user_id = ? AND kind = 'bonus' AND reason = ?", userID, reason).Count(&given).Error; err != nil {
+				return err
+			}
+			if given > 0 {
+				return nil
+			}
+			awarded++
+			return record(tx, &models.LoyaltyTransaction{UserID: userID, Kind: "bonus", Points: points, Reason: reason}, nil)
+		})
+		if err != nil {
+			return awarded, err
+		}
+	}
+	return awarded, nil
+}
+
+// ReverseEarned takes back share, between 0 and 1, of the points earned on an order, e.g. for a partial
+// refund. It never takes back more than was earned.
+func ReverseEarned(tx *gorm.DB, orderID uint, share float64, reason string) error {
+	var order models.Order
+	if err := tx.Select("id", "user_id").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	if order.UserID == nil {
+		return nil
+	}
+
+	var sums struct {
+		Earned   int
+		Reversed int
+	}
+	if err := tx.Model(&models.LoyaltyTransaction{}).
+		Select(`COALESCE(SUM(CASE WHEN kind = 'earn' THEN points END), 0) AS earned,
+			COALESCE(-SUM(CASE WHEN kind = 'reversal' AND points < 0 THEN points END), 0) AS reversed`).
+		Where("order_id = ?", orderID).
+		Scan(&sums).Error; err != nil {
+		return err
+	}
+
+	points := min(int(math.Ceil(float64(sums.Earned)*math.Min(share, 1))), sums.Earned-sums.Reversed)
+	if points <= 0 {
+		return nil
+	}
+	if err := lockCustomer(tx, *order.UserID); err != nil {
+		return err
+	}
+	entry := models.LoyaltyTransaction{UserID: *order.UserID, Kind: "reversal", Points: -points, OrderID: &orderID, Reason: reason}
+	return record(tx, &entry, &orderID)
+}
+
+// ReverseOrder takes back the points earned on a cancelled order and gives back the points redeemed on it
+func ReverseOrder(tx *gorm.DB, orderID uint) error {
+	if err := ReverseEarned(tx, orderID, 1, "Order cancelled"); err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := tx.Select("id", "user_id").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	if order.UserID == nil {
+		return nil
+	}
+	var sums struct {
+		Redeemed int
+		Returned int
+	}
+	if err := tx.Model(&models.LoyaltyTransaction{}).
+		Select(`COALESCE(-SUM(CASE WHEN kind = 'redeem' THEN points END), 0) AS redeemed,
+			COALESCE(SUM(CASE WHEN kind = 'reversal' AND points > 0 THEN points END), 0) AS returned`).
+		Where("order_id = ?", orderID).
+		Scan(&sums).Error; err != nil {
+		return err
+	}
+	if sums.Redeemed <= sums.Returned {
+		return nil
+	}
+	if err := lockCustomer(tx, *order.UserID); err != nil {
+		return err
+	}
+	entry := models.LoyaltyTransaction{UserID: *order.UserID, Kind: "reversal", Points: sums.Redeemed - sums.Returned, OrderID: &orderID, Reason: "Order cancelled"}
+	return record(tx, &entry, nil)
+}
@@ -0,0 +1,171 @@
+package loyalty
+
+import (
+	"backend/models"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotEnoughPoints = errors.New("not enough loyalty points")
+
+// PointValue is what one point takes off an order, from LOYALTY_POINT_VALUE (default 0.01)
+func PointValue() float64 {
+	value, err := strconv.ParseFloat(os.Getenv("LOYALTY_POINT_VALUE"), 64)
+	if err != nil || value <= 0 {
+		return 0.01
+	}
+	return value
+}
+
+// MinRedeemPoints is the fewest points that can be redeemed on an order, from LOYALTY_MIN_REDEEM_POINTS
+// (default 100)
+func MinRedeemPoints() int {
+	points, err := strconv.Atoi(os.Getenv("LOYALTY_MIN_REDEEM_POINTS"))
+	if err != nil || points < 0 {
+		return 100
+	}
+	return points
+}
+
+// expiry is when points earned at the given time expire, after LOYALTY_POINTS_EXPIRY_DAYS (default 365).
+// Points never expire when it is 0.
+func expiry(from time.Time) *time.Time {
+	days := 365
+	if value, err := strconv.Atoi(os.Getenv("LOYALTY_POINTS_EXPIRY_DAYS")); err == nil && value >= 0 {
+		days = value
+	}
+	if days == 0 {
+		return nil
+	}
+	at := from.AddDate(0, 0, days)
+	return &at
+}
+
+// Balance returns the points a customer has left
+func Balance(db *gorm.DB, userID uint) (int, error) {
+	var balance int
+	err := db.Session(&gorm.Session{NewDB: true}).Model(&models.LoyaltyTransaction{}).
+		Select("COALESCE(SUM(points), 0)").
+		Where("user_id = ?", userID).
+		Scan(&balance).Error
+	return balance, err
+}
+
+// Expiring returns the points of a customer that expire before the given time unless they are used
+func Expiring(db *gorm.DB, userID uint, before time.Time) (int, error) {
+	var points int
+	err := db.Session(&gorm.Session{NewDB: true}).Model(&models.LoyaltyTransaction{}).
+		Select("COALESCE(SUM(remaining), 0)").
+		Where("user_id = ? AND remaining > 0 AND expires_at < ?", userID, before).
+		Scan(&points).Error
+	return points, err
+}
+
+// lockCustomer keeps concurrent changes of a customer's points from using the same lots
+func lockCustomer(tx *gorm.DB, userID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error
+}
+
+// record saves a transaction of a locked customer. Positive transactions become lots, negative ones use
+// up lots, starting with the lot of the order when given and then the ones expiring first.
+func record(tx *gorm.DB, entry *models.LoyaltyTransaction, fromOrder *uint) error {
+	entry.ID = 0
+	if entry.Points > 0 {
+		entry.Remaining = entry.Points
+		if entry.ExpiresAt == nil {
+			entry.ExpiresAt = expiry(time.Now())
+		}
+		return tx.Create(entry).Error
+	}
+
+	entry.Remaining = 0
+	entry.ExpiresAt = nil
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	order := "expires_at ASC NULLS LAST, id"
+	if fromOrder != nil {
+		order = fmt.Sprintf("COALESCE(order_id = %d AND kind = 'earn', false) DESC, %s", *fromOrder, order)
+	}
+	var lots []models.LoyaltyTransaction
+	if err := tx.Where("user_id = ? AND remaining > 0", entry.UserID).Order(order).Find(&lots).Error; err != nil {
+		return err
+	}
+
+	// Whatever no lot covers, e.g. a reversal of points already spent, leaves the balance negative
+	left := -entry.Points
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+		used := min(lot.Remaining, left)
+		if err := tx.Model(&lot).Update("remaining", lot.Remaining-used).Error; err != nil {
+			return err
+		}
+		left -= used
+	}
+	return nil
+}
+
+// Adjust changes a customer's points by an admin, negative points take them off
+func Adjust(tx *gorm.DB, userID uint, points int, reason string) (*models.LoyaltyTransaction, error) {
+	if err := lockCustomer(tx, userID); err != nil {
+		return nil, err
+	}
+	entry := &models.LoyaltyTransaction{UserID: userID, Kind: "adjust", Points: points, Reason: reason}
+	return entry, record(tx, entry, nil)
+}
+
+// Redeem takes points off a customer for the discount they gave on an order. It runs in the order
+// transaction and fails when the customer no longer has the points.
+func Redeem(tx *gorm.DB, userID uint, orderID uint, points int) error {
+	if err := lockCustomer(tx, userID); err != nil {
+		return err
+	}
+	balance, err := Balance(tx, userID)
+	if err != nil {
+		return err
+	}
+	if points > balance {
+		return ErrNotEnoughPoints
+	}
+	return record(tx, &models.LoyaltyTransaction{UserID: userID, Kind: "redeem", Points: -points, OrderID: &orderID}, nil)
+}
+
+// ExpirePoints records the expiry of the points left in lots that expired before the given time
+func ExpirePoints(db *gorm.DB, before time.Time) (int, error) {
+	var lots []models.LoyaltyTransaction
+	if err := db.Where("remaining > 0 AND expires_at <= ?", before).Find(&lots).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, lot := range lots {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := lockCustomer(tx, lot.UserID); err != nil {
+				return err
+			}
+			// The lot may have been used since it was listed
+			var current models.LoyaltyTransaction
+			if err := tx.First(&current, lot.ID).Error; err != nil || current.Remaining == 0 {
+				return err
+			}
+			if err := tx.Model(&current).Update("remaining", 0).Error; err != nil {
+				return err
+			}
+			expired += current.Remaining
+			return tx.Create(&models.LoyaltyTransaction{UserID: lot.UserID, Kind: "expire", Points: -current.Remaining, Reason: "expired"}).Error
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,68 @@
+package loyalty
+
+import (
+	"backend/models"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Tier is a level of the programme reached by the points earned in the last 12 months. Tiers earn
+// points faster and are customer segments coupons and promotions can be limited to.
+type Tier struct {
+	Name       string
+	MinPoints  int
+	Multiplier float64 // Applied to the points earned on orders
+}
+
+func envPoints(name string, fallback int) int {
+	points, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || points <= 0 {
+		return fallback
+	}
+	return points
+}
+
+// Tiers returns the tiers from lowest to highest. Silver and gold start at LOYALTY_SILVER_POINTS (default
+// 1000) and LOYALTY_GOLD_POINTS (default 5000).
+func Tiers() []Tier {
+	return []Tier{
+		{Name: "member", MinPoints: 0, Multiplier: 1},
+		{Name: "silver", MinPoints: envPoints("LOYALTY_SILVER_POINTS", 1000), Multiplier: 1.25},
+		{Name: "gold", MinPoints: envPoints("LOYALTY_GOLD_POINTS", 5000), Multiplier: 1.5},
+	}
+}
+
+// QualifyingPoints are the points a customer earned in the 12 months before the given time, less the
+// earned points that were reversed. Redeeming points does not lower the tier.
+func QualifyingPoints(db *gorm.DB, userID uint, at time.Time) (int, error) {
+	var points int
+	err := db.Session(&gorm.Session{NewDB: true}).Model(&models.LoyaltyTransaction{}).
+		Select("COALESCE(SUM(points), 0)").
+		Where("user_id = ? AND created_at > ? AND (kind IN ('earn', 'bonus') OR (kind = 'reversal' AND points < 0))", userID, at.AddDate(-1, 0, 0)).
+		Scan(&points).Error
+	return points, err
+}
+
+// TierOf returns the tier of a customer and the next one, nil at the highest tier
+func TierOf(db *gorm.DB, userID uint, at time.Time) (Tier, *Tier, int, error) {
+	points, err := QualifyingPoints(db, userID, at)
+	if err != nil {
+		return Tier{}, nil, 0, err
+	}
+
+	tiers := Tiers()
+	current := 0
+	for i, tier := range tiers {
+		if points >= tier.MinPoints {
+			current = i
+		}
+	}
+	var next *Tier
+	if current+1 < len(tiers) {
+		next = &tiers[current+1]
+	}
+	return tiers[current], next, points, nil
+}
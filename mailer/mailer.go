@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// From is the sender of outgoing emails, MAIL_FROM (default no-reply@localhost)
+func From() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
+
+// Send emails a plain text message through the SMTP server of SMTP_HOST and SMTP_PORT (default 587),
+// signing in with SMTP_USERNAME and SMTP_PASSWORD when set. Without SMTP_HOST the message is only logged,
+// e.g. in development.
+func Send(to, subject, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Printf("email to %s: %s\n%s", to, subject, body)
+		return nil
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	from := From()
+	message := strings.Join([]string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("sending email to %s: %w", to, err)
+	}
+	return nil
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"backend/alerts"
+	"backend/carts"
+	"backend/catalog"
 	"backend/config"
+	"backend/jobs"
+	"backend/loyalty"
+	"backend/media"
 	"backend/middlewares"
 	"backend/routes"
+	"backend/storage"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -19,6 +29,29 @@ func main() {
 	router.Use(gin.Recovery())
 
 	config.ConnectDatabase()
+	if os.Getenv("DB_AUTO_MIGRATE") == "true" {
+		if err := config.MigrateDatabase(); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to initialise storage: %v", err)
+	}
+	if local, ok := storage.Default.(*storage.LocalStorage); ok && strings.HasPrefix(local.PublicURL, "/") {
+		router.Static(local.PublicURL, local.Root)
+	}
+
+	jobs.Start(4)
+	media.Start()
+	catalog.StartScheduler()
+	catalog.StartFeeds()
+	catalog.StartRecommendations()
+	catalog.StartViews()
+	catalog.StartTrash()
+	carts.Start()
+	loyalty.Start()
+	alerts.Start()
 
 	router.GET("/", func(ctx *gin.Context) { ctx.JSON(http.StatusOK, "Moubon API Service health is OK") })
 	// Liveness Probe: Returns 200 if the app is running
@@ -51,6 +84,14 @@ func main() {
 	routes.AdminDashboardRoutes(router)
 	routes.ShopRoutes(router)
 	routes.ContentRoutes(router)
+	routes.FeedRoutes(router)
+	routes.TrashRoutes(router)
+	routes.CampaignRoutes(router)
+	routes.PromotionRoutes(router)
+	routes.GiftCardRoutes(router)
+	routes.LoyaltyRoutes(router)
+	routes.ReferralRoutes(router)
+	routes.AlertRoutes(router)
 
 	router.Run(":3010")
 }
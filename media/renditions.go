@@ -0,0 +1,150 @@
+package media
+
+import (
+	"backend/config"
+	"backend/imaging"
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const renditionBatchSize = 20
+
+// imageTables are the tables holding images that get renditions
+var imageTables = []string{"product_images", "category_images", "content_images"}
+
+var sweepMu sync.Mutex
+
+// Start schedules the periodic rendition sweep, which also picks up images left over from a restart
+func Start() {
+	jobs.Every("image renditions", 5*time.Minute, processPending)
+	QueueRenditions()
+}
+
+// QueueRenditions asks a background worker to generate renditions for newly stored images
+func QueueRenditions() {
+	jobs.Enqueue("image renditions", processPending)
+}
+
+func processPending(ctx context.Context) error {
+	sweepMu.Lock()
+	defer sweepMu.Unlock()
+
+	for _, table := range imageTables {
+		// Images skipped after a transient error stay pending, the sweep moves past them by ID
+		lastID := uint(0)
+		for {
+			var rows []struct {
+				ID         uint
+				StorageKey string
+			}
+			if err := config.DB.Table(table).
+				Select("id, storage_key").
+				Where("renditions IS NULL AND storage_key IS NOT NULL AND storage_key <> '' AND id > ?", lastID).
+				Order("id").
+				Limit(renditionBatchSize).
+				Scan(&rows).Error; err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				break
+			}
+
+			for _, row := range rows {
+				lastID = row.ID
+				renditions, err := generate(ctx, row.StorageKey)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if errors.Is(err, errBrokenImage) {
+					// Mark the image as processed so a broken file is not retried forever
+					log.Printf("failed to generate renditions for %s %d: %v", table, row.ID, err)
+					renditions = models.Renditions{}
+				} else if err != nil {
+					// Storage errors are retried by the next sweep
+					log.Printf("skipping renditions for %s %d until the next sweep: %v", table, row.ID, err)
+					continue
+				}
+
+				if err := config.DB.Table(table).Where("id = ?", row.ID).Update("renditions", renditions).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// errBrokenImage marks files that can never get renditions, as opposed to storage errors that may pass
+var errBrokenImage = errors.New("broken image")
+
+// generate stores every configured rendition of the original image next to it
+func generate(ctx context.Context, key string) (models.Renditions, error) {
+	if storage.Default == nil {
+		return nil, storage.ErrNotConfigured
+	}
+
+	reader, err := storage.Default.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	original, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBrokenImage, err)
+	}
+
+	base := strings.TrimSuffix(key, path.Ext(key))
+	renditions := models.Renditions{}
+
+	for _, spec := range imaging.Renditions() {
+		resized := imaging.Resize(original, spec.Width)
+		rendition := models.Rendition{
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+			Keys:   map[string]string{},
+		}
+
+		for _, format := range imaging.Formats() {
+			encoded, err := imaging.Encode(resized, format)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errBrokenImage, err)
+			}
+
+			renditionKey := base + "_" + spec.Name + "." + extension(format)
+			if err := storage.Default.Put(ctx, renditionKey, bytes.NewReader(encoded), int64(len(encoded)), "image/"+format); err != nil {
+				return nil, err
+			}
+			rendition.Keys[format] = renditionKey
+		}
+
+		renditions[spec.Name] = rendition
+	}
+
+	return renditions, nil
+}
+
+func extension(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
@@ -45,11 +45,47 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// OptionalAuthMiddleware sets the user of a valid bearer token like AuthMiddleware but lets anonymous requests through.
+// Anonymous clients identify themselves with an X-Session-ID header, available as "session_id". A request that sends
+// an Authorization header is not anonymous, an invalid or expired token is rejected rather than ignored.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sessionID := strings.TrimSpace(c.GetHeader("X-Session-ID")); sessionID != "" && len(sessionID) <= 64 {
+			c.Set("session_id", sessionID)
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+			c.Abort()
+			return
+		}
+		claims, err := utils.ValidateJWT(parts[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			c.Abort()
+			return
+		}
+		c.Set("user_id", claims.UserID)
+		c.Set("email", claims.Email)
+		c.Set("role", claims.Role)
+
+		c.Next()
+	}
+}
+
 func CheckIfAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header
 		if c.GetString("role") != "admin" {
 			c.JSON(http.StatusForbidden, gin.H{"message": "not allowed to access this resource"})
+			c.Abort()
 			return
 		}
 
@@ -19,8 +19,8 @@ func CORSMiddleware() gin.HandlerFunc {
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.Writer.Header().Set("Access-Control-Max-Age", "86400")
 		ctx.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE, PATCH")
-		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Secret-Key, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Secret-Key, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Session-ID, X-Cart-ID")
+		ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, X-Cart-ID")
 		ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		ctx.Writer.Header().Set("Cache-Control", "no-cache")
 
@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware allows each signed in user, or client IP for anonymous requests, limit requests per
+// window on the routes it guards. Counts are kept in memory per process.
+func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+	type counter struct {
+		count int
+		reset time.Time
+	}
+	var mu sync.Mutex
+	counters := map[string]*counter{}
+	lastSweep := time.Now()
+
+	return func(c *gin.Context) {
+		key := c.ClientIP()
+		if userID := c.GetUint("user_id"); userID != 0 {
+			key = "user:" + strconv.FormatUint(uint64(userID), 10)
+		}
+		now := time.Now()
+
+		mu.Lock()
+		// Expired counters are swept once per window so the map does not grow without bound
+		if now.Sub(lastSweep) > window {
+			for k, entry := range counters {
+				if now.After(entry.reset) {
+					delete(counters, k)
+				}
+			}
+			lastSweep = now
+		}
+		entry, ok := counters[key]
+		if !ok || now.After(entry.reset) {
+			entry = &counter{reset: now.Add(window)}
+			counters[key] = entry
+		}
+		entry.count++
+		exceeded, retryAfter := entry.count > limit, entry.reset.Sub(now)
+		mu.Unlock()
+
+		if exceeded {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
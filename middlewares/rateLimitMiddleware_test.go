@@ -0,0 +1,105 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/balance", func(c *gin.Context) {
+		if id := c.GetHeader("X-User"); id == "1" {
+			c.Set("user_id", uint(1))
+		}
+		c.Next()
+	}, RateLimitMiddleware(2, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name string
+		ip   string
+		user string
+		want int
+	}{
+		{"first", "10.0.0.1:1000", "", http.StatusOK},
+		{"second", "10.0.0.1:1001", "", http.StatusOK},
+		{"over the limit", "10.0.0.1:1002", "", http.StatusTooManyRequests},
+		{"other client", "10.0.0.2:1000", "", http.StatusOK},
+		// Signed in customers are counted by account, not by address
+		{"user", "10.0.0.1:1003", "1", http.StatusOK},
+		{"user from another address", "10.0.0.3:1000", "1", http.StatusOK},
+		{"user over the limit", "10.0.0.4:1000", "1", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+		req.RemoteAddr = tt.ip
+		req.Header.Set("X-User", tt.user)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareWindow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", RateLimitMiddleware(1, 50*time.Millisecond), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("first request: status %d", code)
+	}
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("second request in the window: status %d", code)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("request in the next window: status %d", code)
+	}
+}
+
+func TestCheckIfAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		role    string
+		want    int
+		handled bool
+	}{
+		{"admin", http.StatusOK, true},
+		{"customer", http.StatusForbidden, false},
+		{"", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		handled := false
+		router := gin.New()
+		router.GET("/", func(c *gin.Context) {
+			c.Set("role", tt.role)
+		}, CheckIfAdmin(), func(c *gin.Context) {
+			handled = true
+			c.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tt.want || handled != tt.handled {
+			t.Errorf("role %q: status %d, handler ran %v, want %d, %v", tt.role, rec.Code, handled, tt.want, tt.handled)
+		}
+	}
+}
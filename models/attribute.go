@@ -0,0 +1,190 @@
+package models
+
+import (
+	"backend/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// AttributeDefinition is a specification that products of a category, or of its subcategories, can carry
+type AttributeDefinition struct {
+	gorm.Model
+	CategoryID uint           `gorm:"not null;uniqueIndex:idx_attribute_definitions_code"`
+	Category   Category       `gorm:"foreignKey:CategoryID" json:"-"`
+	Name       string         `gorm:"size:100;not null"`
+	Code       string         `gorm:"size:100;not null;uniqueIndex:idx_attribute_definitions_code"` // Used in listing filters, e.g. attr[screen-size]
+	Type       string         `gorm:"size:20;not null;check:type IN ('text', 'number', 'enum', 'boolean')"`
+	Unit       *string        `gorm:"size:20"`     // Unit of number attributes, e.g. "cm"
+	Options    pq.StringArray `gorm:"type:text[]"` // Allowed values of enum attributes
+	Required   bool           `gorm:"default:false"`
+	Filterable bool           `gorm:"default:true"`
+	Position   int            `gorm:"not null;default:0"`
+}
+
+func (d *AttributeDefinition) BeforeSave(tx *gorm.DB) (err error) {
+	if d.Code == "" {
+		d.Code = utils.Slugify(d.Name)
+	}
+	if d.Code == "" || utils.Slugify(d.Code) != d.Code {
+		return errors.New("attribute code may only contain lowercase letters, digits and hyphens")
+	}
+	if d.Type == "enum" && len(d.Options) == 0 {
+		return errors.New("enum attributes need at least one option")
+	}
+	if d.Type != "enum" {
+		d.Options = nil
+	}
+	if d.Type != "number" {
+		d.Unit = nil
+	}
+	return nil
+}
+
+// ParseValue checks a raw value against the attribute type and returns it in its stored form
+func (d *AttributeDefinition) ParseValue(raw string) (text *string, number *float64, boolean *bool, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil, nil, fmt.Errorf("%s needs a value", d.Name)
+	}
+
+	switch d.Type {
+	case "number":
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s must be a number", d.Name)
+		}
+		return nil, &value, nil, nil
+	case "boolean":
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s must be true or false", d.Name)
+		}
+		return nil, nil, &value, nil
+	case "enum":
+		for _, option := range d.Options {
+			if strings.EqualFold(option, raw) {
+				value := option
+				return &value, nil, nil, nil
+			}
+		}
+		return nil, nil, nil, fmt.Errorf("%s must be one of %s", d.Name, strings.Join(d.Options, ", "))
+	default:
+		return &raw, nil, nil, nil
+	}
+}
+
+// FormatValue is the display form of a stored value, e.g. "15.6 in" or "Yes"
+func (d *AttributeDefinition) FormatValue(text *string, number *float64, boolean *bool) string {
+	switch {
+	case number != nil:
+		value := strconv.FormatFloat(*number, 'f', -1, 64)
+		if d.Unit != nil && *d.Unit != "" {
+			value += " " + *d.Unit
+		}
+		return value
+	case boolean != nil:
+		if *boolean {
+			return "Yes"
+		}
+		return "No"
+	case text != nil:
+		return *text
+	}
+	return ""
+}
+
+// CategoryLineage returns the ID of a category followed by the IDs of its ancestors
+func CategoryLineage(db *gorm.DB, categoryID uint) ([]uint, error) {
+	var lineage []uint
+	seen := map[uint]bool{}
+
+	for id := &categoryID; id != nil && !seen[*id]; {
+		seen[*id] = true
+		lineage = append(lineage, *id)
+
+		var category Category
+		if err := db.Session(&gorm.Session{NewDB: true}).Select("id, parent_id").First(&category, *id).Error; err != nil {
+			return nil, err
+		}
+		id = category.ParentID
+	}
+
+	return lineage, nil
+}
+
+// SetProductAttributes replaces the structured attributes of a product, checking that every required
+// attribute of its category is present. Free text attributes without a definition are kept.
+func SetProductAttributes(tx *gorm.DB, product *Product, attributes []ProductAttribute) error {
+	seen := map[uint]bool{}
+	for i := range attributes {
+		attribute := &attributes[i]
+		if attribute.DefinitionID == nil {
+			return errors.New("structured attributes need a DefinitionID")
+		}
+		if seen[*attribute.DefinitionID] {
+			return errors.New("each attribute can only be given once")
+		}
+		seen[*attribute.DefinitionID] = true
+
+		attribute.ID = 0
+		attribute.ProductID = product.ID
+	}
+
+	if err := checkRequiredAttributes(tx, product.CategoryID, seen); err != nil {
+		return err
+	}
+
+	var existing []*ProductAttribute
+	if err := tx.Where("product_id = ? AND definition_id IS NOT NULL", product.ID).Find(&existing).Error; err != nil {
+		return err
+	}
+	for _, attribute := range existing {
+		if err := tx.Delete(attribute).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := range attributes {
+		if err := tx.Omit("Product", "Definition").Create(&attributes[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateProductAttributes checks that a product still has every required attribute, e.g. after moving it to another category
+func ValidateProductAttributes(tx *gorm.DB, product *Product) error {
+	var definitionIDs []uint
+	if err := tx.Model(&ProductAttribute{}).Where("product_id = ? AND definition_id IS NOT NULL", product.ID).Pluck("definition_id", &definitionIDs).Error; err != nil {
+		return err
+	}
+
+	present := map[uint]bool{}
+	for _, id := range definitionIDs {
+		present[id] = true
+	}
+	return checkRequiredAttributes(tx, product.CategoryID, present)
+}
+
+func checkRequiredAttributes(tx *gorm.DB, categoryID uint, present map[uint]bool) error {
+	lineage, err := CategoryLineage(tx, categoryID)
+	if err != nil {
+		return err
+	}
+
+	var required []*AttributeDefinition
+	if err := tx.Session(&gorm.Session{NewDB: true}).Where("category_id IN ? AND required = true", lineage).Find(&required).Error; err != nil {
+		return err
+	}
+	for _, definition := range required {
+		if !present[definition.ID] {
+			return fmt.Errorf("%s is required for products in this category", definition.Name)
+		}
+	}
+	return nil
+}
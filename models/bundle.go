@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// BundleComponent is a product or variation contained in a bundle, Quantity times per bundle
+type BundleComponent struct {
+	ID          uint    `gorm:"primaryKey"`
+	BundleID    uint    `gorm:"not null;uniqueIndex:idx_bundle_components_component"`
+	Bundle      Product `gorm:"foreignKey:BundleID;constraint:OnDelete:CASCADE" json:"-"`
+	ComponentID uint    `gorm:"not null;uniqueIndex:idx_bundle_components_component;index"`
+	Component   Product `gorm:"foreignKey:ComponentID"`
+	Quantity    int     `gorm:"not null;default:1;check:quantity > 0"`
+}
+
+var ErrInvalidBundleComponent = errors.New("bundle components must be existing products that are not bundles or gift cards themselves")
+
+// IsBundle reports whether the product is a bundle of other products
+func (p *Product) IsBundle() bool {
+	return p.ProductType == "bundle"
+}
+
+// SetBundleComponents replaces the components of a bundle and reprices it when it is priced from them
+func SetBundleComponents(tx *gorm.DB, bundle *Product, components []BundleComponent) error {
+	if !bundle.IsBundle() {
+		return errors.New("product is not a bundle")
+	}
+
+	seen := map[uint]bool{}
+	for i := range components {
+		component := &components[i]
+		if component.Quantity <= 0 {
+			component.Quantity = 1
+		}
+		if component.ComponentID == bundle.ID || seen[component.ComponentID] {
+			return ErrInvalidBundleComponent
+		}
+		seen[component.ComponentID] = true
+
+		var product Product
+		if err := tx.Select("id, product_type").First(&product, component.ComponentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidBundleComponent
+			}
+			return err
+		}
+		if product.IsBundle() || product.IsGiftCard() {
+			return ErrInvalidBundleComponent
+		}
+
+		component.ID = 0
+		component.BundleID = bundle.ID
+	}
+
+	if err := tx.Where("bundle_id = ?", bundle.ID).Delete(&BundleComponent{}).Error; err != nil {
+		return err
+	}
+	if len(components) > 0 {
+		if err := tx.Omit("Bundle", "Component").Create(&components).Error; err != nil {
+			return err
+		}
+	}
+
+	return repriceBundles(tx, "products.id = ?", bundle.ID)
+}
+
+// repriceBundles sets the price of sum priced bundles matching the condition to the regular price
+// of their components less the bundle discount
+func repriceBundles(tx *gorm.DB, condition string, args ...interface{}) error {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	var bundles []*Product
+	if err := db.Where("product_type = 'bundle' AND bundle_pricing = 'sum'").Where(condition, args...).Find(&bundles).Error; err != nil {
+		return err
+	}
+
+	for _, bundle := range bundles {
+		var total float64
+		if err := db.Table("bundle_components").
+			Select("COALESCE(SUM(components.price * bundle_components.quantity), 0)").
+			Joins("JOIN products AS components ON components.id = bundle_components.component_id AND components.deleted_at IS NULL").
+			Where("bundle_components.bundle_id = ?", bundle.ID).
+			Row().Scan(&total); err != nil {
+			return err
+		}
+
+		price := math.Round(total*(100-bundle.BundleDiscount)) / 100
+		if price == bundle.Price {
+			continue
+		}
+		bundle.Price = price
+		if err := db.Model(bundle).Update("price", price).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Campaign owns the discount rules of a batch of generated single use codes, e.g. for an influencer or
+// email campaign
+type Campaign struct {
+	gorm.Model
+	Name        string `gorm:"size:150;not null"`
+	Description string `gorm:"type:text"`
+	DiscountRules
+	UsageLimitPerUser int           `gorm:"default:1"`     // Codes of the campaign each user can redeem
+	Referral          bool          `gorm:"default:false"` // Codes are handed out as the welcome discount of referred customers
+	AbandonedCart     bool          `gorm:"default:false"` // Codes are handed out in the last abandoned cart email
+	Scopes            []CouponScope `gorm:"foreignKey:CampaignID"`
+}
+
+func (c *Campaign) BeforeSave(tx *gorm.DB) (err error) {
+	return c.DiscountRules.validate()
+}
+
+// CampaignCode is a single use code of a campaign
+type CampaignCode struct {
+	ID            uint     `gorm:"primaryKey"`
+	CampaignID    uint     `gorm:"not null;index"`
+	Campaign      Campaign `gorm:"foreignKey:CampaignID;constraint:OnDelete:CASCADE" json:"-"`
+	Code          string   `gorm:"size:50;not null;uniqueIndex"`
+	RevokedAt     *time.Time
+	RedeemedAt    *time.Time
+	OrderID       *uint `gorm:"index"` // Order the code was redeemed on
+	UserID        *uint `gorm:"index"` // Customer who redeemed the code
+	ReservedForID *uint `gorm:"index"` // Only this customer can redeem the code, e.g. a referral welcome code
+	CreatedAt     time.Time
+}
+
+// Coupon returns a coupon with the rules of the campaign that stands for the code in the promotion engine
+func (c *Campaign) Coupon(code *CampaignCode) *Coupon {
+	return &Coupon{
+		Code:              code.Code,
+		Description:       c.Description,
+		DiscountRules:     c.DiscountRules,
+		UsageLimitPerUser: c.UsageLimitPerUser,
+		Scopes:            c.Scopes,
+		CampaignCode:      code,
+	}
+}
+
+// Status is unused, redeemed or revoked
+func (c *CampaignCode) Status() string {
+	switch {
+	case c.RedeemedAt != nil:
+		return "redeemed"
+	case c.RevokedAt != nil:
+		return "revoked"
+	}
+	return "unused"
+}
@@ -1,19 +1,27 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type CartItem struct {
-	ID        uint         `gorm:"primaryKey"`
-	CartID    uuid.UUID    `gorm:"type:uuid;not null" json:"cart_id"`
-	Cart      ShoppingCart `gorm:"foreignKey:CartID;references:UUID;constraint:OnDelete:CASCADE"`
-	ProductID uint         `gorm:"not null"`
-	Product   Product      `gorm:"foreignKey:ProductID"`
-	Quantity  int          `gorm:"not null"`
+	ID         uint         `gorm:"primaryKey"`
+	CartID     uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_cart_items_cart_product" json:"cart_id"`
+	Cart       ShoppingCart `gorm:"foreignKey:CartID;references:UUID;constraint:OnDelete:CASCADE"`
+	ProductID  uint         `gorm:"not null;uniqueIndex:idx_cart_items_cart_product"`
+	Product    Product      `gorm:"foreignKey:ProductID"`
+	Quantity   int          `gorm:"not null"`
+	PriceAtAdd *float64     `gorm:"type:decimal(10,2)"` // Price when the item was last added, to warn about price changes
+	CreatedAt  time.Time    `gorm:"not null;default:now()"`
+	UpdatedAt  time.Time    `gorm:"not null;default:now()"`
 }
 type WishList struct {
-	ID        uint    `gorm:"primaryKey"`
-	ProductID uint    `gorm:"not null" json:"-"`
-	Product   Product `gorm:"foreignKey:ProductID"`
-	UserID    uint    `gorm:"not null" json:"-"`
-	User      User    `gorm:"foreignKey:UserID" json:"-"`
+	ID         uint    `gorm:"primaryKey"`
+	ProductID  uint    `gorm:"not null" json:"-"`
+	Product    Product `gorm:"foreignKey:ProductID"`
+	UserID     uint    `gorm:"not null" json:"-"`
+	User       User    `gorm:"foreignKey:UserID" json:"-"`
+	PriceAlert bool    `gorm:"-"` // The customer gets an email when the price drops
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CartRecovery follows the cart of a customer from the time it was abandoned: the recovery emails sent,
+// the coupon code handed out and the order that recovered it. A cart that is changed and abandoned again
+// starts a new recovery.
+type CartRecovery struct {
+	ID             uint      `gorm:"primaryKey"`
+	CartID         uuid.UUID `gorm:"type:uuid;not null;index"`
+	UserID         uint      `gorm:"not null;index"`
+	User           User      `gorm:"foreignKey:UserID" json:"-"`
+	AbandonedAt    time.Time `gorm:"not null;index"` // Last change of the cart
+	EmailsSent     int       `gorm:"not null;default:0"`
+	LastEmailAt    *time.Time
+	ClickedAt      *time.Time // First time the link of an email was followed
+	CampaignCodeID *uint
+	CampaignCode   *CampaignCode `gorm:"foreignKey:CampaignCodeID;constraint:OnDelete:SET NULL"`
+	OrderID        *uint         `gorm:"index"`
+	RecoveredAt    *time.Time    // The customer checked out
+	ClosedAt       *time.Time    // Given up, the cart was abandoned again or the emails ended long ago
+	CreatedAt      time.Time
+}
@@ -1,8 +1,9 @@
 package models
 
 import (
+	"backend/storage"
 	"backend/utils"
-	"errors"
+	"context"
 
 	"gopkg.in/guregu/null.v4"
 	"gorm.io/gorm"
@@ -11,45 +12,117 @@ import (
 type Category struct {
 	gorm.Model
 	Name         null.String `gorm:"size:100;not null"`
-	CategoryType null.String `gorm:"size:100;not null;check:category_type IN ('parent', 'child', 'grandchild')"`
+	CategoryType null.String `gorm:"size:100;not null"` // Derived from Depth: parent, child, or grandchild for any deeper level
 	ParentID     *uint
+	Depth        int            `gorm:"not null;default:0"`
+	SortOrder    int            `gorm:"not null;default:0"`
 	Image        *CategoryImage `gorm:"foreignKey:CategoryID"`
 	Products     []Product      `gorm:"foreignKey:CategoryID"`
+
+	Slug            *string `gorm:"size:200;uniqueIndex"`
+	MetaTitle       *string `gorm:"size:200"`
+	MetaDescription *string `gorm:"size:500"`
+	CanonicalURL    *string `gorm:"size:500"`
+
+	ProductCount int             `gorm:"-" json:",omitempty"` // Products of the category and its subcategories
+	Breadcrumbs  []CategoryCrumb `gorm:"-" json:",omitempty"`
+
+	previous *Category // State before an update, used for the revision diff
 }
 
 type CategoryImage struct {
 	ID         uint `gorm:"primaryKey"`
 	CategoryID uint
-	Category   Category `gorm:"foreignKey:CategoryID" json:"-"`
-	Image      string   `gorm:"-" json:"Image"`
-	ImageBytes []byte   `gorm:"column:image;type:bytea" json:"-"` // Binary data for the image
+	Category   Category                     `gorm:"foreignKey:CategoryID" json:"-"`
+	StorageKey string                       `gorm:"size:255" json:"-"` // Key of the image in the blob store
+	URL        string                       `gorm:"-"`                 // Public URL of the image
+	Renditions Renditions                   `gorm:"type:jsonb" json:"-"`
+	Srcset     map[string]map[string]string `gorm:"-" json:",omitempty"`      // Rendition URLs by name and format
+	Image      string                       `gorm:"-" json:"Image,omitempty"` // Base64 upload, only used on create
 }
 
 func (c *CategoryImage) AfterFind(tx *gorm.DB) (err error) {
-	c.Image = utils.EncodeImageToBase64(c.ImageBytes)
+	c.URL = storage.URL(c.StorageKey)
+	c.Srcset = c.Renditions.Srcset()
 
 	return nil
 
 }
 
+// StoreUpload stores the base64 upload of an image that is about to be created
+func (c *CategoryImage) StoreUpload(ctx context.Context) error {
+	return storeBase64Image(ctx, "categories", &c.Image, &c.StorageKey)
+}
+
 func (c *CategoryImage) BeforeCreate(tx *gorm.DB) (err error) {
-	bt, err := utils.DecodeBase64Image(c.Image)
-	if err != nil {
+	if c.StorageKey == "" {
+		return errUploadNotStored
+	}
+	return nil
+}
+
+func (c *Category) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if err := c.placeInTree(tx); err != nil {
 		return err
 	}
 
-	c.ImageBytes = bt
+	if c.Slug != nil && *c.Slug != "" {
+		return validateSlugChange(tx, "categories", "category", *c.Slug, 0)
+	}
 
-	return nil
+	slug, err := uniqueSlug(tx, "categories", "category", utils.Slugify(c.Name.String), 0)
+	c.Slug = &slug
+	return err
 
 }
 
-func (c *Category) BeforeCreate(tx *gorm.DB) (err error) {
+func (c *Category) AfterCreate(tx *gorm.DB) (err error) {
+	return recordRevision(tx, "category", c.ID, "create", nil, c)
+}
 
-	if (c.CategoryType.String == "child" || c.CategoryType.String == "grandchild") && c.ParentID == nil {
-		return errors.New("must provide parent category id when creating sub category")
+func (c *Category) BeforeUpdate(tx *gorm.DB) (err error) {
+	if c.ID == 0 {
+		return nil
+	}
+	c.previous = &Category{}
+	if err := loadPrevious(tx, c.previous, c.ID); err != nil {
+		return err
 	}
 
+	if err := c.placeInTree(tx); err != nil {
+		return err
+	}
+
+	// Slugs stay stable across renames, they only change when set explicitly
+	if c.Slug == nil || *c.Slug == "" {
+		c.Slug = c.previous.Slug
+	} else if c.previous.Slug == nil || *c.Slug != *c.previous.Slug {
+		return validateSlugChange(tx, "categories", "category", *c.Slug, c.ID)
+	}
 	return nil
+}
 
+func (c *Category) AfterUpdate(tx *gorm.DB) (err error) {
+	if c.previous == nil {
+		return nil
+	}
+	previous := c.previous
+	c.previous = nil
+	if err := recordSlugRedirect(tx, "category", c.ID, previous.Slug, c.Slug); err != nil {
+		return err
+	}
+	if c.Depth != previous.Depth {
+		if err := refreshSubtreeDepth(tx, c); err != nil {
+			return err
+		}
+	}
+	return recordRevision(tx, "category", c.ID, "update", previous, c)
+}
+
+func (c *Category) AfterDelete(tx *gorm.DB) (err error) {
+	if c.ID == 0 {
+		return nil
+	}
+	return recordRevision(tx, "category", c.ID, "delete", c, nil)
 }
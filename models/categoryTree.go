@@ -0,0 +1,197 @@
+package models
+
+import (
+	"errors"
+
+	"gopkg.in/guregu/null.v4"
+	"gorm.io/gorm"
+)
+
+var ErrCategoryCycle = errors.New("a category cannot be moved below itself or one of its subcategories")
+
+// CategoryCrumb is a step of the breadcrumb trail of a category
+type CategoryCrumb struct {
+	ID   uint
+	Name string
+	Slug *string
+}
+
+// categoryTypeForDepth keeps the legacy CategoryType label in line with the depth of a category
+func categoryTypeForDepth(depth int) string {
+	switch depth {
+	case 0:
+		return "parent"
+	case 1:
+		return "child"
+	default:
+		return "grandchild"
+	}
+}
+
+// CategoryDescendantIDs returns the ID of a category followed by the IDs of all categories below it
+func CategoryDescendantIDs(db *gorm.DB, categoryID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Session(&gorm.Session{NewDB: true}).Raw(`WITH RECURSIVE descendants AS (
+			SELECT id, 0 AS depth FROM categories WHERE id = ? AND deleted_at IS NULL
+			UNION
+			SELECT categories.id, descendants.depth + 1 FROM categories
+			JOIN descendants ON categories.parent_id = descendants.id
+			WHERE categories.deleted_at IS NULL
+		)
+		SELECT id FROM descendants ORDER BY depth, id`, categoryID).Scan(&ids).Error
+	return ids, err
+}
+
+// CategoryBreadcrumbs returns the trail from the root category down to the given one
+func CategoryBreadcrumbs(db *gorm.DB, categoryID uint) ([]CategoryCrumb, error) {
+	lineage, err := CategoryLineage(db, categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []*Category
+	if err := db.Session(&gorm.Session{NewDB: true}).Select("id, name, slug").Where("id IN ?", lineage).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	byID := map[uint]*Category{}
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	crumbs := make([]CategoryCrumb, 0, len(lineage))
+	for i := len(lineage) - 1; i >= 0; i-- {
+		if category := byID[lineage[i]]; category != nil {
+			crumbs = append(crumbs, CategoryCrumb{ID: category.ID, Name: category.Name.String, Slug: category.Slug})
+		}
+	}
+	return crumbs, nil
+}
+
+// CategoryProductCounts returns the number of products of every category including those of its subcategories
+func CategoryProductCounts(db *gorm.DB) (map[uint]int, error) {
+	var rows []struct {
+		ID       uint
+		ParentID *uint
+		Products int
+	}
+	if err := db.Session(&gorm.Session{NewDB: true}).Raw(`SELECT categories.id, categories.parent_id, COUNT(products.id) AS products
+		FROM categories
+		LEFT JOIN products ON products.category_id = categories.id AND products.deleted_at IS NULL AND products.is_child = false
+		WHERE categories.deleted_at IS NULL
+		GROUP BY categories.id`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	parents := map[uint]*uint{}
+	for _, row := range rows {
+		parents[row.ID] = row.ParentID
+	}
+
+	counts := map[uint]int{}
+	for _, row := range rows {
+		seen := map[uint]bool{}
+		for id := &row.ID; id != nil && !seen[*id]; id = parents[*id] {
+			seen[*id] = true
+			counts[*id] += row.Products
+		}
+	}
+	return counts, nil
+}
+
+// placeInTree sets the depth of a category from its parent, refusing parents that would create a cycle
+func (c *Category) placeInTree(tx *gorm.DB) error {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	if c.previous != nil && sameParent(c.ParentID, c.previous.ParentID) {
+		c.Depth = c.previous.Depth
+		c.CategoryType = null.StringFrom(categoryTypeForDepth(c.Depth))
+		return nil
+	}
+
+	c.Depth = 0
+	if c.ParentID != nil {
+		if c.ID != 0 {
+			descendants, err := CategoryDescendantIDs(db, c.ID)
+			if err != nil {
+				return err
+			}
+			for _, id := range descendants {
+				if id == *c.ParentID {
+					return ErrCategoryCycle
+				}
+			}
+		}
+
+		var parent Category
+		if err := db.Select("id, depth").First(&parent, *c.ParentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("parent category not found")
+			}
+			return err
+		}
+		c.Depth = parent.Depth + 1
+	}
+	c.CategoryType = null.StringFrom(categoryTypeForDepth(c.Depth))
+
+	return nil
+}
+
+func sameParent(a *uint, b *uint) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// refreshSubtreeDepth updates the depth of the categories below a moved category
+func refreshSubtreeDepth(tx *gorm.DB, c *Category) error {
+	return tx.Session(&gorm.Session{NewDB: true}).Exec(`WITH RECURSIVE subtree AS (
+			SELECT id, ?::int AS depth FROM categories WHERE parent_id = ?
+			UNION
+			SELECT categories.id, subtree.depth + 1 FROM categories
+			JOIN subtree ON categories.parent_id = subtree.id
+		)
+		UPDATE categories SET depth = subtree.depth,
+			category_type = CASE WHEN subtree.depth = 1 THEN 'child' ELSE 'grandchild' END
+		FROM subtree WHERE categories.id = subtree.id`, c.Depth+1, c.ID).Error
+}
+
+// MoveCategory places a category below a new parent, or at the root when parentID is nil
+func MoveCategory(tx *gorm.DB, category *Category, parentID *uint, sortOrder *int) error {
+	category.ParentID = parentID
+	if sortOrder != nil {
+		category.SortOrder = *sortOrder
+	}
+	return tx.Omit("Image", "Products").Save(category).Error
+}
+
+// ReorderCategories sets the sort order of sibling categories to the order of ids
+func ReorderCategories(tx *gorm.DB, parentID *uint, ids []uint) error {
+	for i, id := range ids {
+		query := tx.Model(&Category{}).Where("id = ?", id)
+		if parentID == nil {
+			query = query.Where("parent_id IS NULL")
+		} else {
+			query = query.Where("parent_id = ?", *parentID)
+		}
+
+		result := query.UpdateColumn("sort_order", i+1)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("categories must all have the same parent")
+		}
+	}
+	return nil
+}
+
+// BackfillCategoryDepths sets the depth and type of every category from the tree, for data created before depths existed
+func BackfillCategoryDepths(db *gorm.DB) error {
+	return db.Exec(`WITH RECURSIVE tree AS (
+			SELECT id, 0 AS depth FROM categories WHERE parent_id IS NULL
+			UNION
+			SELECT categories.id, tree.depth + 1 FROM categories
+			JOIN tree ON categories.parent_id = tree.id
+		)
+		UPDATE categories SET depth = tree.depth,
+			category_type = CASE WHEN tree.depth = 0 THEN 'parent' WHEN tree.depth = 1 THEN 'child' ELSE 'grandchild' END
+		FROM tree WHERE categories.id = tree.id AND categories.depth <> tree.depth`).Error
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// categoryTreeDB answers the category tree queries of placeInTree from a map of categories to their parent
+func categoryTreeDB(t *testing.T, parents map[int64]int64) func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	depth := func(id int64) int64 {
+		d := int64(0)
+		for parents[id] != 0 {
+			id = parents[id]
+			d++
+		}
+		return d
+	}
+
+	return func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		id := args[0].Value.(int64)
+		switch {
+		case strings.Contains(query, "WITH RECURSIVE descendants"):
+			rows := [][]driver.Value{{id}}
+			for i := 0; i < len(rows); i++ {
+				for child, parent := range parents {
+					if parent == rows[i][0] {
+						rows = append(rows, []driver.Value{child})
+					}
+				}
+			}
+			return []string{"id"}, rows, nil
+		case strings.HasPrefix(query, "SELECT id, depth FROM"):
+			if _, ok := parents[id]; !ok {
+				return []string{"id", "depth"}, nil, nil
+			}
+			return []string{"id", "depth"}, [][]driver.Value{{id, depth(id)}}, nil
+		}
+		t.Errorf("unexpected query %s", query)
+		return nil, nil, fmt.Errorf("unexpected query %s", query)
+	}
+}
+
+func TestPlaceInTree(t *testing.T) {
+	// 1 > 2 > 3 and 4 on its own, 0 is the root
+	db := openStubDB(t, categoryTreeDB(t, map[int64]int64{1: 0, 2: 1, 3: 2, 4: 0}))
+	parent := func(id uint) *uint { return &id }
+
+	tests := []struct {
+		name   string
+		id     uint
+		parent *uint
+		depth  int
+		err    error
+	}{
+		{"below itself", 1, parent(1), 0, ErrCategoryCycle},
+		{"below its child", 1, parent(2), 0, ErrCategoryCycle},
+		{"below its grandchild", 1, parent(3), 0, ErrCategoryCycle},
+		{"child below its own child", 2, parent(3), 0, ErrCategoryCycle},
+		{"to another root", 3, parent(4), 1, nil},
+		{"to the top", 3, nil, 0, nil},
+		{"root below a leaf", 4, parent(3), 3, nil},
+		{"grandchild below its grandparent", 3, parent(1), 1, nil},
+		{"new category", 0, parent(3), 3, nil},
+		{"missing parent", 4, parent(99), 0, errors.New("parent category not found")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category := Category{ParentID: tt.parent}
+			category.ID = tt.id
+			err := category.placeInTree(db)
+			if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+				t.Fatalf("placeInTree() = %v, want %v", err, tt.err)
+			}
+			if err == nil && category.Depth != tt.depth {
+				t.Errorf("depth %d, want %d", category.Depth, tt.depth)
+			}
+			if err == nil && category.CategoryType.String != categoryTypeForDepth(tt.depth) {
+				t.Errorf("type %q, want %q", category.CategoryType.String, categoryTypeForDepth(tt.depth))
+			}
+		})
+	}
+}
+
+func TestCategoryProductCounts(t *testing.T) {
+	// 1 > 2 > 3, 4 on its own, and 5 and 6 parents of each other from bad data
+	db := openStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"id", "parent_id", "products"}, [][]driver.Value{
+			{int64(1), nil, int64(1)},
+			{int64(2), int64(1), int64(2)},
+			{int64(3), int64(2), int64(4)},
+			{int64(4), nil, int64(0)},
+			{int64(5), int64(6), int64(1)},
+			{int64(6), int64(5), int64(2)},
+		}, nil
+	})
+
+	counts, err := CategoryProductCounts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]int{1: 7, 2: 6, 3: 4, 4: 0, 5: 3, 6: 3}
+	for id, count := range want {
+		if counts[id] != count {
+			t.Errorf("category %d has %d products, want %d", id, counts[id], count)
+		}
+	}
+}
@@ -1,33 +1,38 @@
 package models
 
 import (
-	"backend/utils"
+	"backend/storage"
+	"context"
 
 	"gorm.io/gorm"
 )
 
 type ContentImage struct {
-	ID         uint   `gorm:"primaryKey"`
-	Position   string `gorm:"not null; check:position IN ('banner')"`
-	Image      string `gorm:"-" json:"Image"`
-	ImageBytes []byte `gorm:"column:image;type:bytea" json:"-"` // Binary data for the image
+	ID         uint                         `gorm:"primaryKey"`
+	Position   string                       `gorm:"not null; check:position IN ('banner')"`
+	StorageKey string                       `gorm:"size:255" json:"-"` // Key of the image in the blob store
+	URL        string                       `gorm:"-"`                 // Public URL of the image
+	Renditions Renditions                   `gorm:"type:jsonb" json:"-"`
+	Srcset     map[string]map[string]string `gorm:"-" json:",omitempty"`      // Rendition URLs by name and format
+	Image      string                       `gorm:"-" json:"Image,omitempty"` // Base64 upload, only used on create
 }
 
 func (c *ContentImage) AfterFind(tx *gorm.DB) (err error) {
-	c.Image = utils.EncodeImageToBase64(c.ImageBytes)
+	c.URL = storage.URL(c.StorageKey)
+	c.Srcset = c.Renditions.Srcset()
 
 	return nil
 
 }
 
+// StoreUpload stores the base64 upload of an image that is about to be created
+func (c *ContentImage) StoreUpload(ctx context.Context) error {
+	return storeBase64Image(ctx, "content", &c.Image, &c.StorageKey)
+}
+
 func (c *ContentImage) BeforeCreate(tx *gorm.DB) (err error) {
-	bt, err := utils.DecodeBase64Image(c.Image)
-	if err != nil {
-		return err
+	if c.StorageKey == "" {
+		return errUploadNotStored
 	}
-
-	c.ImageBytes = bt
-
 	return nil
-
 }
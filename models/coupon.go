@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,25 +10,128 @@ import (
 
 type Coupon struct {
 	gorm.Model
-	Code              string     `gorm:"size:50;unique;not null"`                                         // Unique coupon code
-	Description       string     `gorm:"type:text"`                                                       // Description of the coupon
-	DiscountType      string     `gorm:"size:20;not null;check:discount_type IN ('percentage', 'fixed')"` // Type of discount: 'percentage' or 'fixed'
-	DiscountValue     float64    `gorm:"type:numeric(10,2);not null"`                                     // Discount value (percentage or fixed amount)
-	MinOrderValue     *float64   `gorm:"type:numeric(10,2)"`                                              // Minimum order value required to use the coupon
-	MaxDiscountValue  *float64   `gorm:"type:numeric(10,2)"`                                              // Max discount for percentage-based coupons
-	UsageLimit        *int       // Total times this coupon can be used
-	UsageLimitPerUser int        `gorm:"default:1"` // Times each user can use the coupon
-	StartDate         time.Time  `gorm:"not null"`  // Start date for coupon validity
-	ExpirationDate    *time.Time // Expiration date for coupon validity
-	IsActive          bool       `gorm:"default:true"` // Whether the coupon is active
+	Code        string `gorm:"size:50;not null;uniqueIndex:idx_coupons_code_active,where:deleted_at IS NULL"` // Unique among coupons that are not in the trash
+	Description string `gorm:"type:text"`                                                                     // Description of the coupon
+	DiscountRules
+	UsageLimit        *int          // Total times this coupon can be used
+	UsageLimitPerUser int           `gorm:"default:1"` // Times each user can use the coupon
+	Scopes            []CouponScope `gorm:"foreignKey:CouponID"`
+
+	CampaignCode *CampaignCode `gorm:"-" json:"-"` // Set when the coupon stands for a code of a campaign
+	Promotion    *Promotion    `gorm:"-" json:"-"` // Set when the coupon stands for an automatic promotion
+}
+
+// DiscountRules are the discount and conditions shared by coupons and the codes of a campaign
+type DiscountRules struct {
+	DiscountType     string     `gorm:"size:20;not null;check:discount_type IN ('percentage', 'fixed', 'free_shipping')"` // Type of discount: 'percentage', 'fixed' or 'free_shipping'
+	DiscountValue    float64    `gorm:"type:numeric(10,2);not null"`                                                      // Discount value (percentage or fixed amount)
+	MinOrderValue    *float64   `gorm:"type:numeric(10,2)"`                                                               // Minimum spend on eligible items required to use the coupon
+	MaxDiscountValue *float64   `gorm:"type:numeric(10,2)"`                                                               // Max discount for percentage-based and free shipping coupons
+	StartDate        time.Time  `gorm:"not null"`                                                                         // Start date for coupon validity
+	ExpirationDate   *time.Time // Expiration date for coupon validity
+	IsActive         bool       `gorm:"default:true"`  // Whether the coupon is active
+	FirstOrderOnly   bool       `gorm:"default:false"` // Only valid for customers without a previous order
+	Exclusive        bool       `gorm:"default:false"` // Cannot be combined with other coupons
+	Priority         int        `gorm:"default:0"`     // Coupons with a higher priority are applied first
+}
+
+// CustomerSegments are the segments coupons can be limited to: customers without orders, customers with
+// orders, customers whose spend reached VIP_SEGMENT_SPEND, and the loyalty tiers
+var CustomerSegments = []string{"new", "returning", "vip", "silver", "gold"}
+
+// CouponScope limits a coupon, campaign or promotion to, or with Exclude excludes it from, products, categories
+// with their subcategories, brands or customer segments. Without product, category or brand scopes
+// every item is eligible.
+type CouponScope struct {
+	ID          uint       `gorm:"primaryKey"`
+	CouponID    *uint      `gorm:"index"`
+	Coupon      *Coupon    `gorm:"foreignKey:CouponID;constraint:OnDelete:CASCADE" json:"-"`
+	CampaignID  *uint      `gorm:"index"`
+	Campaign    *Campaign  `gorm:"foreignKey:CampaignID;constraint:OnDelete:CASCADE" json:"-"`
+	PromotionID *uint      `gorm:"index"`
+	Promotion   *Promotion `gorm:"foreignKey:PromotionID;constraint:OnDelete:CASCADE" json:"-"`
+	Kind        string     `gorm:"size:20;not null;check:kind IN ('product', 'category', 'brand', 'segment')"`
+	ProductID   *uint      // Set for product scopes
+	CategoryID  *uint      // Set for category scopes
+	Value       *string    `gorm:"size:100"` // Brand or segment name
+	Exclude     bool       `gorm:"default:false"`
+}
+
+func (c *Coupon) BeforeSave(tx *gorm.DB) (err error) {
+	return c.DiscountRules.validate()
+}
+
+func (r *DiscountRules) validate() error {
+	switch r.DiscountType {
+	case "percentage":
+		if r.DiscountValue <= 0 || r.DiscountValue > 100 {
+			return errors.New("percentage discounts must be between 0 and 100")
+		}
+	case "fixed":
+		if r.DiscountValue <= 0 {
+			return errors.New("fixed discounts must be positive")
+		}
+	case "free_shipping":
+		r.DiscountValue = 0
+	}
+	if r.MaxDiscountValue != nil && *r.MaxDiscountValue <= 0 {
+		return errors.New("max discount must be positive")
+	}
+	if r.ExpirationDate != nil && !r.ExpirationDate.After(r.StartDate) {
+		return errors.New("expiration date must be after the start date")
+	}
+	return nil
+}
+
+func (s *CouponScope) BeforeSave(tx *gorm.DB) (err error) {
+	owners := 0
+	for _, owner := range []*uint{s.CouponID, s.CampaignID, s.PromotionID} {
+		if owner != nil {
+			owners++
+		}
+	}
+	if owners != 1 {
+		return errors.New("a scope belongs to either a coupon, a campaign or a promotion")
+	}
+	switch s.Kind {
+	case "product":
+		if s.ProductID == nil {
+			return errors.New("product scopes need a ProductID")
+		}
+		s.CategoryID, s.Value = nil, nil
+	case "category":
+		if s.CategoryID == nil {
+			return errors.New("category scopes need a CategoryID")
+		}
+		s.ProductID, s.Value = nil, nil
+	case "brand":
+		if s.Value == nil || *s.Value == "" {
+			return errors.New("brand scopes need a Value")
+		}
+		s.ProductID, s.CategoryID = nil, nil
+	case "segment":
+		known := false
+		for _, segment := range CustomerSegments {
+			known = known || (s.Value != nil && *s.Value == segment)
+		}
+		if !known {
+			return fmt.Errorf("segment scopes need a Value of %v", CustomerSegments)
+		}
+		s.ProductID, s.CategoryID = nil, nil
+	}
+	return nil
 }
 
+// CouponUsageHistory is a redemption of a coupon by an order. Reversed redemptions, of cancelled
+// orders, no longer count towards the usage limits.
 type CouponUsageHistory struct {
-	ID        uint      `gorm:"primaryKey"`
-	CouponID  uint      `gorm:"not null"` // Reference to Coupon
-	Category  Coupon    `gorm:"foreignKey:CouponID"`
-	UserID    uint      `gorm:"not null"` // Reference to the user who used the coupon
-	User      User      `gorm:"foreignKey:UserID"`
-	UsedAt    time.Time `gorm:"autoCreateTime"` // Timestamp of when the coupon was used
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ID         uint      `gorm:"primaryKey"`
+	CouponID   uint      `gorm:"not null"` // Reference to Coupon
+	Category   Coupon    `gorm:"foreignKey:CouponID"`
+	UserID     *uint     // Reference to the user who used the coupon, nil for guest orders
+	User       *User     `gorm:"foreignKey:UserID"`
+	OrderID    *uint     `gorm:"index"`          // Order the coupon was redeemed on
+	UsedAt     time.Time `gorm:"autoCreateTime"` // Timestamp of when the coupon was used
+	ReversedAt *time.Time
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiscountRulesValidate(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	after, before := start.Add(24*time.Hour), start.Add(-24*time.Hour)
+	zero, ten := 0.0, 10.0
+
+	tests := []struct {
+		name  string
+		rules DiscountRules
+		ok    bool
+	}{
+		{"percentage", DiscountRules{DiscountType: "percentage", DiscountValue: 15}, true},
+		{"full percentage", DiscountRules{DiscountType: "percentage", DiscountValue: 100}, true},
+		{"percentage over 100", DiscountRules{DiscountType: "percentage", DiscountValue: 101}, false},
+		{"zero percentage", DiscountRules{DiscountType: "percentage", DiscountValue: 0}, false},
+		{"fixed", DiscountRules{DiscountType: "fixed", DiscountValue: 250}, true},
+		{"negative fixed", DiscountRules{DiscountType: "fixed", DiscountValue: -5}, false},
+		{"free shipping ignores the value", DiscountRules{DiscountType: "free_shipping", DiscountValue: -5}, true},
+		{"max discount", DiscountRules{DiscountType: "percentage", DiscountValue: 20, MaxDiscountValue: &ten}, true},
+		{"zero max discount", DiscountRules{DiscountType: "percentage", DiscountValue: 20, MaxDiscountValue: &zero}, false},
+		{"expires after the start", DiscountRules{DiscountType: "fixed", DiscountValue: 5, StartDate: start, ExpirationDate: &after}, true},
+		{"expires at the start", DiscountRules{DiscountType: "fixed", DiscountValue: 5, StartDate: start, ExpirationDate: &start}, false},
+		{"expires before the start", DiscountRules{DiscountType: "fixed", DiscountValue: 5, StartDate: start, ExpirationDate: &before}, false},
+	}
+	for _, tt := range tests {
+		err := tt.rules.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	rules := DiscountRules{DiscountType: "free_shipping", DiscountValue: 5}
+	if err := rules.validate(); err != nil || rules.DiscountValue != 0 {
+		t.Errorf("free shipping validate() = %v with value %v, want nil with value 0", err, rules.DiscountValue)
+	}
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidEmailVerification = errors.New("verification link is invalid or has expired")
+
+// EmailVerification is a one time link emailed to a customer to prove the email of their account is
+// theirs. Only the hash of the token is kept.
+type EmailVerification struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Email     string    `gorm:"size:100;not null"` // Address the link was sent to, it stops working when the account email changes
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// EmailVerificationTTL is how long a verification link can be used, EMAIL_VERIFICATION_HOURS (default 24)
+func EmailVerificationTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("EMAIL_VERIFICATION_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewEmailVerification creates a verification of the current email of a user and returns the token to
+// email to it
+func NewEmailVerification(db *gorm.DB, user *User) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	verification := EmailVerification{
+		UserID:    user.ID,
+		Email:     strings.ToLower(strings.TrimSpace(user.Email)),
+		TokenHash: hashVerificationToken(token),
+		ExpiresAt: time.Now().Add(EmailVerificationTTL()),
+	}
+	return token, db.Create(&verification).Error
+}
+
+// VerifyEmail uses up a verification token, marks the email of its user verified and attaches the guest
+// orders placed with the email. It returns the user and how many orders were attached.
+func VerifyEmail(tx *gorm.DB, token string) (*User, int64, error) {
+	var verification EmailVerification
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", hashVerificationToken(token)).
+		First(&verification).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, ErrInvalidEmailVerification
+		}
+		return nil, 0, err
+	}
+	now := time.Now()
+	if verification.UsedAt != nil || !now.Before(verification.ExpiresAt) {
+		return nil, 0, ErrInvalidEmailVerification
+	}
+
+	var user User
+	if err := tx.First(&user, verification.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, ErrInvalidEmailVerification
+		}
+		return nil, 0, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(user.Email), verification.Email) {
+		return nil, 0, ErrInvalidEmailVerification
+	}
+
+	if err := tx.Model(&verification).Update("used_at", now).Error; err != nil {
+		return nil, 0, err
+	}
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &now
+		if err := tx.Model(&user).UpdateColumn("email_verified_at", now).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	attached, err := AttachGuestOrders(tx, user.ID, user.Email)
+	return &user, attached, err
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TenderMethods are the payment methods paid from balances held with the shop rather than with money.
+// They don't count as revenue, the money was taken when the gift card was sold or the order refunded.
+var TenderMethods = []string{"gift_card", "store_credit"}
+
+// IsGiftCard reports whether buying the product issues a gift card of its price
+func (p *Product) IsGiftCard() bool {
+	return p.ProductType == "gift_card"
+}
+
+// GiftCard is a prepaid balance redeemable with its code. Every change of the balance is recorded in
+// its transactions.
+type GiftCard struct {
+	ID             uint                  `gorm:"primaryKey"`
+	Code           string                `gorm:"size:50;not null;uniqueIndex"`
+	InitialBalance float64               `gorm:"type:decimal(10,2);not null"`
+	Balance        float64               `gorm:"type:decimal(10,2);not null;check:balance >= 0"`
+	Currency       string                `gorm:"size:3;not null"`
+	ExpiresAt      *time.Time            // Never expires when nil
+	IsActive       bool                  `gorm:"default:true"`
+	PurchaserID    *uint                 `gorm:"index"` // Customer who bought the card
+	OrderItemID    *uint                 `gorm:"index"` // Order line the card was sold on
+	RecipientEmail *string               `gorm:"size:100"`
+	Note           string                `gorm:"size:255"`
+	Transactions   []GiftCardTransaction `gorm:"foreignKey:GiftCardID;constraint:OnDelete:CASCADE" json:",omitempty"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Usable returns why the card cannot pay at the given time, or nil when it can
+func (g *GiftCard) Usable(at time.Time) error {
+	switch {
+	case !g.IsActive:
+		return errors.New("gift card is not active")
+	case g.ExpiresAt != nil && at.After(*g.ExpiresAt):
+		return errors.New("gift card has expired")
+	case g.Balance <= 0:
+		return errors.New("gift card has no balance left")
+	}
+	return nil
+}
+
+// GiftCardTransaction is a change of a gift card balance: issue and refund add to it, redeem takes from
+// it and adjust corrects it either way
+type GiftCardTransaction struct {
+	ID         uint    `gorm:"primaryKey"`
+	GiftCardID uint    `gorm:"not null;index"`
+	Kind       string  `gorm:"size:20;not null;check:kind IN ('issue', 'redeem', 'refund', 'adjust')"`
+	Amount     float64 `gorm:"type:decimal(10,2);not null"` // Negative when taken off the balance
+	Balance    float64 `gorm:"type:decimal(10,2);not null"` // Balance after the transaction
+	OrderID    *uint   `gorm:"index"`
+	Note       string  `gorm:"size:255"`
+	CreatedAt  time.Time
+}
+
+// StoreCreditTransaction is a change of a customer's store credit. Credit is issued by refunds, support
+// or as referral reward and redeemed on orders, a reversal gives back what a cancelled order redeemed.
+type StoreCreditTransaction struct {
+	ID         uint    `gorm:"primaryKey"`
+	UserID     uint    `gorm:"not null;index"`
+	User       User    `gorm:"foreignKey:UserID" json:"-"`
+	Kind       string  `gorm:"size:20;not null;check:kind IN ('refund', 'support', 'redeem', 'reversal', 'referral')"`
+	Amount     float64 `gorm:"type:decimal(10,2);not null"` // Negative when taken off the balance
+	Balance    float64 `gorm:"type:decimal(10,2);not null"` // Balance after the transaction
+	OrderID    *uint   `gorm:"index"`
+	Reason     string  `gorm:"size:255"`
+	IssuedByID *uint   // Admin who issued the credit
+	CreatedAt  time.Time
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"backend/imaging"
+	"backend/storage"
+	"backend/utils"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rendition is a resized copy of an image, stored once per output format
+type Rendition struct {
+	Width  int
+	Height int
+	Keys   map[string]string // Storage key by format, e.g. "jpeg" and "webp"
+}
+
+// Renditions maps a rendition name such as "thumbnail" to its stored files.
+// A nil value means the image has not been processed yet.
+type Renditions map[string]Rendition
+
+func (r Renditions) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *Renditions) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return errors.New("unsupported type for renditions")
+}
+
+// Srcset returns the public URL of every rendition by name and format, plus a
+// srcset attribute value per format under the "srcset" key.
+func (r Renditions) Srcset() map[string]map[string]string {
+	if len(r) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return r[names[i]].Width < r[names[j]].Width })
+
+	srcset := map[string]map[string]string{"srcset": {}}
+	candidates := map[string][]string{}
+
+	for _, name := range names {
+		rendition := r[name]
+		srcset[name] = map[string]string{}
+		for format, key := range rendition.Keys {
+			url := storage.URL(key)
+			srcset[name][format] = url
+			candidates[format] = append(candidates[format], url+" "+strconv.Itoa(rendition.Width)+"w")
+		}
+	}
+
+	for format, list := range candidates {
+		srcset["srcset"][format] = strings.Join(list, ", ")
+	}
+
+	return srcset
+}
+
+// errUploadNotStored is returned when an image is created before its base64 upload was stored
+var errUploadNotStored = errors.New("image upload must be stored before the image is created")
+
+// storeBase64Image validates a base64 encoded upload, strips its metadata and stores it under prefix. The
+// store is not part of the database transaction, so uploads are stored before it starts and the caller
+// removes them when the create fails rather than leaving them orphaned by a rollback.
+func storeBase64Image(ctx context.Context, prefix string, encoded *string, key *string) error {
+	if *key != "" {
+		return nil
+	}
+	bt, err := utils.DecodeBase64Image(*encoded)
+	if err != nil {
+		return err
+	}
+
+	clean, _, err := imaging.Sanitize(bt, imaging.DefaultLimits())
+	if err != nil {
+		return err
+	}
+
+	if *key, err = storage.SaveBytes(ctx, prefix, clean); err != nil {
+		return err
+	}
+	*encoded = ""
+	return nil
+}
+
+// StorageKeys lists the stored file of every rendition and format
+func (r Renditions) StorageKeys() []string {
+	var keys []string
+	for _, rendition := range r {
+		for _, key := range rendition.Keys {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
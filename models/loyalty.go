@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoyaltyTransaction is a change of a customer's points. Positive transactions are lots whose Remaining
+// points are used up by redemptions oldest first and expire at ExpiresAt. Kinds:
+//   - earn: points for an order, bonus: review and birthday points, adjust: by an admin, either way
+//   - redeem: points taken off an order, expire: points that were not used in time
+//   - reversal: earned points taken back from a cancelled or refunded order, or redeemed points given back
+type LoyaltyTransaction struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"foreignKey:UserID" json:"-"`
+	Kind      string `gorm:"size:20;not null;check:kind IN ('earn', 'bonus', 'redeem', 'expire', 'adjust', 'reversal')"`
+	Points    int    `gorm:"not null"` // Negative when taken off the balance
+	Remaining int    `gorm:"not null;default:0" json:"-"`
+	ExpiresAt *time.Time
+	OrderID   *uint  `gorm:"index"`
+	ReviewID  *uint  `gorm:"index"`
+	Reason    string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+// LoyaltyRule is an earn rule of the loyalty programme:
+//   - spend: PointsPerUnit for every currency unit paid for items
+//   - category: PointsPerUnit more for every currency unit paid for items of the category and its subcategories
+//   - review: Points for the first approved review of a product
+//   - birthday: Points on the customer's birthday once a year
+type LoyaltyRule struct {
+	gorm.Model
+	Name          string    `gorm:"size:150;not null"`
+	Kind          string    `gorm:"size:20;not null;check:kind IN ('spend', 'category', 'review', 'birthday')"`
+	CategoryID    *uint     `gorm:"index"`
+	Category      *Category `gorm:"foreignKey:CategoryID" json:"-"`
+	PointsPerUnit float64   `gorm:"type:numeric(10,2);default:0"`
+	Points        int       `gorm:"default:0"`
+	IsActive      bool      `gorm:"default:true"`
+}
+
+func (r *LoyaltyRule) BeforeSave(tx *gorm.DB) (err error) {
+	switch r.Kind {
+	case "spend", "category":
+		if r.PointsPerUnit <= 0 {
+			return errors.New("spend and category rules need a positive PointsPerUnit")
+		}
+		if r.Kind == "category" && r.CategoryID == nil {
+			return errors.New("category rules need a CategoryID")
+		}
+		r.Points = 0
+	case "review", "birthday":
+		if r.Points <= 0 {
+			return errors.New("review and birthday rules need positive Points")
+		}
+		r.PointsPerUnit = 0
+	}
+	if r.Kind != "category" {
+		r.CategoryID = nil
+	}
+	return nil
+}
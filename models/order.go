@@ -2,25 +2,99 @@ package models
 
 import (
 	"backend/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type Order struct {
 	gorm.Model
-	OrderIdentifier      string      `gorm:"type:varchar(8); not null;unique;index"`
-	UserID               uint        `gorm:"not null"`
-	User                 User        `gorm:"foreignKey:UserID"`
-	OrderStatus          string      `gorm:"size:50;not null;check:order_status IN ('pending', 'shipped', 'delivered', 'cancelled')"`
-	Currency             *string     `gorm:"size:3; not null"`
-	TotalPrice           float64     `gorm:"type:decimal(10,2);not null"`
-	ItemPrice            float64     `gorm:"type:decimal(10,2);not null"`
-	DiscountAmount       float64     `gorm:"type:decimal(10,2);default:0;not null"`
-	ShippingCost         float64     `gorm:"type:decimal(10,2);default:0;not null"`
-	OrderItems           []OrderItem `gorm:"foreignKey:OrderID"`
-	OrderShippingAddress string      `gorm:"type:text"`
-	PaymentDetails       *Payment    `gorm:"-"`
-	Coupon               string      `gorm:"-"`
+	OrderIdentifier      string          `gorm:"type:varchar(8); not null;unique;index"`
+	UserID               *uint           `gorm:"index"` // Nil for guest checkouts
+	User                 *User           `gorm:"foreignKey:UserID"`
+	GuestEmail           *string         `gorm:"size:100;index;check:chk_orders_customer,user_id IS NOT NULL OR guest_email IS NOT NULL"`
+	GuestPhone           *string         `gorm:"size:20"`
+	OrderStatus          string          `gorm:"size:50;not null;check:order_status IN ('pending', 'shipped', 'delivered', 'cancelled')"`
+	Currency             *string         `gorm:"size:3; not null"`
+	TotalPrice           float64         `gorm:"type:decimal(10,2);not null"`
+	ItemPrice            float64         `gorm:"type:decimal(10,2);not null"`
+	DiscountAmount       float64         `gorm:"type:decimal(10,2);default:0;not null"`
+	ShippingCost         float64         `gorm:"type:decimal(10,2);default:0;not null"`
+	OrderItems           []OrderItem     `gorm:"foreignKey:OrderID"`
+	OrderShippingAddress string          `gorm:"type:text"`
+	PaymentDetails       *Payment        `gorm:"-"`
+	Coupon               string          `gorm:"-"`
+	Coupons              []string        `gorm:"-"` // Further codes to combine with Coupon
+	Discounts            []OrderDiscount `gorm:"foreignKey:OrderID"`
+	GiftCards            []string        `gorm:"-"` // Codes of gift cards paying for the order
+	StoreCredit          float64         `gorm:"-"` // Store credit to pay with
+	LoyaltyPoints        int             `gorm:"-"` // Loyalty points to redeem as a discount
+}
+
+// CouponCodes returns the distinct coupon codes the order was placed with
+func (o *Order) CouponCodes() []string {
+	var codes []string
+	seen := map[string]bool{}
+	for _, code := range append([]string{o.Coupon}, o.Coupons...) {
+		if code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// LookupToken lets a guest track and pay for their order without an account, for GuestOrderTokenTTL
+// after the order was placed. It only proves the holder placed the order, not that the email is theirs.
+func (o *Order) LookupToken() string {
+	return utils.SignExpiringToken(guestOrderToken, strconv.FormatUint(uint64(o.ID), 10), o.CreatedAt.Add(GuestOrderTokenTTL()))
+}
+
+const guestOrderToken = "guest-order"
+
+// GuestOrderTokenTTL is how long lookup tokens stay valid, GUEST_ORDER_TOKEN_DAYS (default 90)
+func GuestOrderTokenTTL() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("GUEST_ORDER_TOKEN_DAYS"))
+	if err != nil || days <= 0 {
+		days = 90
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GuestOrderID returns the order a lookup token was made for, unless the token has expired
+func GuestOrderID(token string) (uint, bool) {
+	value, ok := utils.VerifyExpiringToken(guestOrderToken, token, time.Now())
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return uint(id), err == nil
+}
+
+// AttachGuestOrders moves the guest orders placed with an email, and the coupon and campaign code uses
+// of those orders, to the account that owns it. It must only be called once the customer proved the
+// email is theirs with an EmailVerification.
+func AttachGuestOrders(db *gorm.DB, userID uint, email string) (int64, error) {
+	var ids []uint
+	if err := db.Model(&Order{}).
+		Where("user_id IS NULL AND guest_email = ?", strings.ToLower(strings.TrimSpace(email))).
+		Pluck("id", &ids).Error; err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Order{}).Where("id IN ?", ids).Update("user_id", userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&CouponUsageHistory{}).Where("order_id IN ? AND user_id IS NULL", ids).Update("user_id", userID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&CampaignCode{}).Where("order_id IN ? AND user_id IS NULL", ids).Update("user_id", userID).Error
+	})
+	return int64(len(ids)), err
 }
 
 func (o *Order) BeforeCreate(tx *gorm.DB) (err error) {
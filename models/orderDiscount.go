@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OrderDiscount is the part of a coupon or promotion that was taken off one line of an order, or off its
+// shipping when OrderItemID is nil
+type OrderDiscount struct {
+	ID          uint    `gorm:"primaryKey"`
+	OrderID     uint    `gorm:"not null;index"`
+	Order       Order   `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"-"`
+	OrderItemID *uint   `gorm:"index"`
+	ProductID   *uint   // Product of the line, kept for reporting
+	CouponID    *uint   `gorm:"index"`
+	CampaignID  *uint   `gorm:"index"`   // Campaign of the code, for campaign statistics
+	PromotionID *uint   `gorm:"index"`   // Automatic promotion, for per customer limits
+	Code        string  `gorm:"size:50"` // Coupon code at the time of the order
+	Description string  `gorm:"size:255"`
+	Amount      float64 `gorm:"type:decimal(10,2);not null"`
+	CreatedAt   time.Time
+}
@@ -8,4 +8,5 @@ type OrderItem struct {
 	Product         Product `gorm:"foreignKey:ProductID"`
 	Quantity        int     `gorm:"not null"`
 	PriceAtPurchase float64 `gorm:"type:decimal(10,2);not null"`
+	DiscountAmount  float64 `gorm:"type:decimal(10,2);default:0;not null"` // Coupon and promotion discounts on the whole line
 }
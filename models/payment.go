@@ -6,15 +6,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// Payment is one tender of an order. Gift cards and store credit pay part of an order next to its
+// payment method, so an order can have several payments.
 type Payment struct {
 	gorm.Model
-	PaymentMethod  string  `gorm:"size:50;not null;check:payment_method IN ('cash_on_delivery', 'paypal')"`
-	PaymentStatus  string  `gorm:"size:50;not null;check:payment_status IN ('pending', 'completed', 'failed')"`
+	PaymentMethod  string  `gorm:"size:50;not null;check:payment_method IN ('cash_on_delivery', 'paypal', 'gift_card', 'store_credit')"`
+	PaymentStatus  string  `gorm:"size:50;not null;check:payment_status IN ('pending', 'completed', 'failed', 'refunded')"`
 	Amount         float64 `gorm:"type:decimal(10,2);not null"`
 	TransanctionID *string `gorm:"size:11;not null"`
 	PaymentDate    *time.Time
 	OrderID        uint  `gorm:"not null"`
 	Order          Order `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	GiftCardID     *uint `gorm:"index"` // Card of gift_card payments
 }
 
 type PaymentOption struct {
@@ -1,7 +1,13 @@
 package models
 
 import (
+	"backend/storage"
 	"backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -10,8 +16,9 @@ type Product struct {
 	gorm.Model
 	Name        string   `gorm:"size:150;not null"`
 	Description string   `gorm:"type:text"`
-	SKU         string   `gorm:"size:150;not null;unique;index"`
+	SKU         string   `gorm:"size:150;not null;uniqueIndex:idx_products_sku_active,where:deleted_at IS NULL"`
 	Barcode     *string  `gorm:"size:150"`
+	Brand       *string  `gorm:"size:100;index"`
 	Price       float64  `gorm:"type:decimal(10,2);not null"`
 	Currency    string   `gorm:"size:3; not null"`
 	CategoryID  uint     `gorm:"not null"`
@@ -23,39 +30,302 @@ type Product struct {
 	ParentID    *uint
 	Size        string
 	Images      []ProductImage `gorm:"foreignKey:ProductID"`
+
+	PublishAt    *time.Time // Status is switched to published at this time
+	UnpublishAt  *time.Time // Status is switched to unpublished at this time
+	SalePrice    *float64   `gorm:"type:decimal(10,2)"` // Replaces Price between SaleStartsAt and SaleEndsAt
+	SaleStartsAt *time.Time
+	SaleEndsAt   *time.Time
+
+	ProductType      string            `gorm:"size:20;not null;default:simple;check:product_type IN ('simple', 'bundle', 'gift_card')"`
+	BundlePricing    *string           `gorm:"size:20;check:bundle_pricing IN ('fixed', 'sum')"` // Bundles either use Price or the sum of their components
+	BundleDiscount   float64           `gorm:"type:decimal(5,2);default:0"`                      // Percentage taken off the components of a sum priced bundle
+	BundleComponents []BundleComponent `gorm:"foreignKey:BundleID"`
+
+	Slug            *string `gorm:"size:200;uniqueIndex"` // Only parent products have a slug
+	MetaTitle       *string `gorm:"size:200"`
+	MetaDescription *string `gorm:"size:500"`
+	CanonicalURL    *string `gorm:"size:500"`
+
+	previous *Product // State before an update, used for the revision diff
 }
 
-type ProductImage struct {
-	ID         uint    `gorm:"primaryKey"`
-	ProductID  uint    // Foreign key to the Product
-	Product    Product `gorm:"foreignKey:ProductID" json:"-"`
-	Image      string  `gorm:"-" json:"Image"`
-	ImageBytes []byte  `gorm:"column:image;type:bytea" json:"-"`
+// ProductRevisionFields are the fields a revision restore puts back. Stock, images, attributes and bundle
+// components have their own history and are left out, as are the parent and variation links.
+var ProductRevisionFields = []string{
+	"Name", "Description", "SKU", "Barcode", "Brand", "Price", "Currency", "CategoryID", "Status", "Featured", "Size",
+	"PublishAt", "UnpublishAt", "SalePrice", "SaleStartsAt", "SaleEndsAt",
+	"ProductType", "BundlePricing", "BundleDiscount",
+	"Slug", "MetaTitle", "MetaDescription", "CanonicalURL",
 }
 
-func (c *ProductImage) BeforeCreate(tx *gorm.DB) (err error) {
+// RestoreRevision copies the ProductRevisionFields of a snapshot onto the product and undeletes it. Saving
+// with Select(ProductRevisionFields, "DeletedAt") then writes exactly those columns, and a restored slug
+// leaves a redirect from the current one like any other slug change.
+func (p *Product) RestoreRevision(snapshot *Product) {
+	target := reflect.ValueOf(p).Elem()
+	source := reflect.ValueOf(snapshot).Elem()
+	for _, name := range ProductRevisionFields {
+		target.FieldByName(name).Set(source.FieldByName(name))
+	}
+	p.DeletedAt = gorm.DeletedAt{}
+}
 
-	bt, err := utils.DecodeBase64Image(c.Image)
-	if err != nil {
+func (p *Product) BeforeSave(tx *gorm.DB) (err error) {
+	if p.ProductType == "" {
+		p.ProductType = "simple"
+	}
+	if p.IsBundle() {
+		if p.IsChild {
+			return errors.New("variations cannot be bundles")
+		}
+		if p.BundlePricing == nil {
+			pricing := "fixed"
+			p.BundlePricing = &pricing
+		}
+		if p.BundleDiscount < 0 || p.BundleDiscount >= 100 {
+			return errors.New("bundle discount must be a percentage between 0 and 100")
+		}
+	} else {
+		p.BundlePricing = nil
+		p.BundleDiscount = 0
+	}
+	if p.SalePrice != nil && (*p.SalePrice < 0 || *p.SalePrice >= p.Price) {
+		return errors.New("sale price must be lower than the regular price")
+	}
+	if p.SaleStartsAt != nil && p.SaleEndsAt != nil && !p.SaleEndsAt.After(*p.SaleStartsAt) {
+		return errors.New("sale end must be after the sale start")
+	}
+	if p.PublishAt != nil && p.UnpublishAt != nil && !p.UnpublishAt.After(*p.PublishAt) {
+		return errors.New("unpublish time must be after the publish time")
+	}
+
+	return nil
+}
+
+func (p *Product) BeforeCreate(tx *gorm.DB) (err error) {
+	if p.IsChild {
+		p.Slug = nil
+		return nil
+	}
+	if p.Slug != nil && *p.Slug != "" {
+		return validateSlugChange(tx, "products", "product", *p.Slug, 0)
+	}
+
+	slug, err := uniqueSlug(tx, "products", "product", utils.Slugify(p.Name), 0)
+	p.Slug = &slug
+	return err
+}
+
+func (p *Product) AfterCreate(tx *gorm.DB) (err error) {
+	return recordRevision(tx, "product", p.ID, "create", nil, p)
+}
+
+func (p *Product) BeforeUpdate(tx *gorm.DB) (err error) {
+	if p.ID == 0 {
+		return nil
+	}
+	p.previous = &Product{}
+	if err := loadPrevious(tx, p.previous, p.ID); err != nil {
 		return err
 	}
 
-	c.ImageBytes = bt
+	// Slugs stay stable across renames, they only change when set explicitly
+	if p.IsChild || p.Slug == nil || *p.Slug == "" {
+		p.Slug = p.previous.Slug
+	} else if p.previous.Slug == nil || *p.Slug != *p.previous.Slug {
+		return validateSlugChange(tx, "products", "product", *p.Slug, p.ID)
+	}
+	return nil
+}
+
+func (p *Product) AfterUpdate(tx *gorm.DB) (err error) {
+	if p.previous == nil {
+		return nil
+	}
+	previous := p.previous
+	p.previous = nil
+	if err := recordSlugRedirect(tx, "product", p.ID, previous.Slug, p.Slug); err != nil {
+		return err
+	}
+	if err := recordRevision(tx, "product", p.ID, "update", previous, p); err != nil {
+		return err
+	}
 
+	// Keep sum priced bundles in line with their components
+	if p.IsBundle() {
+		if p.BundleDiscount != previous.BundleDiscount || !reflect.DeepEqual(p.BundlePricing, previous.BundlePricing) {
+			return repriceBundles(tx, "products.id = ?", p.ID)
+		}
+	} else if p.Price != previous.Price {
+		return repriceBundles(tx, "products.id IN (SELECT bundle_id FROM bundle_components WHERE component_id = ?)", p.ID)
+	}
 	return nil
+}
+
+func (p *Product) AfterDelete(tx *gorm.DB) (err error) {
+	if p.ID == 0 {
+		return nil
+	}
+	return recordRevision(tx, "product", p.ID, "delete", p, nil)
+}
+
+// OnSale reports whether the sale price applies at the given time
+func (p *Product) OnSale(at time.Time) bool {
+	if p.SalePrice == nil {
+		return false
+	}
+	if p.SaleStartsAt != nil && at.Before(*p.SaleStartsAt) {
+		return false
+	}
+	if p.SaleEndsAt != nil && !at.Before(*p.SaleEndsAt) {
+		return false
+	}
+	return true
+}
+
+// EffectivePrice is the price a customer pays at the given time
+func (p *Product) EffectivePrice(at time.Time) float64 {
+	if p.OnSale(at) {
+		return *p.SalePrice
+	}
+	return p.Price
+}
+
+// IsPublished reports whether the product is visible in the storefront at the given time
+func (p *Product) IsPublished(at time.Time) bool {
+	if p.Status == nil || *p.Status != "published" {
+		return false
+	}
+	if p.PublishAt != nil && at.Before(*p.PublishAt) {
+		return false
+	}
+	if p.UnpublishAt != nil && !at.Before(*p.UnpublishAt) {
+		return false
+	}
+	return true
+}
 
+type ProductImage struct {
+	ID         uint                         `gorm:"primaryKey"`
+	ProductID  uint                         // Foreign key to the Product
+	Product    Product                      `gorm:"foreignKey:ProductID" json:"-"`
+	StorageKey string                       `gorm:"size:255" json:"-"` // Key of the image in the blob store
+	URL        string                       `gorm:"-"`                 // Public URL of the image
+	Renditions Renditions                   `gorm:"type:jsonb" json:"-"`
+	Srcset     map[string]map[string]string `gorm:"-" json:",omitempty"`      // Rendition URLs by name and format
+	Image      string                       `gorm:"-" json:"Image,omitempty"` // Base64 upload, only used on create
+	SourceURL  *string                      `gorm:"size:2048;index" json:"-"` // Address an imported image was downloaded from
+}
+
+// StoreUpload stores the base64 upload of an image that is about to be created
+func (c *ProductImage) StoreUpload(ctx context.Context) error {
+	return storeBase64Image(ctx, "products", &c.Image, &c.StorageKey)
+}
+
+func (c *ProductImage) BeforeCreate(tx *gorm.DB) (err error) {
+	if c.StorageKey == "" {
+		return errUploadNotStored
+	}
+	return nil
 }
 func (c *ProductImage) AfterFind(tx *gorm.DB) (err error) {
-	c.Image = utils.EncodeImageToBase64(c.ImageBytes)
+	c.URL = storage.URL(c.StorageKey)
+	c.Srcset = c.Renditions.Srcset()
 
 	return nil
 
 }
 
+// ProductAttribute is a specification of a product. Attributes with a definition are structured and
+// filterable, their Name and Description are filled from the definition and the typed value.
 type ProductAttribute struct {
 	gorm.Model
-	Name        string  `gorm:"size:150;not null"`
-	Description string  `gorm:"type:text"`
-	ProductID   uint    `gorm:"not null"`
-	Product     Product `gorm:"foreignKey:ProductID"`
+	Name         string               `gorm:"size:150;not null"`
+	Description  string               `gorm:"type:text"`
+	ProductID    uint                 `gorm:"not null;index"`
+	Product      Product              `gorm:"foreignKey:ProductID"`
+	DefinitionID *uint                `gorm:"index"`
+	Definition   *AttributeDefinition `gorm:"foreignKey:DefinitionID" json:",omitempty"`
+	Value        string               `gorm:"-" json:",omitempty"` // Raw value of a structured attribute, only used on create and update
+	TextValue    *string              `gorm:"size:255"`            // Value of text and enum attributes
+	NumberValue  *float64
+	BooleanValue *bool
+
+	previous *ProductAttribute
+}
+
+func (a *ProductAttribute) BeforeSave(tx *gorm.DB) (err error) {
+	if a.DefinitionID == nil {
+		return nil
+	}
+	if a.Value == "" {
+		if a.TextValue == nil && a.NumberValue == nil && a.BooleanValue == nil {
+			return errors.New("structured attributes need a Value")
+		}
+		return nil
+	}
+
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	var definition AttributeDefinition
+	if err := db.First(&definition, *a.DefinitionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("attribute definition not found")
+		}
+		return err
+	}
+
+	var product Product
+	if err := db.Select("id, category_id").First(&product, a.ProductID).Error; err != nil {
+		return err
+	}
+	lineage, err := CategoryLineage(db, product.CategoryID)
+	if err != nil {
+		return err
+	}
+	belongs := false
+	for _, id := range lineage {
+		belongs = belongs || id == definition.CategoryID
+	}
+	if !belongs {
+		return fmt.Errorf("%s does not apply to products in this category", definition.Name)
+	}
+
+	if a.TextValue, a.NumberValue, a.BooleanValue, err = definition.ParseValue(a.Value); err != nil {
+		return err
+	}
+	a.Name = definition.Name
+	a.Description = definition.FormatValue(a.TextValue, a.NumberValue, a.BooleanValue)
+	a.Value = ""
+
+	return nil
+}
+
+func (a *ProductAttribute) AfterCreate(tx *gorm.DB) (err error) {
+	return recordRevision(tx, "product_attribute", a.ID, "create", nil, a)
+}
+
+func (a *ProductAttribute) BeforeUpdate(tx *gorm.DB) (err error) {
+	if a.ID == 0 {
+		return nil
+	}
+	a.previous = &ProductAttribute{}
+	return loadPrevious(tx, a.previous, a.ID)
+}
+
+func (a *ProductAttribute) AfterUpdate(tx *gorm.DB) (err error) {
+	if a.previous == nil {
+		return nil
+	}
+	previous := a.previous
+	a.previous = nil
+	return recordRevision(tx, "product_attribute", a.ID, "update", previous, a)
+}
+
+func (a *ProductAttribute) AfterDelete(tx *gorm.DB) (err error) {
+	if a.ID == 0 {
+		return nil
+	}
+	return recordRevision(tx, "product_attribute", a.ID, "delete", a, nil)
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ProductAlert asks for an email about a product or variation: back_in_stock once an out of stock product
+// can be ordered again, price_drop every time the price of a wishlist product falls below the price the
+// customer was last told about
+type ProductAlert struct {
+	ID         uint       `gorm:"primaryKey"`
+	UserID     uint       `gorm:"not null;uniqueIndex:idx_product_alerts_user_product_kind"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+	ProductID  uint       `gorm:"not null;uniqueIndex:idx_product_alerts_user_product_kind;index"`
+	Product    Product    `gorm:"foreignKey:ProductID" json:"-"`
+	Kind       string     `gorm:"size:20;not null;uniqueIndex:idx_product_alerts_user_product_kind;check:kind IN ('back_in_stock', 'price_drop')"`
+	Price      *float64   `gorm:"type:decimal(10,2)"` // Price drops are measured from, price_drop only
+	NotifiedAt *time.Time // Last email about it, back in stock alerts are done once notified
+	CreatedAt  time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ProductImportJob struct {
+	gorm.Model
+	UserID        uint            `gorm:"not null"`
+	User          User            `gorm:"foreignKey:UserID" json:"-"`
+	FileName      string          `gorm:"size:255;not null"`
+	DryRun        bool            `gorm:"default:false"`
+	Status        string          `gorm:"size:20;not null;default:'pending';check:status IN ('pending', 'running', 'completed', 'failed')"`
+	TotalRows     int             `gorm:"default:0"`
+	ProcessedRows int             `gorm:"default:0"`
+	CreatedCount  int             `gorm:"default:0"`
+	UpdatedCount  int             `gorm:"default:0"`
+	FailedCount   int             `gorm:"default:0"`
+	RowErrors     json.RawMessage `gorm:"type:jsonb"` // Validation errors by spreadsheet line
+	Error         *string         `gorm:"type:text"`  // Set when the whole job failed
+	FinishedAt    *time.Time
+}
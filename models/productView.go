@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ProductView records a product detail page view by a user, or by an anonymous session
+type ProductView struct {
+	ID        uint      `gorm:"primaryKey"`
+	ProductID uint      `gorm:"not null;index"`
+	Product   Product   `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE" json:"-"`
+	UserID    *uint     `gorm:"index:idx_product_views_user"`
+	SessionID *string   `gorm:"size:64;index:idx_product_views_session"`
+	ViewedAt  time.Time `gorm:"not null;index:idx_product_views_user;index:idx_product_views_session"`
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// Promotion is a discount applied automatically to every cart that qualifies, without a code:
+//   - bogo: for every BuyQuantity eligible items bought, GetQuantity more get DiscountValue percent off,
+//     e.g. buy 2 get 1 free is 2, 1 and 100. The cheapest items are the discounted ones.
+//   - threshold: the discount of the rules once the eligible items reach MinOrderValue, e.g. 10% off
+//     orders over 50
+//   - tiered: the discount of the highest tier the eligible items reach, of DiscountType
+type Promotion struct {
+	gorm.Model
+	Name        string `gorm:"size:150;not null"`
+	Description string `gorm:"type:text"`
+	Badge       string `gorm:"size:50"` // Shown on product listings, the name when empty
+	Kind        string `gorm:"size:20;not null;check:kind IN ('bogo', 'threshold', 'tiered')"`
+	DiscountRules
+	BuyQuantity       int             // Items to buy for bogo promotions
+	GetQuantity       int             // Items discounted for bogo promotions
+	UsageLimitPerUser *int            // Orders each customer can get the promotion on, unlimited when nil
+	Tiers             []PromotionTier `gorm:"foreignKey:PromotionID;constraint:OnDelete:CASCADE"`
+	Scopes            []CouponScope   `gorm:"foreignKey:PromotionID"`
+}
+
+// PromotionTier is a spend tier of a tiered promotion
+type PromotionTier struct {
+	ID            uint    `gorm:"primaryKey"`
+	PromotionID   uint    `gorm:"not null;index"`
+	MinOrderValue float64 `gorm:"type:numeric(10,2);not null"` // Spend on eligible items that reaches the tier
+	DiscountValue float64 `gorm:"type:numeric(10,2);not null"` // Percentage or fixed amount, after the promotion's DiscountType
+}
+
+func (p *Promotion) BeforeSave(tx *gorm.DB) (err error) {
+	switch p.Kind {
+	case "bogo":
+		if p.BuyQuantity < 1 || p.GetQuantity < 1 {
+			return errors.New("bogo promotions need a BuyQuantity and GetQuantity of at least 1")
+		}
+		if p.DiscountType != "percentage" {
+			return errors.New("bogo promotions take a percentage off the discounted items")
+		}
+	case "threshold":
+		if p.MinOrderValue == nil || *p.MinOrderValue <= 0 {
+			return errors.New("threshold promotions need a MinOrderValue")
+		}
+	case "tiered":
+		if len(p.Tiers) == 0 {
+			return errors.New("tiered promotions need at least one tier")
+		}
+		if p.DiscountType != "percentage" && p.DiscountType != "fixed" {
+			return errors.New("tiered promotions take a percentage or fixed amount off")
+		}
+		sort.Slice(p.Tiers, func(i, j int) bool { return p.Tiers[i].MinOrderValue < p.Tiers[j].MinOrderValue })
+		for _, tier := range p.Tiers {
+			if tier.MinOrderValue <= 0 || tier.DiscountValue <= 0 {
+				return errors.New("tiers need a positive MinOrderValue and DiscountValue")
+			}
+			if p.DiscountType == "percentage" && tier.DiscountValue > 100 {
+				return errors.New("percentage discounts must be between 0 and 100")
+			}
+		}
+		// The tiers carry the conditions, the rules hold the best tier for badges
+		p.MinOrderValue = nil
+		p.DiscountValue = p.Tiers[len(p.Tiers)-1].DiscountValue
+	}
+	if p.UsageLimitPerUser != nil && *p.UsageLimitPerUser < 1 {
+		return errors.New("usage limit per user must be at least 1")
+	}
+	return p.DiscountRules.validate()
+}
+
+// Tier returns the highest tier the spend reaches, nil when it reaches none
+func (p *Promotion) Tier(spend float64) *PromotionTier {
+	var reached *PromotionTier
+	for i := range p.Tiers {
+		if spend >= p.Tiers[i].MinOrderValue && (reached == nil || p.Tiers[i].MinOrderValue > reached.MinOrderValue) {
+			reached = &p.Tiers[i]
+		}
+	}
+	return reached
+}
+
+// Coupon returns a coupon with the rules of the promotion that stands for it in the promotion engine
+func (p *Promotion) Coupon() *Coupon {
+	return &Coupon{
+		Description:   p.Name,
+		DiscountRules: p.DiscountRules,
+		Scopes:        p.Scopes,
+		Promotion:     p,
+	}
+}
+
+// BadgeText is the label of the promotion on product listings
+func (p *Promotion) BadgeText() string {
+	if p.Badge != "" {
+		return p.Badge
+	}
+	return p.Name
+}
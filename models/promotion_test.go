@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestPromotionTier(t *testing.T) {
+	promotion := &Promotion{Tiers: []PromotionTier{
+		{MinOrderValue: 100, DiscountValue: 10},
+		{MinOrderValue: 50, DiscountValue: 5},
+		{MinOrderValue: 200, DiscountValue: 20},
+	}}
+
+	tests := []struct {
+		spend float64
+		want  float64 // DiscountValue of the reached tier, 0 for none
+	}{
+		{0, 0},
+		{49.99, 0},
+		{50, 5},
+		{99.99, 5},
+		{100, 10},
+		{150, 10},
+		{200, 20},
+		{1000, 20},
+	}
+	for _, tt := range tests {
+		got := 0.0
+		if tier := promotion.Tier(tt.spend); tier != nil {
+			got = tier.DiscountValue
+		}
+		if got != tt.want {
+			t.Errorf("Tier(%v) gives %v off, want %v", tt.spend, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ProductLink is an admin curated recommendation from one product to another
+type ProductLink struct {
+	ID              uint    `gorm:"primaryKey"`
+	ProductID       uint    `gorm:"not null;uniqueIndex:idx_product_links_link"`
+	Product         Product `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE" json:"-"`
+	LinkedProductID uint    `gorm:"not null;uniqueIndex:idx_product_links_link"`
+	LinkedProduct   Product `gorm:"foreignKey:LinkedProductID;constraint:OnDelete:CASCADE"`
+	LinkType        string  `gorm:"size:20;not null;uniqueIndex:idx_product_links_link;check:link_type IN ('related', 'upsell', 'cross_sell')"`
+	Position        int     `gorm:"not null;default:0"`
+}
+
+// ProductAffinity is a "frequently bought together" pair computed from orders:
+// Support is the share of all orders containing both products and Confidence
+// the share of orders containing ProductID that also contain RelatedProductID
+type ProductAffinity struct {
+	ProductID        uint      `gorm:"primaryKey;autoIncrement:false"`
+	RelatedProductID uint      `gorm:"primaryKey;autoIncrement:false"`
+	Orders           int       `gorm:"not null"` // Orders containing both products
+	Support          float64   `gorm:"not null"`
+	Confidence       float64   `gorm:"not null"`
+	ComputedAt       time.Time `gorm:"not null"`
+}
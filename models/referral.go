@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Referral records that a customer registered with the referral code of another. Statuses:
+//   - pending: waiting for the first delivered order of the referee to reward the referrer
+//   - flagged: held back by a fraud guard until an admin approves or rejects it
+//   - rewarded: the referrer got RewardAmount of store credit
+//   - rejected: neither the welcome discount nor the reward is given
+type Referral struct {
+	ID            uint           `gorm:"primaryKey"`
+	ReferrerID    uint           `gorm:"not null;index"`
+	Referrer      User           `gorm:"foreignKey:ReferrerID" json:"-"`
+	RefereeID     uint           `gorm:"not null;uniqueIndex"` // A customer can only be referred once
+	Referee       User           `gorm:"foreignKey:RefereeID" json:"-"`
+	Code          string         `gorm:"size:20;not null"` // Referral code the referee registered with
+	Status        string         `gorm:"size:20;not null;default:'pending';check:status IN ('pending', 'flagged', 'rewarded', 'rejected')"`
+	Flags         pq.StringArray `gorm:"type:varchar[]"` // Fraud guards that matched: email_domain, address or device
+	WelcomeCodeID *uint          // Campaign code the referee got as welcome discount
+	WelcomeCode   *CampaignCode  `gorm:"foreignKey:WelcomeCodeID" json:",omitempty"`
+	OrderID       *uint          // Order of the referee whose delivery rewarded the referrer
+	RewardAmount  float64        `gorm:"type:decimal(10,2);default:0;not null"`
+	RewardedAt    *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// UserDevice is a device a customer registered or signed in from, known by the X-Device-ID header the
+// apps send
+type UserDevice struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_user_devices_user_device"`
+	DeviceID   string    `gorm:"size:100;not null;uniqueIndex:idx_user_devices_user_device;index"`
+	LastSeenAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Revision records a create, update, delete or restore of a catalogue entity
+type Revision struct {
+	ID         uint            `gorm:"primaryKey"`
+	EntityType string          `gorm:"size:50;not null;index:idx_revisions_entity"`
+	EntityID   uint            `gorm:"not null;index:idx_revisions_entity"`
+	Action     string          `gorm:"size:20;not null;check:action IN ('create', 'update', 'delete', 'restore')"`
+	Changes    json.RawMessage `gorm:"type:jsonb"` // Field name to {"From", "To"}
+	Snapshot   json.RawMessage `gorm:"type:jsonb"` // Entity state after the change, or before a delete
+	ActorID    *uint           // User who made the change, nil for system jobs
+	Actor      *User           `gorm:"foreignKey:ActorID" json:"-"`
+	CreatedAt  time.Time       `gorm:"autoCreateTime;index"`
+}
+
+// FieldChange is the value of a field before and after a change
+type FieldChange struct {
+	From interface{}
+	To   interface{}
+}
+
+type actorKey struct{}
+type revisionActionKey struct{}
+
+// WithActor attaches the user making catalogue changes to a context used with DB.WithContext
+func WithActor(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// WithRevisionAction overrides the action recorded for updates, e.g. "restore"
+func WithRevisionAction(ctx context.Context, action string) context.Context {
+	return context.WithValue(ctx, revisionActionKey{}, action)
+}
+
+// revisionIgnoredFields are timestamps and associations that are not part of an entity's own state
+var revisionIgnoredFields = map[string]bool{
+	"CreatedAt": true, "UpdatedAt": true, "DeletedAt": true,
+	"Category": true, "Images": true, "Product": true, "Products": true, "Image": true, "Stock": true,
+	"BundleComponents": true, "Definition": true, "Value": true,
+	"ProductCount": true, "Breadcrumbs": true,
+}
+
+func actorFromContext(ctx context.Context) *uint {
+	if ctx == nil {
+		return nil
+	}
+	if id, ok := ctx.Value(actorKey{}).(uint); ok {
+		return &id
+	}
+	// Handlers pass the gin context, which exposes the user set by AuthMiddleware
+	if id, ok := ctx.Value("user_id").(uint); ok && id != 0 {
+		return &id
+	}
+	return nil
+}
+
+func revisionState(entity interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for field := range revisionIgnoredFields {
+		delete(state, field)
+	}
+	return state, nil
+}
+
+// recordRevision stores the field level difference between before and after, which may each be nil
+func recordRevision(tx *gorm.DB, entityType string, entityID uint, action string, before interface{}, after interface{}) error {
+	ctx := tx.Statement.Context
+
+	var beforeState, afterState map[string]interface{}
+	var err error
+	if before != nil {
+		if beforeState, err = revisionState(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if afterState, err = revisionState(after); err != nil {
+			return err
+		}
+	}
+
+	changes := map[string]FieldChange{}
+	for field, value := range afterState {
+		if previous, ok := beforeState[field]; !ok || !reflect.DeepEqual(previous, value) {
+			changes[field] = FieldChange{From: beforeState[field], To: value}
+		}
+	}
+	if override, ok := ctx.Value(revisionActionKey{}).(string); ok && action == "update" {
+		action = override
+	} else if action == "update" && len(changes) == 0 {
+		return nil
+	}
+
+	snapshot := afterState
+	if snapshot == nil {
+		snapshot = beforeState
+	}
+
+	revision := Revision{EntityType: entityType, EntityID: entityID, Action: action, ActorID: actorFromContext(ctx)}
+	if revision.Changes, err = json.Marshal(changes); err != nil {
+		return err
+	}
+	if revision.Snapshot, err = json.Marshal(snapshot); err != nil {
+		return err
+	}
+
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&revision).Error
+}
+
+// loadPrevious fetches the stored state of an entity about to be updated
+func loadPrevious(tx *gorm.DB, dest interface{}, id uint) error {
+	if id == 0 {
+		return nil
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Unscoped().First(dest, id).Error
+}
@@ -1,13 +1,20 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// ShoppingCart belongs to a customer, who has at most one, or to an anonymous visitor who holds its UUID
+// in a cookie or the X-Cart-ID header. Anonymous carts expire at ExpiresAt.
 type ShoppingCart struct {
 	UUID      uuid.UUID  `gorm:"type:uuid;primaryKey;index"`
-	UserID    uint       `gorm:"not null"`
-	User      User       `gorm:"foreignKey:UserID"`
+	UserID    *uint      `gorm:"uniqueIndex"`
+	User      *User      `gorm:"foreignKey:UserID" json:"-"`
+	ExpiresAt *time.Time `gorm:"index"` // Nil for the carts of customers
 	CartItems []CartItem `gorm:"foreignKey:CartID"`
+	CreatedAt time.Time  `gorm:"not null;default:now()"`
+	UpdatedAt time.Time  `gorm:"not null;default:now()"` // Last change of the cart or its items
 	// CartItems []CartItem `gorm:"foreignKey:CartID"`
 }
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"backend/utils"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SlugRedirect points a slug an entity used to have to the entity, so old links keep working
+type SlugRedirect struct {
+	ID         uint      `gorm:"primaryKey"`
+	EntityType string    `gorm:"size:50;not null;uniqueIndex:idx_slug_redirects_old_slug"`
+	OldSlug    string    `gorm:"size:200;not null;uniqueIndex:idx_slug_redirects_old_slug"`
+	EntityID   uint      `gorm:"not null;index"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// reservedSlugs are path segments already taken by static routes next to /:id
+var reservedSlugs = map[string][]string{
+	"product":  {"search", "import", "export", "new-arrival", "trending", "images", "recently-viewed", "for-you", "compare"},
+	"category": {"all", "sub-category", "reorder"},
+}
+
+var ErrInvalidSlug = errors.New("slug may only contain lowercase letters, digits and hyphens")
+
+// uniqueSlug returns base, or base with a numeric suffix, that is not used by another row or redirect
+func uniqueSlug(tx *gorm.DB, table string, entityType string, base string, id uint) (string, error) {
+	if base == "" {
+		base = entityType
+	} else if _, err := strconv.Atoi(base); err == nil {
+		// Numeric slugs would be mistaken for IDs
+		base = entityType + "-" + base
+	}
+
+	db := tx.Session(&gorm.Session{NewDB: true})
+	for i := 1; ; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = base + "-" + strconv.Itoa(i)
+		}
+
+		var taken int64
+		for _, reserved := range reservedSlugs[entityType] {
+			if candidate == reserved {
+				taken = 1
+			}
+		}
+		if taken == 0 {
+			if err := db.Table(table).Where("slug = ? AND id <> ?", candidate, id).Count(&taken).Error; err != nil {
+				return "", err
+			}
+		}
+		if taken == 0 {
+			if err := db.Model(&SlugRedirect{}).Where("entity_type = ? AND old_slug = ? AND entity_id <> ?", entityType, candidate, id).Count(&taken).Error; err != nil {
+				return "", err
+			}
+		}
+		if taken == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// FindSlugRedirect returns the current slug of the entity that used oldSlug before
+func FindSlugRedirect(db *gorm.DB, entityType string, table string, oldSlug string) (string, error) {
+	var slug string
+	err := db.Table(table).
+		Select(table+".slug").
+		Joins("JOIN slug_redirects ON slug_redirects.entity_id = "+table+".id").
+		Where("slug_redirects.entity_type = ? AND slug_redirects.old_slug = ? AND "+table+".deleted_at IS NULL", entityType, oldSlug).
+		Row().Scan(&slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", gorm.ErrRecordNotFound
+	}
+	return slug, err
+}
+
+// validateSlugChange checks a slug set by an admin, which must be in slug form and unused
+func validateSlugChange(tx *gorm.DB, table string, entityType string, slug string, id uint) error {
+	if slug == "" || utils.Slugify(slug) != slug {
+		return ErrInvalidSlug
+	}
+
+	available, err := uniqueSlug(tx, table, entityType, slug, id)
+	if err != nil {
+		return err
+	}
+	if available != slug {
+		return errors.New("slug '" + slug + "' is already in use")
+	}
+	return nil
+}
+
+// recordSlugRedirect keeps the previous slug of an entity pointing at it
+func recordSlugRedirect(tx *gorm.DB, entityType string, entityID uint, oldSlug *string, newSlug *string) error {
+	if oldSlug == nil || *oldSlug == "" || (newSlug != nil && *newSlug == *oldSlug) {
+		return nil
+	}
+
+	db := tx.Session(&gorm.Session{NewDB: true})
+	// The new slug may have been a redirect of this entity before
+	if newSlug != nil {
+		if err := db.Where("entity_type = ? AND old_slug = ?", entityType, *newSlug).Delete(&SlugRedirect{}).Error; err != nil {
+			return err
+		}
+	}
+	return db.Create(&SlugRedirect{EntityType: entityType, OldSlug: *oldSlug, EntityID: entityID}).Error
+}
+
+// BackfillSlugs generates slugs for products and categories created before slugs existed
+func BackfillSlugs(db *gorm.DB) error {
+	var products []*Product
+	if err := db.Unscoped().Where("slug IS NULL AND is_child = false").Find(&products).Error; err != nil {
+		return err
+	}
+	for _, product := range products {
+		slug, err := uniqueSlug(db, "products", "product", utils.Slugify(product.Name), product.ID)
+		if err != nil {
+			return err
+		}
+		if err := db.Unscoped().Model(&Product{}).Where("id = ?", product.ID).UpdateColumn("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+
+	var categories []*Category
+	if err := db.Unscoped().Where("slug IS NULL").Find(&categories).Error; err != nil {
+		return err
+	}
+	for _, category := range categories {
+		slug, err := uniqueSlug(db, "categories", "category", utils.Slugify(category.Name.String), category.ID)
+		if err != nil {
+			return err
+		}
+		if err := db.Unscoped().Model(&Category{}).Where("id = ?", category.ID).UpdateColumn("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
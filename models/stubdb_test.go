@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stubQuery answers a query run against a stub database with the columns and rows of its result
+type stubQuery func(query string, args []driver.NamedValue) (columns []string, rows [][]driver.Value, err error)
+
+// openStubDB opens a Postgres flavoured gorm DB whose queries are all answered by answer, for tests of
+// code that reads the database without a server
+func openStubDB(t *testing.T, answer stubQuery) *gorm.DB {
+	t.Helper()
+	sqlDB := sql.OpenDB(stubConnector{answer})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type stubConnector struct{ answer stubQuery }
+
+func (c stubConnector) Connect(context.Context) (driver.Conn, error) { return stubConn(c), nil }
+func (c stubConnector) Driver() driver.Driver                        { return nil }
+
+type stubConn struct{ answer stubQuery }
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("the stub database does not prepare statements")
+}
+func (c stubConn) Close() error              { return nil }
+func (c stubConn) Begin() (driver.Tx, error) { return stubTx{}, nil }
+
+func (c stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &stubRows{columns: columns, rows: rows}, nil
+}
+
+func (c stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, _, err := c.answer(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,439 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrUnknownTrashEntity = errors.New("unknown trash entity, use one of products, categories, reviews, users or coupons")
+
+// TrashConflict explains why a trashed record cannot be restored or purged in the current state of the data
+type TrashConflict struct {
+	Reason string
+}
+
+func (e *TrashConflict) Error() string {
+	return e.Reason
+}
+
+func trashConflict(format string, args ...interface{}) error {
+	return &TrashConflict{Reason: fmt.Sprintf(format, args...)}
+}
+
+// trashEntity describes how soft deleted records of a model are listed, restored and purged
+type trashEntity struct {
+	model   func() interface{} // Pointer to a single record
+	records func() interface{} // Pointer to a slice of records
+	list    func(db *gorm.DB) *gorm.DB
+	restore func(tx *gorm.DB, record interface{}) error            // Returns a TrashConflict when the record cannot come back
+	purge   func(tx *gorm.DB, id uint) (files []string, err error) // Removes dependent rows, returns stored files to delete
+}
+
+// TrashEntities lists the entities with a trash, in the order expired records are purged so that
+// references are removed before what they point to
+var TrashEntities = []string{"reviews", "products", "coupons", "users", "categories"}
+
+var trashEntities = map[string]trashEntity{
+	"products": {
+		model:   func() interface{} { return &Product{} },
+		records: func() interface{} { return &[]*Product{} },
+		list:    func(db *gorm.DB) *gorm.DB { return db.Preload("Images") },
+		restore: restoreProduct,
+		purge:   purgeProduct,
+	},
+	"categories": {
+		model:   func() interface{} { return &Category{} },
+		records: func() interface{} { return &[]*Category{} },
+		list:    func(db *gorm.DB) *gorm.DB { return db.Preload("Image") },
+		restore: restoreCategory,
+		purge:   purgeCategory,
+	},
+	"reviews": {
+		model:   func() interface{} { return &Review{} },
+		records: func() interface{} { return &[]*Review{} },
+		list:    func(db *gorm.DB) *gorm.DB { return db },
+		restore: restoreReview,
+		purge:   func(tx *gorm.DB, id uint) ([]string, error) { return nil, nil },
+	},
+	"users": {
+		model:   func() interface{} { return &User{} },
+		records: func() interface{} { return &[]*User{} },
+		list:    func(db *gorm.DB) *gorm.DB { return db.Omit("password_hash") },
+		restore: restoreUser,
+		purge:   purgeUser,
+	},
+	"coupons": {
+		model:   func() interface{} { return &Coupon{} },
+		records: func() interface{} { return &[]*Coupon{} },
+		list:    func(db *gorm.DB) *gorm.DB { return db },
+		restore: restoreCoupon,
+		purge:   purgeCoupon,
+	},
+}
+
+func lookupTrashEntity(name string) (trashEntity, error) {
+	entity, ok := trashEntities[name]
+	if !ok {
+		return trashEntity{}, ErrUnknownTrashEntity
+	}
+	return entity, nil
+}
+
+// TrashQuery returns the query listing the soft deleted records of an entity, most recently deleted first,
+// and a slice to scan them into
+func TrashQuery(db *gorm.DB, name string) (*gorm.DB, interface{}, error) {
+	entity, err := lookupTrashEntity(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := entity.list(db.Unscoped().Model(entity.model())).
+		Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC, id DESC")
+	return query, entity.records(), nil
+}
+
+// findTrashed loads a soft deleted record, gorm.ErrRecordNotFound if it does not exist or is not in the trash
+func findTrashed(tx *gorm.DB, entity trashEntity, id uint) (interface{}, error) {
+	record := entity.model()
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(record, id).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// RestoreFromTrash undeletes a record after checking it does not clash with the records that replaced it.
+// Use a context from WithRevisionAction(ctx, "restore") to record the restore in the catalogue history.
+func RestoreFromTrash(db *gorm.DB, name string, id uint) (interface{}, error) {
+	entity, err := lookupTrashEntity(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var record interface{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if record, err = findTrashed(tx, entity, id); err != nil {
+			return err
+		}
+		if err := entity.restore(tx, record); err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(record).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user, ok := record.(*User); ok {
+		user.PasswordHash = nil
+	}
+	return record, nil
+}
+
+// PurgeFromTrash permanently deletes a trashed record and the rows that only exist for it. Stored files
+// are removed by the caller once the transaction has committed.
+func PurgeFromTrash(db *gorm.DB, name string, id uint) (files []string, err error) {
+	entity, err := lookupTrashEntity(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if _, err := findTrashed(tx, entity, id); err != nil {
+			return err
+		}
+		if files, err = entity.purge(tx, id); err != nil {
+			return err
+		}
+		// A zero valued model skips the AfterDelete revision, the soft delete was already recorded
+		return tx.Unscoped().Delete(entity.model(), id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ExpiredTrashIDs returns the IDs of records of an entity that were deleted more than the retention period ago
+func ExpiredTrashIDs(db *gorm.DB, name string, deletedBefore time.Time) ([]uint, error) {
+	entity, err := lookupTrashEntity(name)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	err = db.Unscoped().Model(entity.model()).Where("deleted_at < ?", deletedBefore).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// exists reports whether a query matches any row
+func exists(query *gorm.DB) (bool, error) {
+	var count int64
+	err := query.Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+func restoreProduct(tx *gorm.DB, record interface{}) error {
+	product := record.(*Product)
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	taken, err := exists(db.Model(&Product{}).Where("sku = ? AND id <> ?", product.SKU, product.ID))
+	if err != nil {
+		return err
+	}
+	if taken {
+		return trashConflict("SKU %s is used by another product, change it before restoring", product.SKU)
+	}
+
+	active, err := exists(db.Model(&Category{}).Where("id = ?", product.CategoryID))
+	if err != nil {
+		return err
+	}
+	if !active {
+		return trashConflict("category %d is in the trash, restore it first", product.CategoryID)
+	}
+
+	if product.ParentID != nil {
+		active, err := exists(db.Model(&Product{}).Where("id = ?", *product.ParentID))
+		if err != nil {
+			return err
+		}
+		if !active {
+			return trashConflict("parent product %d is in the trash, restore it first", *product.ParentID)
+		}
+	}
+	return nil
+}
+
+func restoreCategory(tx *gorm.DB, record interface{}) error {
+	category := record.(*Category)
+	if category.ParentID == nil {
+		return nil
+	}
+
+	active, err := exists(tx.Session(&gorm.Session{NewDB: true}).Model(&Category{}).Where("id = ?", *category.ParentID))
+	if err != nil {
+		return err
+	}
+	if !active {
+		return trashConflict("parent category %d is in the trash, restore it first", *category.ParentID)
+	}
+	return nil
+}
+
+func restoreReview(tx *gorm.DB, record interface{}) error {
+	review := record.(*Review)
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	active, err := exists(db.Model(&Product{}).Where("id = ?", review.ProductID))
+	if err != nil {
+		return err
+	}
+	if !active {
+		return trashConflict("product %d is in the trash, restore it first", review.ProductID)
+	}
+
+	active, err = exists(db.Model(&User{}).Where("id = ?", review.UserID))
+	if err != nil {
+		return err
+	}
+	if !active {
+		return trashConflict("user %d is in the trash, restore them first", review.UserID)
+	}
+	return nil
+}
+
+func restoreUser(tx *gorm.DB, record interface{}) error {
+	user := record.(*User)
+
+	taken, err := exists(tx.Session(&gorm.Session{NewDB: true}).Model(&User{}).Where("email = ? AND id <> ?", user.Email, user.ID))
+	if err != nil {
+		return err
+	}
+	if taken {
+		return trashConflict("email %s is used by another account", user.Email)
+	}
+	return nil
+}
+
+func restoreCoupon(tx *gorm.DB, record interface{}) error {
+	coupon := record.(*Coupon)
+
+	taken, err := exists(tx.Session(&gorm.Session{NewDB: true}).Model(&Coupon{}).Where("code = ? AND id <> ?", coupon.Code, coupon.ID))
+	if err != nil {
+		return err
+	}
+	if taken {
+		return trashConflict("code %s is used by another coupon", coupon.Code)
+	}
+	return nil
+}
+
+// purgeProduct removes a product together with its trashed variations and everything attached to them.
+// Products that were ordered stay in the trash so order history keeps its line items.
+func purgeProduct(tx *gorm.DB, id uint) ([]string, error) {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	activeVariations, err := exists(db.Model(&Product{}).Where("parent_id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if activeVariations {
+		return nil, trashConflict("product has variations that are not in the trash")
+	}
+
+	ids := []uint{id}
+	var variations []uint
+	if err := db.Unscoped().Model(&Product{}).Where("parent_id = ?", id).Pluck("id", &variations).Error; err != nil {
+		return nil, err
+	}
+	ids = append(ids, variations...)
+
+	ordered, err := exists(db.Model(&OrderItem{}).Where("product_id IN ?", ids))
+	if err != nil {
+		return nil, err
+	}
+	if ordered {
+		return nil, trashConflict("product has been ordered and is kept for the order history")
+	}
+
+	var bundles []uint
+	if err := db.Model(&BundleComponent{}).Where("component_id IN ? AND bundle_id NOT IN ?", ids, ids).Pluck("bundle_id", &bundles).Error; err != nil {
+		return nil, err
+	}
+	if len(bundles) > 0 {
+		return nil, trashConflict("product is a component of bundle %d", bundles[0])
+	}
+
+	var images []*ProductImage
+	if err := db.Where("product_id IN ?", ids).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, image := range images {
+		files = append(files, image.StorageKey)
+		files = append(files, image.Renditions.StorageKeys()...)
+	}
+
+	dependents := []struct {
+		model interface{}
+		where string
+	}{
+		{&ProductImage{}, "product_id IN @ids"},
+		{&Inventory{}, "product_id IN @ids"},
+		{&ProductAttribute{}, "product_id IN @ids"},
+		{&ProductLink{}, "product_id IN @ids OR linked_product_id IN @ids"},
+		{&ProductAffinity{}, "product_id IN @ids OR related_product_id IN @ids"},
+		{&ProductView{}, "product_id IN @ids"},
+		{&BundleComponent{}, "bundle_id IN @ids"},
+		{&WishList{}, "product_id IN @ids"},
+		{&ProductAlert{}, "product_id IN @ids"},
+		{&CartItem{}, "product_id IN @ids"},
+		{&Review{}, "product_id IN @ids"},
+		{&SlugRedirect{}, "entity_type = 'product' AND entity_id IN @ids"},
+	}
+	for _, dependent := range dependents {
+		if err := db.Unscoped().Where(dependent.where, sql.Named("ids", ids)).Delete(dependent.model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if len(variations) > 0 {
+		if err := db.Unscoped().Delete(&Product{}, variations).Error; err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// purgeCategory removes a category once nothing is filed under it anymore, trashed products and
+// subcategories included
+func purgeCategory(tx *gorm.DB, id uint) ([]string, error) {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	hasProducts, err := exists(db.Unscoped().Model(&Product{}).Where("category_id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	hasChildren, err := exists(db.Unscoped().Model(&Category{}).Where("parent_id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if hasProducts || hasChildren {
+		return nil, trashConflict("category still has products or subcategories, purge or move them first")
+	}
+
+	definitions := db.Model(&AttributeDefinition{}).Select("id").Where("category_id = ?", id)
+	if err := db.Unscoped().Model(&ProductAttribute{}).Where("definition_id IN (?)", definitions).UpdateColumn("definition_id", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("category_id = ?", id).Delete(&AttributeDefinition{}).Error; err != nil {
+		return nil, err
+	}
+
+	var files []string
+	var image CategoryImage
+	if err := db.Where("category_id = ?", id).Limit(1).Find(&image).Error; err != nil {
+		return nil, err
+	}
+	if image.ID != 0 {
+		files = append(image.Renditions.StorageKeys(), image.StorageKey)
+		if err := db.Delete(&image).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.Where("entity_type = 'category' AND entity_id = ?", id).Delete(&SlugRedirect{}).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// purgeUser removes an account and its personal data. Customers with orders stay in the trash so
+// the orders keep their customer.
+func purgeUser(tx *gorm.DB, id uint) ([]string, error) {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	ordered, err := exists(db.Unscoped().Model(&Order{}).Where("user_id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if ordered {
+		return nil, trashConflict("user has orders and is kept for the order history")
+	}
+
+	carts := db.Model(&ShoppingCart{}).Select("uuid").Where("user_id = ?", id)
+	if err := db.Where("cart_id IN (?)", carts).Delete(&CartItem{}).Error; err != nil {
+		return nil, err
+	}
+
+	for _, model := range []interface{}{&ShoppingCart{}, &WishList{}, &Review{}, &ShippingAddress{}, &CouponUsageHistory{}, &ProductView{}, &ProductImportJob{}} {
+		if err := db.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, model := range []interface{}{&StoreCreditTransaction{}, &LoyaltyTransaction{}, &UserDevice{}, &CartRecovery{}, &ProductAlert{}} {
+		if err := db.Where("user_id = ?", id).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// History stays, it just no longer names who made the change
+	if err := db.Model(&Revision{}).Where("actor_id = ?", id).UpdateColumn("actor_id", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&StoreCreditTransaction{}).Where("issued_by_id = ?", id).UpdateColumn("issued_by_id", nil).Error; err != nil {
+		return nil, err
+	}
+	// Referrals the customer made or was referred by go with them
+	if err := db.Where("referee_id = ? OR referrer_id = ?", id, id).Delete(&Referral{}).Error; err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func purgeCoupon(tx *gorm.DB, id uint) ([]string, error) {
+	return nil, tx.Session(&gorm.Session{NewDB: true}).Where("coupon_id = ?", id).Delete(&CouponUsageHistory{}).Error
+}
@@ -1,15 +1,21 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Name         string  `gorm:"size:100;not null"`
-	Email        string  `gorm:"size:100;unique;not null"`
-	Address      *string `gorm:"type:text"`
-	PasswordHash *string `gorm:"size:255;not null" json:"password"`
-	PhoneNumber  *string `gorm:"size:15"`
-	Role         string  `gorm:"size:20;default:'customer';not null"`
+	Name         string     `gorm:"size:100;not null"`
+	Email        string     `gorm:"size:100;not null;uniqueIndex:idx_users_email_active,where:deleted_at IS NULL"`
+	Address      *string    `gorm:"type:text"`
+	PasswordHash *string    `gorm:"size:255;not null" json:"password"`
+	PhoneNumber  *string    `gorm:"size:15"`
+	Role         string     `gorm:"size:20;default:'customer';not null"`
+	Birthday     *time.Time `gorm:"type:date"`                    // For the loyalty birthday bonus
+	ReferralCode *string    `gorm:"size:20;uniqueIndex" json:"-"` // Generated the first time it is needed
+	// Set once the customer proved the email is theirs, which attaches the guest orders placed with it
+	EmailVerifiedAt *time.Time `json:"-"`
 }
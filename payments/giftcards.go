@@ -0,0 +1,205 @@
+package payments
+
+import (
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GiftCardCodePattern is the pattern of generated gift card codes, X is a letter or digit
+const GiftCardCodePattern = "XXXX-XXXX-XXXX-XXXX"
+
+var ErrGiftCardNotFound = errors.New("gift card not found")
+
+// TenderError is a gift card or store credit that cannot pay for an order, with the reason shown to the customer
+type TenderError struct {
+	Reason string
+}
+
+func (e *TenderError) Error() string {
+	return e.Reason
+}
+
+// giftCardExpiry is the expiry of cards issued at the given time after GIFT_CARD_VALIDITY_DAYS, cards
+// don't expire when it is not set
+func giftCardExpiry(from time.Time) *time.Time {
+	days, err := strconv.Atoi(os.Getenv("GIFT_CARD_VALIDITY_DAYS"))
+	if err != nil || days <= 0 {
+		return nil
+	}
+	expiry := from.AddDate(0, 0, days)
+	return &expiry
+}
+
+// IssueGiftCard creates a card with its initial balance, generating a code when it has none
+func IssueGiftCard(tx *gorm.DB, card *models.GiftCard) error {
+	card.InitialBalance = round(card.InitialBalance)
+	if card.InitialBalance <= 0 {
+		return errors.New("initial balance must be positive")
+	}
+	card.Currency = strings.ToUpper(card.Currency)
+	if len(card.Currency) != 3 {
+		return errors.New("currency must be a three letter code")
+	}
+
+	card.Code = strings.ToUpper(strings.TrimSpace(card.Code))
+	for attempt := 0; card.Code == ""; attempt++ {
+		if attempt == 5 {
+			return errors.New("could not generate a unique gift card code")
+		}
+		code, err := utils.GenerateCode("", GiftCardCodePattern)
+		if err != nil {
+			return err
+		}
+		var taken int64
+		if err := tx.Model(&models.GiftCard{}).Where("code = ?", code).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken == 0 {
+			card.Code = code
+		}
+	}
+
+	card.ID = 0
+	card.Balance = card.InitialBalance
+	card.IsActive = true
+	card.Transactions = []models.GiftCardTransaction{{Kind: "issue", Amount: card.InitialBalance, Balance: card.Balance, Note: card.Note}}
+	return tx.Create(card).Error
+}
+
+// lockGiftCard loads a card for update, by its ID or code
+func lockGiftCard(tx *gorm.DB, query string, arg interface{}) (*models.GiftCard, error) {
+	var card models.GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, arg).First(&card).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGiftCardNotFound
+		}
+		return nil, err
+	}
+	return &card, nil
+}
+
+// changeGiftCard adds amount to the balance of a locked card, negative amounts take it off, and records
+// the transaction
+func changeGiftCard(tx *gorm.DB, card *models.GiftCard, kind string, amount float64, orderID *uint, note string) error {
+	balance := round(card.Balance + amount)
+	if balance < 0 {
+		return &TenderError{Reason: "gift card " + card.Code + " does not have enough balance"}
+	}
+	if err := tx.Model(card).Update("balance", balance).Error; err != nil {
+		return err
+	}
+	card.Balance = balance
+	return tx.Create(&models.GiftCardTransaction{GiftCardID: card.ID, Kind: kind, Amount: round(amount), Balance: balance, OrderID: orderID, Note: note}).Error
+}
+
+// AdjustGiftCard corrects the balance of a card by amount, which is negative to take off the balance
+func AdjustGiftCard(tx *gorm.DB, id uint, amount float64, note string) (*models.GiftCard, error) {
+	card, err := lockGiftCard(tx, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := changeGiftCard(tx, card, "adjust", amount, nil, note); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// IssueOrderGiftCards issues a card for every gift card bought on an order, worth what was paid for it
+// after the discounts on its line. Items that already have their cards are skipped, so it can run again.
+func IssueOrderGiftCards(tx *gorm.DB, orderID uint) ([]models.GiftCard, error) {
+	var order models.Order
+	if err := tx.Select("id", "order_identifier", "user_id", "currency").First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+
+	var items []struct {
+		ID              uint
+		Quantity        int
+		PriceAtPurchase float64
+		DiscountAmount  float64
+		Currency        string
+	}
+	if err := tx.Table("order_items").
+		Select("order_items.id, order_items.quantity, order_items.price_at_purchase, order_items.discount_amount, products.currency").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND products.product_type = 'gift_card'", orderID).
+		Where("NOT EXISTS (SELECT 1 FROM gift_cards WHERE gift_cards.order_item_id = order_items.id)").
+		Scan(&items).Error; err != nil {
+		return nil, err
+	}
+
+	var cards []models.GiftCard
+	now := time.Now()
+	for _, item := range items {
+		currency := item.Currency
+		if order.Currency != nil && *order.Currency != "" {
+			currency = *order.Currency
+		}
+		if item.Quantity <= 0 {
+			continue
+		}
+		// The discount is spread over the cards of the line, the last one takes the rounding difference
+		paid := round(item.PriceAtPurchase*float64(item.Quantity) - item.DiscountAmount)
+		each := round(paid / float64(item.Quantity))
+		for n := 0; n < item.Quantity; n++ {
+			balance := each
+			if n == item.Quantity-1 {
+				balance = round(paid - each*float64(item.Quantity-1))
+			}
+			if balance <= 0 {
+				continue
+			}
+			card := models.GiftCard{
+				InitialBalance: balance,
+				Currency:       currency,
+				ExpiresAt:      giftCardExpiry(now),
+				PurchaserID:    order.UserID,
+				OrderItemID:    &item.ID,
+				Note:           "Order " + order.OrderIdentifier,
+			}
+			if err := IssueGiftCard(tx, &card); err != nil {
+				return nil, err
+			}
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+// VoidOrderGiftCards takes the unused balance off the cards issued for a fully refunded order and
+// deactivates them. What was already spent stays spent.
+func VoidOrderGiftCards(tx *gorm.DB, orderID uint) error {
+	var cards []models.GiftCard
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_item_id IN (?)", tx.Model(&models.OrderItem{}).Select("id").Where("order_id = ?", orderID)).
+		Where("is_active = ?", true).
+		Find(&cards).Error; err != nil {
+		return err
+	}
+
+	for i := range cards {
+		card := &cards[i]
+		if card.Balance > 0 {
+			if err := changeGiftCard(tx, card, "adjust", -card.Balance, &orderID, "Order refunded"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(card).Update("is_active", false).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
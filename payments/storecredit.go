@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"backend/models"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoreCreditBalance returns the store credit a customer has left
+func StoreCreditBalance(db *gorm.DB, userID uint) (float64, error) {
+	var last models.StoreCreditTransaction
+	err := db.Session(&gorm.Session{NewDB: true}).Where("user_id = ?", userID).Order("id DESC").Take(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return last.Balance, err
+}
+
+// ChangeStoreCredit records a change of a customer's store credit, a negative Amount takes it off. The
+// customer is locked so concurrent changes keep the running balance right.
+func ChangeStoreCredit(tx *gorm.DB, entry *models.StoreCreditTransaction) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, entry.UserID).Error; err != nil {
+		return err
+	}
+	balance, err := StoreCreditBalance(tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	entry.ID = 0
+	entry.Amount = round(entry.Amount)
+	entry.Balance = round(balance + entry.Amount)
+	if entry.Balance < 0 {
+		return &TenderError{Reason: "not enough store credit"}
+	}
+	return tx.Create(entry).Error
+}
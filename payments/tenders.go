@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ApplyTenders pays what it can of a saved order with its gift cards, in the order they were given, then
+// with up to its StoreCredit. It records a completed payment for each and returns what is left for the
+// payment method of the order. It runs in the order transaction.
+func ApplyTenders(tx *gorm.DB, order *models.Order, at time.Time) (float64, error) {
+	remaining := round(order.TotalPrice)
+
+	var codes []string
+	seen := map[string]bool{}
+	for _, code := range order.GiftCards {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	if order.StoreCredit < 0 {
+		return 0, &TenderError{Reason: "store credit cannot be negative"}
+	}
+	if len(codes) == 0 && order.StoreCredit == 0 {
+		return remaining, nil
+	}
+
+	// Balances held with the shop cannot be turned into new gift cards
+	productIDs := make([]uint, len(order.OrderItems))
+	for i, item := range order.OrderItems {
+		productIDs[i] = item.ProductID
+	}
+	var giftCards int64
+	if err := tx.Model(&models.Product{}).Where("id IN ? AND product_type = 'gift_card'", productIDs).Count(&giftCards).Error; err != nil {
+		return 0, err
+	}
+	if giftCards > 0 {
+		return 0, &TenderError{Reason: "gift cards cannot be paid with gift cards or store credit"}
+	}
+
+	pay := func(method string, amount float64, giftCardID *uint) error {
+		remaining = round(remaining - amount)
+		return tx.Create(&models.Payment{
+			PaymentMethod:  method,
+			PaymentStatus:  "completed",
+			Amount:         amount,
+			TransanctionID: toPtr(utils.GenerateTransactionID()),
+			PaymentDate:    &at,
+			OrderID:        order.ID,
+			GiftCardID:     giftCardID,
+		}).Error
+	}
+
+	for _, code := range codes {
+		card, err := lockGiftCard(tx, "code = ?", code)
+		if errors.Is(err, ErrGiftCardNotFound) {
+			return 0, &TenderError{Reason: "gift card " + code + " not found"}
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := card.Usable(at); err != nil {
+			return 0, &TenderError{Reason: code + ": " + err.Error()}
+		}
+		if order.Currency != nil && *order.Currency != "" && !strings.EqualFold(*order.Currency, card.Currency) {
+			return 0, &TenderError{Reason: "gift card " + code + " is in " + card.Currency}
+		}
+		if remaining <= 0 {
+			return 0, &TenderError{Reason: "the order is already paid without gift card " + code}
+		}
+
+		amount := math.Min(card.Balance, remaining)
+		if err := changeGiftCard(tx, card, "redeem", -amount, &order.ID, ""); err != nil {
+			return 0, err
+		}
+		if err := pay("gift_card", amount, &card.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	if order.StoreCredit > 0 && remaining > 0 {
+		if order.UserID == nil {
+			return 0, &TenderError{Reason: "sign in to pay with store credit"}
+		}
+		amount := math.Min(round(order.StoreCredit), remaining)
+		entry := models.StoreCreditTransaction{UserID: *order.UserID, Kind: "redeem", Amount: -amount, OrderID: &order.ID}
+		if err := ChangeStoreCredit(tx, &entry); err != nil {
+			return 0, err
+		}
+		if err := pay("store_credit", amount, nil); err != nil {
+			return 0, err
+		}
+	}
+	return remaining, nil
+}
+
+// ReverseTenders gives the gift card and store credit payments of a cancelled order back to the cards and
+// the customer, and marks them refunded
+func ReverseTenders(tx *gorm.DB, orderID uint) error {
+	var order models.Order
+	if err := tx.Select("id", "user_id").First(&order, orderID).Error; err != nil {
+		return err
+	}
+
+	var tenders []models.Payment
+	if err := tx.Where("order_id = ? AND payment_status = 'completed' AND payment_method IN ?", orderID, models.TenderMethods).Find(&tenders).Error; err != nil {
+		return err
+	}
+	for _, payment := range tenders {
+		if payment.PaymentMethod == "gift_card" && payment.GiftCardID != nil {
+			card, err := lockGiftCard(tx, "id = ?", *payment.GiftCardID)
+			if err != nil {
+				return err
+			}
+			if err := changeGiftCard(tx, card, "refund", payment.Amount, &orderID, "order cancelled"); err != nil {
+				return err
+			}
+		} else if order.UserID != nil {
+			entry := models.StoreCreditTransaction{UserID: *order.UserID, Kind: "reversal", Amount: payment.Amount, OrderID: &orderID, Reason: "order cancelled"}
+			if err := ChangeStoreCredit(tx, &entry); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&payment).Update("payment_status", "refunded").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toPtr[T any](value T) *T {
+	return &value
+}
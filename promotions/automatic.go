@@ -0,0 +1,86 @@
+package promotions
+
+import (
+	"backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// activePromotions loads the automatic promotions running at the given time with their scopes and
+// tiers, highest priority first
+func activePromotions(db *gorm.DB, at time.Time) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	err := db.Session(&gorm.Session{NewDB: true}).Preload("Scopes").Preload("Tiers").
+		Where("is_active = true AND start_date <= ? AND (expiration_date IS NULL OR expiration_date > ?)", at, at).
+		Order("priority DESC, id").
+		Find(&promotions).Error
+	return promotions, err
+}
+
+// promotionLimitReached tells whether the customer got the promotion on as many orders as it allows.
+// Cancelled orders and the order being placed, when given, are not counted.
+func promotionLimitReached(db *gorm.DB, promotion *models.Promotion, customer *customer, orderID uint) (bool, error) {
+	ordered, arg := customer.orders()
+	if promotion.UsageLimitPerUser == nil || ordered == "" {
+		return false, nil
+	}
+
+	var count int64
+	if err := db.Session(&gorm.Session{NewDB: true}).Model(&models.OrderDiscount{}).
+		Joins("JOIN orders ON orders.id = order_discounts.order_id AND orders.deleted_at IS NULL").
+		Where(ordered, arg).
+		Where("order_discounts.promotion_id = ? AND orders.order_status <> 'cancelled' AND orders.id <> ?", promotion.ID, orderID).
+		Distinct("order_discounts.order_id").
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count >= int64(*promotion.UsageLimitPerUser), nil
+}
+
+// ListingBadges returns the badges of the automatic promotions running on each of the products. Only
+// promotions open to every customer are shown, cart conditions like a minimum spend are not checked.
+func ListingBadges(db *gorm.DB, productIDs []uint, at time.Time) (map[uint][]string, error) {
+	badges := map[uint][]string{}
+	if len(productIDs) == 0 {
+		return badges, nil
+	}
+
+	promotions, err := activePromotions(db, at)
+	if err != nil || len(promotions) == 0 {
+		return badges, err
+	}
+	products, err := productLines(db, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]Line, len(productIDs))
+	for i, id := range productIDs {
+		lines[i] = products[id]
+		lines[i].ProductID = id
+	}
+
+	for i := range promotions {
+		promotion := &promotions[i]
+		if promotion.FirstOrderOnly || hasSegmentScope(promotion.Scopes) {
+			continue
+		}
+		eligible, err := eligibleLines(db, promotion.Coupon(), lines)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range eligible {
+			badges[productIDs[line]] = append(badges[productIDs[line]], promotion.BadgeText())
+		}
+	}
+	return badges, nil
+}
+
+func hasSegmentScope(scopes []models.CouponScope) bool {
+	for _, scope := range scopes {
+		if scope.Kind == "segment" && !scope.Exclude {
+			return true
+		}
+	}
+	return false
+}
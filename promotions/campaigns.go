@@ -0,0 +1,158 @@
+package promotions
+
+import (
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxGeneratedCodes is the most codes one generation request may create
+const MaxGeneratedCodes = 50000
+
+// DefaultCodePattern is used when a generation request has no pattern
+const DefaultCodePattern = "XXXX-XXXX"
+
+const codeBatchSize = 500
+
+var ErrCodeSpaceTooSmall = errors.New("pattern cannot produce enough unique codes, add more placeholders")
+
+// GenerateCampaignCodes creates count unique codes for a campaign made of the prefix and the pattern.
+// Codes never clash with each other or with coupon codes, either all of them are created or none.
+func GenerateCampaignCodes(db *gorm.DB, campaignID uint, count int, prefix string, pattern string) error {
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	if pattern == "" {
+		pattern = DefaultCodePattern
+	}
+	if err := utils.ValidateCodePattern(pattern); err != nil {
+		return err
+	}
+	if len(prefix)+len(pattern) > 50 {
+		return errors.New("prefix and pattern together may be at most 50 characters")
+	}
+	// Leave plenty of room so random codes rarely collide
+	if utils.CodePatternSpace(pattern, int64(count)*100) < int64(count)*100 {
+		return ErrCodeSpaceTooSmall
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		created := 0
+		for attempts := 0; created < count; attempts++ {
+			if attempts > count/codeBatchSize+20 {
+				return ErrCodeSpaceTooSmall
+			}
+
+			batch := map[string]bool{}
+			for len(batch) < min(codeBatchSize, count-created) {
+				code, err := utils.GenerateCode(prefix, pattern)
+				if err != nil {
+					return err
+				}
+				batch[code] = true
+			}
+
+			candidates := make([]string, 0, len(batch))
+			for code := range batch {
+				candidates = append(candidates, code)
+			}
+			var taken []string
+			if err := tx.Unscoped().Model(&models.Coupon{}).Where("code IN ?", candidates).Pluck("code", &taken).Error; err != nil {
+				return err
+			}
+			for _, code := range taken {
+				delete(batch, code)
+			}
+
+			rows := make([]models.CampaignCode, 0, len(batch))
+			for code := range batch {
+				rows = append(rows, models.CampaignCode{CampaignID: campaignID, Code: code})
+			}
+			if len(rows) == 0 {
+				continue
+			}
+			// Codes already generated for any campaign are skipped and made up for in the next batch
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows)
+			if result.Error != nil {
+				return result.Error
+			}
+			created += int(result.RowsAffected)
+		}
+		return nil
+	})
+}
+
+// IssueCampaignCode creates one code of a campaign that only the given customer can redeem
+func IssueCampaignCode(tx *gorm.DB, campaignID uint, userID uint) (*models.CampaignCode, error) {
+	for attempts := 0; attempts < 10; attempts++ {
+		code, err := utils.GenerateCode("", DefaultCodePattern)
+		if err != nil {
+			return nil, err
+		}
+		var taken int64
+		if err := tx.Unscoped().Model(&models.Coupon{}).Where("code = ?", code).Count(&taken).Error; err != nil {
+			return nil, err
+		}
+		if taken > 0 {
+			continue
+		}
+
+		issued := models.CampaignCode{CampaignID: campaignID, Code: code, ReservedForID: &userID}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&issued)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return &issued, nil
+		}
+	}
+	return nil, ErrCodeSpaceTooSmall
+}
+
+// CampaignStats summarises the redemptions of a campaign. Revenue and discounts only count orders that
+// were not cancelled.
+type CampaignStats struct {
+	Codes           int64
+	Redeemed        int64
+	Revoked         int64
+	Orders          int64
+	Revenue         float64 // Total of the orders placed with a code of the campaign
+	DiscountTotal   float64
+	AverageDiscount float64 // Discount per order
+}
+
+// GetCampaignStats works out the redemption statistics of a campaign
+func GetCampaignStats(db *gorm.DB, campaignID uint) (*CampaignStats, error) {
+	stats := &CampaignStats{}
+
+	if err := db.Model(&models.CampaignCode{}).
+		Select("COUNT(*) AS codes, COUNT(redeemed_at) AS redeemed, COUNT(revoked_at) AS revoked").
+		Where("campaign_id = ?", campaignID).
+		Scan(stats).Error; err != nil {
+		return nil, err
+	}
+
+	var orders struct {
+		Orders        int64
+		Revenue       float64
+		DiscountTotal float64
+	}
+	if err := db.Raw(`SELECT COUNT(*) AS orders,
+			COALESCE(SUM(orders.total_price), 0) AS revenue,
+			COALESCE(SUM(discounts.amount), 0) AS discount_total
+		FROM (
+			SELECT order_id, SUM(amount) AS amount FROM order_discounts WHERE campaign_id = ? GROUP BY order_id
+		) AS discounts
+		JOIN orders ON orders.id = discounts.order_id AND orders.deleted_at IS NULL AND orders.order_status <> 'cancelled'`, campaignID).
+		Scan(&orders).Error; err != nil {
+		return nil, err
+	}
+
+	stats.Orders, stats.Revenue, stats.DiscountTotal = orders.Orders, orders.Revenue, orders.DiscountTotal
+	if stats.Orders > 0 {
+		stats.AverageDiscount = round(stats.DiscountTotal / float64(stats.Orders))
+	}
+	return stats, nil
+}
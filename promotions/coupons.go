@@ -0,0 +1,320 @@
+package promotions
+
+import (
+	"backend/loyalty"
+	"backend/models"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// loadCoupons finds the coupons of the codes with their scopes, codes of campaigns stand in as coupons
+// with the rules of their campaign. Codes without either are rejected.
+func loadCoupons(db *gorm.DB, codes []string) ([]*models.Coupon, []Rejection, error) {
+	if len(codes) == 0 {
+		return nil, nil, nil
+	}
+
+	var found []*models.Coupon
+	if err := db.Preload("Scopes").Where("code IN ?", codes).Find(&found).Error; err != nil {
+		return nil, nil, err
+	}
+	byCode := map[string]*models.Coupon{}
+	for _, coupon := range found {
+		byCode[coupon.Code] = coupon
+	}
+
+	var campaignCodes []*models.CampaignCode
+	if err := db.Preload("Campaign.Scopes").Where("code IN ?", codes).Find(&campaignCodes).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, code := range campaignCodes {
+		// Coupons win over campaign codes, which are generated to not clash with them anyway
+		if byCode[code.Code] == nil && code.Campaign.ID != 0 {
+			byCode[code.Code] = code.Campaign.Coupon(code)
+		}
+	}
+
+	var coupons []*models.Coupon
+	var rejected []Rejection
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		if coupon := byCode[code]; coupon != nil {
+			coupons = append(coupons, coupon)
+		} else {
+			rejected = append(rejected, Rejection{Code: code, Reason: "coupon not found"})
+		}
+	}
+	return coupons, rejected, nil
+}
+
+// checkCoupon returns why a coupon cannot be used by the customer at the given time, or "" when it can
+func checkCoupon(db *gorm.DB, coupon *models.Coupon, customer *customer, at time.Time) (string, error) {
+	if code := coupon.CampaignCode; code != nil {
+		if code.RevokedAt != nil {
+			return "code has been revoked", nil
+		}
+		if code.RedeemedAt != nil {
+			return "code has already been used", nil
+		}
+		if code.ReservedForID != nil && (customer.userID == nil || *customer.userID != *code.ReservedForID) {
+			return "code belongs to another account", nil
+		}
+	}
+	if !coupon.IsActive {
+		return "coupon is not active", nil
+	}
+	if at.Before(coupon.StartDate) {
+		return "coupon is not valid yet", nil
+	}
+	if coupon.ExpirationDate != nil && at.After(*coupon.ExpirationDate) {
+		return "coupon has expired", nil
+	}
+
+	if reason, err := usageLimitReason(db, coupon, customer); reason != "" || err != nil {
+		return reason, err
+	}
+
+	needsCustomer := coupon.FirstOrderOnly
+	for _, scope := range coupon.Scopes {
+		needsCustomer = needsCustomer || scope.Kind == "segment"
+	}
+	if customer.userID == nil {
+		if needsCustomer {
+			return "sign in to use this coupon", nil
+		}
+		return "", nil
+	}
+
+	if !needsCustomer {
+		return "", nil
+	}
+	segments, err := customer.segments()
+	if err != nil {
+		return "", err
+	}
+	if coupon.FirstOrderOnly && !segments["new"] {
+		return "coupon is only valid on a first order", nil
+	}
+
+	included, matched := false, false
+	for _, scope := range coupon.Scopes {
+		if scope.Kind != "segment" || scope.Value == nil {
+			continue
+		}
+		if scope.Exclude {
+			if segments[*scope.Value] {
+				return "coupon is not available for your account", nil
+			}
+			continue
+		}
+		included = true
+		matched = matched || segments[*scope.Value]
+	}
+	if included && !matched {
+		return "coupon is not available for your account", nil
+	}
+	return "", nil
+}
+
+// eligibleLines returns the indexes of the lines the product, category and brand scopes of a coupon
+// allow. Without include scopes every line is eligible, exclusions always win.
+func eligibleLines(db *gorm.DB, coupon *models.Coupon, lines []Line) ([]int, error) {
+	type rules struct {
+		products   map[uint]bool
+		categories map[uint]bool
+		brands     map[string]bool
+	}
+	include := rules{map[uint]bool{}, map[uint]bool{}, map[string]bool{}}
+	exclude := rules{map[uint]bool{}, map[uint]bool{}, map[string]bool{}}
+	restricted := false
+
+	for _, scope := range coupon.Scopes {
+		target := &include
+		if scope.Exclude {
+			target = &exclude
+		} else if scope.Kind != "segment" {
+			restricted = true
+		}
+
+		switch {
+		case scope.Kind == "product" && scope.ProductID != nil:
+			target.products[*scope.ProductID] = true
+		case scope.Kind == "category" && scope.CategoryID != nil:
+			ids, err := models.CategoryDescendantIDs(db, *scope.CategoryID)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				target.categories[id] = true
+			}
+		case scope.Kind == "brand" && scope.Value != nil:
+			target.brands[strings.ToLower(*scope.Value)] = true
+		}
+	}
+
+	// A product scope covers the variations of the product too
+	matches := func(r rules, line Line) bool {
+		return r.products[line.ProductID] || (line.ParentID != 0 && r.products[line.ParentID]) ||
+			r.categories[line.CategoryID] || r.brands[strings.ToLower(line.Brand)]
+	}
+
+	var eligible []int
+	for i, line := range lines {
+		if matches(exclude, line) || (restricted && !matches(include, line)) {
+			continue
+		}
+		eligible = append(eligible, i)
+	}
+	return eligible, nil
+}
+
+// customer lazily works out the segments of the customer a cart belongs to. Guests have no segments,
+// their per customer limits count the orders placed with their email.
+type customer struct {
+	db         *gorm.DB
+	userID     *uint
+	guestEmail string
+	computed   map[string]bool
+}
+
+// orders returns the condition that picks the customer's orders, "" for anonymous carts
+func (c *customer) orders() (string, interface{}) {
+	switch {
+	case c.userID != nil:
+		return "orders.user_id = ?", *c.userID
+	case c.guestEmail != "":
+		return "orders.guest_email = ?", c.guestEmail
+	}
+	return "", nil
+}
+
+// vipSpend is the lifetime spend from VIP_SEGMENT_SPEND (default 500) that puts a customer in the vip segment
+func vipSpend() float64 {
+	spend, err := strconv.ParseFloat(os.Getenv("VIP_SEGMENT_SPEND"), 64)
+	if err != nil || spend <= 0 {
+		return 500
+	}
+	return spend
+}
+
+func (c *customer) segments() (map[string]bool, error) {
+	if c.computed != nil || c.userID == nil {
+		return c.computed, nil
+	}
+
+	var history struct {
+		Orders int
+		Spend  float64
+	}
+	if err := c.db.Model(&models.Order{}).
+		Select("COUNT(*) AS orders, COALESCE(SUM(total_price), 0) AS spend").
+		Where("user_id = ? AND order_status <> 'cancelled'", *c.userID).
+		Scan(&history).Error; err != nil {
+		return nil, err
+	}
+
+	tier, _, _, err := loyalty.TierOf(c.db, *c.userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	c.computed = map[string]bool{
+		"new":       history.Orders == 0,
+		"returning": history.Orders > 0,
+		"vip":       history.Spend >= vipSpend(),
+		"silver":    tier.Name == "silver" || tier.Name == "gold", // Gold customers get silver benefits too
+		"gold":      tier.Name == "gold",
+	}
+	return c.computed, nil
+}
+
+// productLines looks up the parent, category and brand of products as engine lines without quantity and
+// price. Variations without a brand of their own use their parent's.
+func productLines(db *gorm.DB, ids []uint) (map[uint]Line, error) {
+	var products []struct {
+		ID         uint
+		ParentID   uint
+		CategoryID uint
+		Brand      string
+	}
+	if err := db.Table("products").
+		Select("products.id, COALESCE(products.parent_id, 0) AS parent_id, products.category_id, COALESCE(products.brand, parent.brand, '') AS brand").
+		Joins("LEFT JOIN products AS parent ON parent.id = products.parent_id").
+		Where("products.id IN ?", ids).
+		Scan(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := map[uint]Line{}
+	for _, product := range products {
+		byID[product.ID] = Line{ParentID: product.ParentID, CategoryID: product.CategoryID, Brand: product.Brand}
+	}
+	return byID, nil
+}
+
+// OrderLines turns priced order items into engine lines with the category and brand of their product
+func OrderLines(db *gorm.DB, items []models.OrderItem) ([]Line, error) {
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.ProductID
+	}
+	byID, err := productLines(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]Line, len(items))
+	for i, item := range items {
+		lines[i] = Line{
+			ProductID:  item.ProductID,
+			ParentID:   byID[item.ProductID].ParentID,
+			CategoryID: byID[item.ProductID].CategoryID,
+			Brand:      byID[item.ProductID].Brand,
+			Quantity:   item.Quantity,
+			UnitPrice:  item.PriceAtPurchase,
+		}
+	}
+	return lines, nil
+}
+
+// OrderDiscounts turns the applied discounts into the per line records of an order whose items were
+// saved in the order of the cart lines
+func (r *Result) OrderDiscounts(order *models.Order) []models.OrderDiscount {
+	var records []models.OrderDiscount
+	for _, discount := range r.Discounts {
+		for _, share := range discount.Lines {
+			item := order.OrderItems[share.Line]
+			records = append(records, models.OrderDiscount{
+				OrderID:     order.ID,
+				OrderItemID: &item.ID,
+				ProductID:   &item.ProductID,
+				CouponID:    discount.CouponID,
+				CampaignID:  discount.CampaignID,
+				PromotionID: discount.PromotionID,
+				Code:        discount.Code,
+				Description: discount.Description,
+				Amount:      share.Amount,
+			})
+		}
+		if discount.Shipping > 0 {
+			records = append(records, models.OrderDiscount{
+				OrderID:     order.ID,
+				CouponID:    discount.CouponID,
+				CampaignID:  discount.CampaignID,
+				PromotionID: discount.PromotionID,
+				Code:        discount.Code,
+				Description: discount.Description,
+				Amount:      discount.Shipping,
+			})
+		}
+	}
+	return records
+}
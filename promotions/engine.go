@@ -0,0 +1,316 @@
+package promotions
+
+import (
+	"backend/models"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Line is one product of a cart or order as the engine sees it
+type Line struct {
+	ProductID  uint
+	ParentID   uint // Parent of a variation, 0 otherwise
+	CategoryID uint
+	Brand      string
+	Quantity   int
+	UnitPrice  float64 // Price the customer pays before discounts
+}
+
+// Total is the price of the line before discounts
+func (l Line) Total() float64 {
+	return round(l.UnitPrice * float64(l.Quantity))
+}
+
+// Cart is what discounts are computed for, a shopping cart or an order being placed
+type Cart struct {
+	UserID     *uint
+	GuestEmail string // Email of a guest checkout, for the per customer limits
+	Lines      []Line
+	Shipping   float64
+	Points     int // Loyalty points the customer redeems
+}
+
+// LineDiscount is the share of a discount taken off one line
+type LineDiscount struct {
+	Line      int // Index of the line in Cart.Lines
+	ProductID uint
+	Amount    float64
+}
+
+// Discount is a coupon, automatic promotion or loyalty points redemption applied to the cart with the amount it took off each line
+// and the shipping
+type Discount struct {
+	CouponID       *uint
+	CampaignID     *uint
+	CampaignCodeID *uint
+	PromotionID    *uint
+	Points         int    // Loyalty points redeemed for the discount
+	Code           string // Empty for automatic promotions and loyalty points
+	Description    string
+	Amount         float64 // Taken off the lines
+	Shipping       float64 // Taken off the shipping cost
+	Lines          []LineDiscount
+}
+
+// Rejection explains why a coupon code was not applied
+type Rejection struct {
+	Code   string
+	Reason string
+}
+
+// Result is the outcome of evaluating a cart, its totals never go below zero
+type Result struct {
+	Subtotal         float64
+	Discounts        []Discount
+	Rejected         []Rejection
+	DiscountAmount   float64 // Line and shipping discounts together
+	ShippingDiscount float64
+	Shipping         float64 // Shipping cost after discounts
+	Total            float64
+}
+
+// LineDiscount returns the discount taken off a line by all applied discounts
+func (r *Result) LineDiscount(line int) float64 {
+	total := 0.0
+	for _, discount := range r.Discounts {
+		for _, share := range discount.Lines {
+			if share.Line == line {
+				total += share.Amount
+			}
+		}
+	}
+	return round(total)
+}
+
+// Evaluate applies the automatic promotions running at the time and the coupons with the given codes to
+// a cart. Promotions are applied first, then coupons, each by priority and on what is left of the
+// eligible lines after the previous ones, so discounts can never exceed the cart. An exclusive promotion
+// is not combined with other promotions and an exclusive coupon not with other coupons. Promotions the
+// cart does not qualify for are left out without a rejection. Redeemed loyalty points come last, off what
+// is left to pay for the items.
+func Evaluate(db *gorm.DB, cart Cart, codes []string, at time.Time) (*Result, error) {
+	result := &Result{Shipping: round(cart.Shipping)}
+
+	remaining := make([]float64, len(cart.Lines))
+	for i, line := range cart.Lines {
+		remaining[i] = line.Total()
+		result.Subtotal += remaining[i]
+	}
+	result.Subtotal = round(result.Subtotal)
+
+	coupons, rejected, err := loadCoupons(db, codes)
+	if err != nil {
+		return nil, err
+	}
+	result.Rejected = rejected
+
+	// Highest priority first, ties keep the order the codes were entered in
+	sort.SliceStable(coupons, func(i, j int) bool { return coupons[i].Priority > coupons[j].Priority })
+
+	automatic, err := activePromotions(db, at)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(automatic) - 1; i >= 0; i-- {
+		coupons = append([]*models.Coupon{automatic[i].Coupon()}, coupons...)
+	}
+
+	customer := &customer{db: db, userID: cart.UserID, guestEmail: cart.GuestEmail}
+	// Promotions and coupons are exclusive among their own kind, keyed by whether they are automatic
+	exclusive := map[bool]*models.Coupon{}
+	applied := map[bool]int{}
+	for _, coupon := range coupons {
+		promotion := coupon.Promotion
+		reject := func(format string, args ...interface{}) {
+			if promotion == nil {
+				result.Rejected = append(result.Rejected, Rejection{Code: coupon.Code, Reason: fmt.Sprintf(format, args...)})
+			}
+		}
+
+		if other := exclusive[promotion != nil]; other != nil {
+			reject("cannot be combined with %s", other.Code)
+			continue
+		}
+		if coupon.Exclusive && applied[promotion != nil] > 0 {
+			reject("cannot be combined with other coupons")
+			continue
+		}
+
+		reason, err := checkCoupon(db, coupon, customer, at)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			reject(reason)
+			continue
+		}
+
+		eligible, err := eligibleLines(db, coupon, cart.Lines)
+		if err != nil {
+			return nil, err
+		}
+		if len(eligible) == 0 {
+			reject("does not apply to any item in the cart")
+			continue
+		}
+
+		base := 0.0
+		for _, i := range eligible {
+			base += cart.Lines[i].Total()
+		}
+		if coupon.MinOrderValue != nil && round(base) < *coupon.MinOrderValue {
+			reject("requires a minimum spend of %.2f on eligible items", *coupon.MinOrderValue)
+			continue
+		}
+		value := coupon.DiscountValue
+		if promotion != nil && promotion.Kind == "tiered" {
+			tier := promotion.Tier(round(base))
+			if tier == nil {
+				continue
+			}
+			value = tier.DiscountValue
+		}
+
+		discount := Discount{Code: coupon.Code, Description: coupon.Description}
+		switch {
+		case promotion != nil:
+			discount.PromotionID = &promotion.ID
+		case coupon.CampaignCode != nil:
+			discount.CampaignID, discount.CampaignCodeID = &coupon.CampaignCode.CampaignID, &coupon.CampaignCode.ID
+		default:
+			discount.CouponID = &coupon.ID
+		}
+		switch {
+		case promotion != nil && promotion.Kind == "bogo":
+			discount.Lines = bogo(promotion, value, eligible, cart.Lines, remaining)
+		case coupon.DiscountType == "free_shipping":
+			discount.Shipping = result.Shipping - result.ShippingDiscount
+			if coupon.MaxDiscountValue != nil {
+				discount.Shipping = math.Min(discount.Shipping, *coupon.MaxDiscountValue)
+			}
+			discount.Shipping = round(discount.Shipping)
+		case coupon.DiscountType == "percentage":
+			available := 0.0
+			for _, i := range eligible {
+				available += remaining[i]
+			}
+			amount := available * value / 100
+			if coupon.MaxDiscountValue != nil {
+				amount = math.Min(amount, *coupon.MaxDiscountValue)
+			}
+			discount.Lines = allocate(round(amount), eligible, cart.Lines, remaining)
+		default:
+			discount.Lines = allocate(value, eligible, cart.Lines, remaining)
+		}
+
+		for _, share := range discount.Lines {
+			discount.Amount += share.Amount
+		}
+		discount.Amount = round(discount.Amount)
+		if discount.Amount == 0 && discount.Shipping == 0 {
+			reject("gives no further discount on this cart")
+			continue
+		}
+
+		result.Discounts = append(result.Discounts, discount)
+		result.DiscountAmount += discount.Amount + discount.Shipping
+		result.ShippingDiscount += discount.Shipping
+		applied[promotion != nil]++
+		if coupon.Exclusive {
+			exclusive[promotion != nil] = coupon
+		}
+	}
+
+	if cart.Points > 0 {
+		if err := applyPoints(db, cart, result, remaining); err != nil {
+			return nil, err
+		}
+	}
+
+	result.DiscountAmount = round(result.DiscountAmount)
+	result.ShippingDiscount = round(result.ShippingDiscount)
+	result.Shipping = round(result.Shipping - result.ShippingDiscount)
+	result.Total = round(result.Subtotal - result.DiscountAmount + cart.Shipping)
+
+	return result, nil
+}
+
+// allocate spreads amount over the eligible lines in proportion to what is left of them, capped at
+// what is left, and takes the shares off remaining
+func allocate(amount float64, eligible []int, lines []Line, remaining []float64) []LineDiscount {
+	var open []int
+	available := 0.0
+	for _, i := range eligible {
+		if remaining[i] > 0 {
+			open = append(open, i)
+			available += remaining[i]
+		}
+	}
+	amount = math.Min(round(amount), round(available))
+	if amount <= 0 {
+		return nil
+	}
+
+	var shares []LineDiscount
+	left := amount
+	for n, i := range open {
+		share := round(amount * remaining[i] / available)
+		if n == len(open)-1 {
+			// The last line takes the rounding difference
+			share = left
+		}
+		share = math.Min(math.Min(share, left), remaining[i])
+		if share <= 0 {
+			continue
+		}
+
+		remaining[i] = round(remaining[i] - share)
+		left = round(left - share)
+		shares = append(shares, LineDiscount{Line: i, ProductID: lines[i].ProductID, Amount: share})
+	}
+	return shares
+}
+
+// bogo takes percent off GetQuantity items for every full group of BuyQuantity + GetQuantity eligible
+// items, the discounted items being the cheapest ones, with items priced at what is left of their line.
+// Lines are counted as whole price tiers so the quantity of a line does not matter.
+func bogo(promotion *models.Promotion, percent float64, eligible []int, lines []Line, remaining []float64) []LineDiscount {
+	var open []int
+	units := 0
+	for _, i := range eligible {
+		if lines[i].Quantity <= 0 || remaining[i] <= 0 {
+			continue
+		}
+		open = append(open, i)
+		units += lines[i].Quantity
+	}
+	unitPrice := func(i int) float64 { return remaining[i] / float64(lines[i].Quantity) }
+	sort.SliceStable(open, func(a, b int) bool { return unitPrice(open[a]) < unitPrice(open[b]) })
+
+	free := units / (promotion.BuyQuantity + promotion.GetQuantity) * promotion.GetQuantity
+
+	var shares []LineDiscount
+	for _, i := range open {
+		if free == 0 {
+			break
+		}
+		quantity := min(free, lines[i].Quantity)
+		free -= quantity
+
+		share := math.Min(round(unitPrice(i)*float64(quantity)*percent/100), remaining[i])
+		if share <= 0 {
+			continue
+		}
+		remaining[i] = round(remaining[i] - share)
+		shares = append(shares, LineDiscount{Line: i, ProductID: lines[i].ProductID, Amount: share})
+	}
+	return shares
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
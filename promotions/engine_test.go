@@ -0,0 +1,196 @@
+package promotions
+
+import (
+	"backend/models"
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	lines := []Line{
+		{ProductID: 1, Quantity: 1, UnitPrice: 60},
+		{ProductID: 2, Quantity: 2, UnitPrice: 20},
+		{ProductID: 3, Quantity: 1, UnitPrice: 10},
+	}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		eligible  []int
+		remaining []float64
+		want      []LineDiscount
+		left      []float64
+	}{
+		{
+			name:      "in proportion to the lines",
+			amount:    10,
+			eligible:  []int{0, 1},
+			remaining: []float64{60, 40, 10},
+			want:      []LineDiscount{{Line: 0, ProductID: 1, Amount: 6}, {Line: 1, ProductID: 2, Amount: 4}},
+			left:      []float64{54, 36, 10},
+		},
+		{
+			name:      "the last line takes the rounding difference",
+			amount:    10,
+			eligible:  []int{0, 1, 2},
+			remaining: []float64{10, 10, 10},
+			want:      []LineDiscount{{Line: 0, ProductID: 1, Amount: 3.33}, {Line: 1, ProductID: 2, Amount: 3.33}, {Line: 2, ProductID: 3, Amount: 3.34}},
+			left:      []float64{6.67, 6.67, 6.66},
+		},
+		{
+			name:      "capped at what is left",
+			amount:    200,
+			eligible:  []int{1, 2},
+			remaining: []float64{60, 40, 10},
+			want:      []LineDiscount{{Line: 1, ProductID: 2, Amount: 40}, {Line: 2, ProductID: 3, Amount: 10}},
+			left:      []float64{60, 0, 0},
+		},
+		{
+			name:      "lines already discounted in full are skipped",
+			amount:    5,
+			eligible:  []int{0, 1},
+			remaining: []float64{0, 40, 10},
+			want:      []LineDiscount{{Line: 1, ProductID: 2, Amount: 5}},
+			left:      []float64{0, 35, 10},
+		},
+		{
+			name:      "shares of what is left after earlier discounts",
+			amount:    9,
+			eligible:  []int{0, 1},
+			remaining: []float64{30, 15, 10},
+			want:      []LineDiscount{{Line: 0, ProductID: 1, Amount: 6}, {Line: 1, ProductID: 2, Amount: 3}},
+			left:      []float64{24, 12, 10},
+		},
+		{
+			name:      "nothing left",
+			amount:    10,
+			eligible:  []int{0},
+			remaining: []float64{0, 40, 10},
+			want:      nil,
+			left:      []float64{0, 40, 10},
+		},
+		{
+			name:      "zero amount",
+			amount:    0,
+			eligible:  []int{0, 1, 2},
+			remaining: []float64{60, 40, 10},
+			want:      nil,
+			left:      []float64{60, 40, 10},
+		},
+		{
+			name:      "amounts are rounded to cents",
+			amount:    0.004,
+			eligible:  []int{0},
+			remaining: []float64{60, 40, 10},
+			want:      nil,
+			left:      []float64{60, 40, 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocate(tt.amount, tt.eligible, lines, tt.remaining)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocate = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.remaining, tt.left) {
+				t.Errorf("remaining = %v, want %v", tt.remaining, tt.left)
+			}
+		})
+	}
+}
+
+func TestBogo(t *testing.T) {
+	buy2get1 := &models.Promotion{BuyQuantity: 2, GetQuantity: 1}
+	buy1get1 := &models.Promotion{BuyQuantity: 1, GetQuantity: 1}
+	lines := []Line{
+		{ProductID: 1, Quantity: 1, UnitPrice: 30},
+		{ProductID: 2, Quantity: 2, UnitPrice: 10},
+		{ProductID: 3, Quantity: 3, UnitPrice: 20},
+		{ProductID: 4, Quantity: 0, UnitPrice: 5},
+	}
+
+	tests := []struct {
+		name      string
+		promotion *models.Promotion
+		percent   float64
+		eligible  []int
+		remaining []float64
+		want      []LineDiscount
+		left      []float64
+	}{
+		{
+			name:      "cheapest item free",
+			promotion: buy2get1,
+			percent:   100,
+			eligible:  []int{0, 1},
+			remaining: []float64{30, 20, 60, 0},
+			want:      []LineDiscount{{Line: 1, ProductID: 2, Amount: 10}},
+			left:      []float64{30, 10, 60, 0},
+		},
+		{
+			name:      "one free item per full group",
+			promotion: buy2get1,
+			percent:   100,
+			eligible:  []int{0, 1, 2},
+			remaining: []float64{30, 20, 60, 0},
+			want:      []LineDiscount{{Line: 1, ProductID: 2, Amount: 20}},
+			left:      []float64{30, 0, 60, 0},
+		},
+		{
+			name:      "free items run over into the next cheapest line",
+			promotion: buy1get1,
+			percent:   100,
+			eligible:  []int{0, 1, 2},
+			remaining: []float64{30, 20, 60, 0},
+			want:      []LineDiscount{{Line: 1, ProductID: 2, Amount: 20}, {Line: 2, ProductID: 3, Amount: 20}},
+			left:      []float64{30, 0, 40, 0},
+		},
+		{
+			name:      "percentage off",
+			promotion: buy1get1,
+			percent:   50,
+			eligible:  []int{0, 2},
+			remaining: []float64{30, 20, 60, 0},
+			want:      []LineDiscount{{Line: 2, ProductID: 3, Amount: 20}},
+			left:      []float64{30, 20, 40, 0},
+		},
+		{
+			name:      "items are priced at what is left of their line",
+			promotion: buy2get1,
+			percent:   100,
+			eligible:  []int{0, 2},
+			remaining: []float64{12, 20, 60, 0},
+			want:      []LineDiscount{{Line: 0, ProductID: 1, Amount: 12}},
+			left:      []float64{0, 20, 60, 0},
+		},
+		{
+			name:      "incomplete group",
+			promotion: buy2get1,
+			percent:   100,
+			eligible:  []int{1},
+			remaining: []float64{30, 20, 60, 0},
+			want:      nil,
+			left:      []float64{30, 20, 60, 0},
+		},
+		{
+			name:      "lines without quantity or anything left are not counted",
+			promotion: buy1get1,
+			percent:   100,
+			eligible:  []int{0, 1, 3},
+			remaining: []float64{30, 0, 60, 0},
+			want:      nil,
+			left:      []float64{30, 0, 60, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bogo(tt.promotion, tt.percent, tt.eligible, lines, tt.remaining)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bogo = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.remaining, tt.left) {
+				t.Errorf("remaining = %v, want %v", tt.remaining, tt.left)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package promotions
+
+import (
+	"backend/loyalty"
+	"fmt"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// PointsCode is the code rejections of loyalty points are reported under
+const PointsCode = "loyalty_points"
+
+// applyPoints takes the value of the redeemed loyalty points off what is left of the lines after the other
+// discounts. When that is worth less than the points, only the points needed are used.
+func applyPoints(db *gorm.DB, cart Cart, result *Result, remaining []float64) error {
+	reject := func(format string, args ...interface{}) {
+		result.Rejected = append(result.Rejected, Rejection{Code: PointsCode, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if cart.UserID == nil {
+		reject("sign in to redeem loyalty points")
+		return nil
+	}
+	if minimum := loyalty.MinRedeemPoints(); cart.Points < minimum {
+		reject("at least %d points must be redeemed", minimum)
+		return nil
+	}
+	balance, err := loyalty.Balance(db, *cart.UserID)
+	if err != nil {
+		return err
+	}
+	if cart.Points > balance {
+		reject("you have %d points", balance)
+		return nil
+	}
+
+	eligible := make([]int, len(cart.Lines))
+	for i := range cart.Lines {
+		eligible[i] = i
+	}
+	pointValue := loyalty.PointValue()
+	value := round(float64(cart.Points) * pointValue)
+
+	discount := Discount{Lines: allocate(value, eligible, cart.Lines, remaining), Points: cart.Points}
+	for _, share := range discount.Lines {
+		discount.Amount += share.Amount
+	}
+	discount.Amount = round(discount.Amount)
+	if discount.Amount == 0 {
+		reject("nothing is left to pay with points")
+		return nil
+	}
+	if discount.Amount < value {
+		discount.Points = int(math.Ceil(discount.Amount/pointValue - 1e-9))
+	}
+	discount.Description = fmt.Sprintf("%d loyalty points", discount.Points)
+
+	result.Discounts = append(result.Discounts, discount)
+	result.DiscountAmount += discount.Amount
+	return nil
+}
@@ -0,0 +1,184 @@
+package promotions
+
+import (
+	"backend/loyalty"
+	"backend/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCouponUnavailable is returned when a coupon reached its usage limit between evaluating a cart and placing the order
+var ErrCouponUnavailable = errors.New("coupon is no longer available")
+
+// ErrPromotionUnavailable is returned when the customer reached the limit of a promotion with another order
+var ErrPromotionUnavailable = errors.New("promotion is no longer available")
+
+// usageLimitReason returns why a coupon reached its total or per user usage limit, or "" when it did not.
+// Reversed redemptions are not counted.
+func usageLimitReason(db *gorm.DB, coupon *models.Coupon, customer *customer) (string, error) {
+	if promotion := coupon.Promotion; promotion != nil {
+		reached, err := promotionLimitReached(db, promotion, customer, 0)
+		if err != nil || !reached {
+			return "", err
+		}
+		return "promotion limit reached", nil
+	}
+	ordered, arg := customer.orders()
+	if code := coupon.CampaignCode; code != nil {
+		if ordered == "" {
+			return "", nil
+		}
+		var count int64
+		if err := db.Session(&gorm.Session{NewDB: true}).Model(&models.CampaignCode{}).
+			Joins("JOIN orders ON orders.id = campaign_codes.order_id").
+			Where(ordered, arg).
+			Where("campaign_codes.campaign_id = ? AND campaign_codes.redeemed_at IS NOT NULL AND campaign_codes.id <> ?", code.CampaignID, code.ID).
+			Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count >= int64(coupon.UsageLimitPerUser) {
+			return "you have already used a code of this campaign", nil
+		}
+		return "", nil
+	}
+
+	used := db.Session(&gorm.Session{NewDB: true}).Model(&models.CouponUsageHistory{}).Where("coupon_usage_histories.coupon_id = ? AND coupon_usage_histories.reversed_at IS NULL", coupon.ID)
+
+	if coupon.UsageLimit != nil {
+		var count int64
+		if err := used.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count >= int64(*coupon.UsageLimit) {
+			return "coupon usage limit reached", nil
+		}
+	}
+
+	if ordered != "" {
+		mine := used.Session(&gorm.Session{})
+		if customer.userID != nil {
+			mine = mine.Where("coupon_usage_histories.user_id = ?", *customer.userID)
+		} else {
+			mine = mine.Joins("JOIN orders ON orders.id = coupon_usage_histories.order_id").Where(ordered, arg)
+		}
+		var count int64
+		if err := mine.Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count >= int64(coupon.UsageLimitPerUser) {
+			return "you have already used this coupon", nil
+		}
+	}
+	return "", nil
+}
+
+// Redeem records a use of every applied coupon by the order, takes off the redeemed loyalty points and
+// checks the per customer limits of the applied promotions, whose uses are the saved order discounts. It runs in the order transaction and locks
+// each coupon and limited promotion, so concurrent orders cannot go over a usage limit.
+func (r *Result) Redeem(tx *gorm.DB, order *models.Order) error {
+	customer := &customer{db: tx, userID: order.UserID}
+	if order.GuestEmail != nil {
+		customer.guestEmail = *order.GuestEmail
+	}
+	for _, discount := range r.Discounts {
+		if discount.Points > 0 {
+			if order.UserID == nil {
+				return loyalty.ErrNotEnoughPoints
+			}
+			if err := loyalty.Redeem(tx, *order.UserID, order.ID, discount.Points); err != nil {
+				return err
+			}
+			continue
+		}
+		if discount.PromotionID != nil {
+			if err := checkPromotionLimit(tx, *discount.PromotionID, customer, order.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if discount.CampaignCodeID != nil {
+			if err := redeemCampaignCode(tx, *discount.CampaignCodeID, customer, order.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if discount.CouponID == nil {
+			continue
+		}
+
+		var coupon models.Coupon
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, *discount.CouponID).Error; err != nil {
+			return err
+		}
+		reason, err := usageLimitReason(tx, &coupon, customer)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return ErrCouponUnavailable
+		}
+
+		if err := tx.Create(&models.CouponUsageHistory{CouponID: coupon.ID, UserID: order.UserID, OrderID: &order.ID, UsedAt: time.Now()}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPromotionLimit fails when the customer reached the limit of a promotion with other orders
+func checkPromotionLimit(tx *gorm.DB, promotionID uint, customer *customer, orderID uint) error {
+	var promotion models.Promotion
+	if err := tx.Select("id", "usage_limit_per_user").First(&promotion, promotionID).Error; err != nil {
+		return err
+	}
+	if promotion.UsageLimitPerUser == nil {
+		return nil
+	}
+	// Only limited promotions are locked, so unlimited ones never hold up orders
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Promotion{}, promotionID).Error; err != nil {
+		return err
+	}
+	reached, err := promotionLimitReached(tx, &promotion, customer, orderID)
+	if err != nil {
+		return err
+	}
+	if reached {
+		return ErrPromotionUnavailable
+	}
+	return nil
+}
+
+// redeemCampaignCode marks a single use code as used by the order, unless another order took it first
+func redeemCampaignCode(tx *gorm.DB, codeID uint, customer *customer, orderID uint) error {
+	var code models.CampaignCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Campaign").First(&code, codeID).Error; err != nil {
+		return err
+	}
+	if code.RevokedAt != nil || code.RedeemedAt != nil {
+		return ErrCouponUnavailable
+	}
+	reason, err := usageLimitReason(tx, code.Campaign.Coupon(&code), customer)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return ErrCouponUnavailable
+	}
+
+	return tx.Model(&code).Updates(map[string]interface{}{"redeemed_at": time.Now(), "order_id": orderID, "user_id": customer.userID}).Error
+}
+
+// ReverseRedemptions gives back the coupon uses and campaign codes of a cancelled order
+func ReverseRedemptions(tx *gorm.DB, orderID uint) error {
+	if err := tx.Model(&models.CouponUsageHistory{}).
+		Where("order_id = ? AND reversed_at IS NULL", orderID).
+		Update("reversed_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.CampaignCode{}).
+		Where("order_id = ?", orderID).
+		Updates(map[string]interface{}{"redeemed_at": nil, "order_id": nil, "user_id": nil}).Error
+}
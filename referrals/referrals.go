@@ -0,0 +1,190 @@
+package referrals
+
+import (
+	"backend/models"
+	"backend/promotions"
+	"backend/utils"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// codePattern makes referral codes short enough to share by word of mouth
+const codePattern = "XXXXXXXX"
+
+var ErrInvalidReferralCode = errors.New("invalid referral code")
+
+// freeEmailDomains are shared by unrelated customers, so a referrer and referee using one of them is not
+// suspicious
+var freeEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "outlook.com": true, "hotmail.com": true,
+	"live.com": true, "icloud.com": true, "me.com": true, "aol.com": true, "proton.me": true,
+	"protonmail.com": true, "gmx.com": true, "mail.com": true, "yandex.com": true,
+}
+
+// CodeFor returns the referral code of a customer, generating it the first time
+func CodeFor(db *gorm.DB, userID uint) (string, error) {
+	db = db.Session(&gorm.Session{NewDB: true})
+	for attempts := 0; attempts < 10; attempts++ {
+		var user models.User
+		if err := db.Select("id", "referral_code").First(&user, userID).Error; err != nil {
+			return "", err
+		}
+		if user.ReferralCode != nil {
+			return *user.ReferralCode, nil
+		}
+
+		code, err := utils.GenerateCode("", codePattern)
+		if err != nil {
+			return "", err
+		}
+		var taken int64
+		if err := db.Unscoped().Model(&models.User{}).Where("referral_code = ?", code).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken > 0 {
+			continue
+		}
+		// Another request may have generated a code in the meantime, the next attempt reads it
+		if err := db.Model(&user).Where("referral_code IS NULL").Update("referral_code", code).Error; err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("could not generate a unique referral code")
+}
+
+// RecordDevice remembers that a customer used a device, for the device fraud guard. Requests without a
+// device ID are ignored.
+func RecordDevice(db *gorm.DB, userID uint, deviceID string) error {
+	deviceID = strings.TrimSpace(deviceID)
+	if deviceID == "" || len(deviceID) > 100 {
+		return nil
+	}
+	return db.Exec(`INSERT INTO user_devices (user_id, device_id, last_seen_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at`,
+		userID, deviceID, time.Now()).Error
+}
+
+// Refer records that a customer who just registered was referred with a code, in the registration
+// transaction. Referrals that pass the fraud guards give the referee a code of the referral campaign
+// running at the time as welcome discount, the others are flagged for an admin to review.
+func Refer(tx *gorm.DB, referee *models.User, code string, deviceID string) (*models.Referral, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	var referrer models.User
+	if err := tx.Where("referral_code = ?", code).First(&referrer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidReferralCode
+		}
+		return nil, err
+	}
+	if referrer.ID == referee.ID {
+		return nil, ErrInvalidReferralCode
+	}
+
+	var flags pq.StringArray
+	if domain := emailDomain(referee.Email); domain != "" && !freeEmailDomains[domain] && domain == emailDomain(referrer.Email) {
+		flags = append(flags, "email_domain")
+	}
+	if referee.Address != nil {
+		shared, err := sharesAddress(tx, referrer, *referee.Address)
+		if err != nil {
+			return nil, err
+		}
+		if shared {
+			flags = append(flags, "address")
+		}
+	}
+	if deviceID = strings.TrimSpace(deviceID); deviceID != "" {
+		var used int64
+		if err := tx.Model(&models.UserDevice{}).Where("user_id = ? AND device_id = ?", referrer.ID, deviceID).Count(&used).Error; err != nil {
+			return nil, err
+		}
+		if used > 0 {
+			flags = append(flags, "device")
+		}
+	}
+
+	referral := &models.Referral{ReferrerID: referrer.ID, RefereeID: referee.ID, Code: code, Status: "pending", Flags: flags}
+	if len(flags) > 0 {
+		referral.Status = "flagged"
+	}
+	if err := tx.Create(referral).Error; err != nil {
+		return nil, err
+	}
+	if referral.Status == "pending" {
+		if err := issueWelcomeCode(tx, referral); err != nil {
+			return nil, err
+		}
+	}
+	return referral, nil
+}
+
+// issueWelcomeCode gives the referee a code of the latest referral campaign running now, referrals
+// keep working without a welcome discount when there is none
+func issueWelcomeCode(tx *gorm.DB, referral *models.Referral) error {
+	if referral.WelcomeCodeID != nil {
+		return nil
+	}
+	now := time.Now()
+	var campaign models.Campaign
+	err := tx.Where("referral = true AND is_active = true AND start_date <= ? AND (expiration_date IS NULL OR expiration_date > ?)", now, now).
+		Order("id DESC").
+		First(&campaign).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err := promotions.IssueCampaignCode(tx, campaign.ID, referral.RefereeID)
+	if err != nil {
+		return err
+	}
+	referral.WelcomeCodeID, referral.WelcomeCode = &code.ID, code
+	return tx.Model(referral).Update("welcome_code_id", code.ID).Error
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// normalizeAddress lets addresses that only differ in case, spacing and punctuation match
+func normalizeAddress(address string) string {
+	return nonAlphanumeric.ReplaceAllString(strings.ToLower(address), "")
+}
+
+// sharesAddress tells whether an address is the one of the referrer's account or of one of their orders
+func sharesAddress(tx *gorm.DB, referrer models.User, address string) (bool, error) {
+	address = normalizeAddress(address)
+	if address == "" {
+		return false, nil
+	}
+	if referrer.Address != nil && normalizeAddress(*referrer.Address) == address {
+		return true, nil
+	}
+
+	var shipped []string
+	if err := tx.Model(&models.Order{}).
+		Distinct("order_shipping_address").
+		Where("user_id = ? AND order_shipping_address <> ''", referrer.ID).
+		Pluck("order_shipping_address", &shipped).Error; err != nil {
+		return false, err
+	}
+	for _, other := range shipped {
+		if normalizeAddress(other) == address {
+			return true, nil
+		}
+	}
+	return false, nil
+}
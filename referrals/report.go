@@ -0,0 +1,158 @@
+package referrals
+
+import (
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxChainDepth bounds how many levels of a referral chain are followed
+const maxChainDepth = 10
+
+// Stats summarises the referral programme. Costs and revenue only count orders that were not cancelled.
+type Stats struct {
+	Referrals           int64
+	Pending             int64
+	Flagged             int64
+	Rewarded            int64
+	Rejected            int64
+	RewardCost          float64 // Store credit given to referrers
+	WelcomeDiscountCost float64 // Taken off the orders of referees by their welcome codes
+	TotalCost           float64
+	CostPerReward       float64 // Total cost per rewarded referral
+	RefereeRevenue      float64 // Orders of referred customers whose referral was not rejected
+	TopReferrers        []Referrer
+}
+
+// Referrer is a customer with the referrals they made
+type Referrer struct {
+	UserID     uint
+	Name       string
+	Email      string
+	Referrals  int64
+	Rewarded   int64
+	RewardCost float64
+}
+
+// GetStats works out the statistics of the referral programme
+func GetStats(db *gorm.DB) (*Stats, error) {
+	stats := &Stats{}
+	if err := db.Raw(`SELECT COUNT(*) AS referrals,
+			COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+			COUNT(*) FILTER (WHERE status = 'flagged') AS flagged,
+			COUNT(*) FILTER (WHERE status = 'rewarded') AS rewarded,
+			COUNT(*) FILTER (WHERE status = 'rejected') AS rejected,
+			COALESCE(SUM(reward_amount), 0) AS reward_cost
+		FROM referrals`).
+		Scan(stats).Error; err != nil {
+		return nil, err
+	}
+
+	var totals struct {
+		WelcomeDiscountCost float64
+		RefereeRevenue      float64
+	}
+	if err := db.Raw(`SELECT
+			(SELECT COALESCE(SUM(order_discounts.amount), 0)
+				FROM referrals
+				JOIN campaign_codes ON campaign_codes.id = referrals.welcome_code_id
+				JOIN order_discounts ON order_discounts.order_id = campaign_codes.order_id AND order_discounts.campaign_id = campaign_codes.campaign_id
+				JOIN orders ON orders.id = order_discounts.order_id AND orders.deleted_at IS NULL AND orders.order_status <> 'cancelled'
+			) AS welcome_discount_cost,
+			(SELECT COALESCE(SUM(orders.total_price), 0)
+				FROM orders
+				JOIN referrals ON referrals.referee_id = orders.user_id AND referrals.status <> 'rejected'
+				WHERE orders.deleted_at IS NULL AND orders.order_status <> 'cancelled'
+			) AS referee_revenue`).
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	stats.WelcomeDiscountCost, stats.RefereeRevenue = totals.WelcomeDiscountCost, totals.RefereeRevenue
+	stats.TotalCost = round(stats.RewardCost + stats.WelcomeDiscountCost)
+	if stats.Rewarded > 0 {
+		stats.CostPerReward = round(stats.TotalCost / float64(stats.Rewarded))
+	}
+
+	if err := db.Raw(`SELECT users.id AS user_id, users.name, users.email,
+			COUNT(*) AS referrals,
+			COUNT(*) FILTER (WHERE referrals.status = 'rewarded') AS rewarded,
+			COALESCE(SUM(referrals.reward_amount), 0) AS reward_cost
+		FROM referrals
+		JOIN users ON users.id = referrals.referrer_id
+		GROUP BY users.id
+		ORDER BY referrals DESC, users.id
+		LIMIT 10`).
+		Scan(&stats.TopReferrers).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ChainLink is a referral of a chain, Depth counts the steps from the customer the chain is about
+type ChainLink struct {
+	ReferralID   uint
+	ReferrerID   uint
+	ReferrerName string
+	RefereeID    uint
+	RefereeName  string
+	RefereeEmail string
+	Status       string
+	RewardAmount float64
+	Depth        int
+	CreatedAt    time.Time
+}
+
+// Chain is who referred a customer, and who referred them, and everyone the customer referred directly
+// or through the customers they referred
+type Chain struct {
+	Upline   []ChainLink // Nearest referrer first
+	Downline []ChainLink
+}
+
+const chainLinkColumns = `referrals.id AS referral_id, referrals.referrer_id, referrer.name AS referrer_name,
+	referrals.referee_id, referee.name AS referee_name, referee.email AS referee_email,
+	referrals.status, referrals.reward_amount, chain.depth, referrals.created_at`
+
+// GetChain follows the referral chain of a customer up and down
+func GetChain(db *gorm.DB, userID uint) (*Chain, error) {
+	chain := &Chain{}
+	if err := db.Raw(`WITH RECURSIVE chain AS (
+			SELECT id, referrer_id, 1 AS depth FROM referrals WHERE referee_id = ?
+			UNION ALL
+			SELECT referrals.id, referrals.referrer_id, chain.depth + 1
+			FROM referrals JOIN chain ON referrals.referee_id = chain.referrer_id
+			WHERE chain.depth < ?
+		)
+		SELECT `+chainLinkColumns+`
+		FROM chain
+		JOIN referrals ON referrals.id = chain.id
+		JOIN users AS referrer ON referrer.id = referrals.referrer_id
+		JOIN users AS referee ON referee.id = referrals.referee_id
+		ORDER BY chain.depth`, userID, maxChainDepth).
+		Scan(&chain.Upline).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Raw(`WITH RECURSIVE chain AS (
+			SELECT id, referee_id, 1 AS depth FROM referrals WHERE referrer_id = ?
+			UNION ALL
+			SELECT referrals.id, referrals.referee_id, chain.depth + 1
+			FROM referrals JOIN chain ON referrals.referrer_id = chain.referee_id
+			WHERE chain.depth < ?
+		)
+		SELECT `+chainLinkColumns+`
+		FROM chain
+		JOIN referrals ON referrals.id = chain.id
+		JOIN users AS referrer ON referrer.id = referrals.referrer_id
+		JOIN users AS referee ON referee.id = referrals.referee_id
+		ORDER BY chain.depth, referrals.id`, userID, maxChainDepth).
+		Scan(&chain.Downline).Error; err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
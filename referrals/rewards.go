@@ -0,0 +1,141 @@
+package referrals
+
+import (
+	"backend/models"
+	"backend/payments"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotReviewable = errors.New("only flagged referrals can be approved or rejected")
+
+// RewardAmount is the store credit a referrer gets, from REFERRAL_REWARD_AMOUNT (default 10)
+func RewardAmount() float64 {
+	amount, err := strconv.ParseFloat(os.Getenv("REFERRAL_REWARD_AMOUNT"), 64)
+	if err != nil || amount <= 0 {
+		return 10
+	}
+	return amount
+}
+
+// RewardForOrder rewards the referrer of a customer whose order was delivered, in the transaction that
+// marks it delivered. Only the first delivered order counts. Orders shipped to an address of the referrer
+// flag the referral instead.
+func RewardForOrder(tx *gorm.DB, orderID uint) error {
+	var order models.Order
+	if err := tx.Select("id", "user_id", "order_shipping_address").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	if order.UserID == nil {
+		return nil
+	}
+	var referral models.Referral
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("referee_id = ? AND status = 'pending'", order.UserID).First(&referral).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var referrer models.User
+	if err := tx.Select("id", "address").First(&referrer, referral.ReferrerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The referrer deleted their account
+			return tx.Model(&referral).Update("status", "rejected").Error
+		}
+		return err
+	}
+	shared, err := sharesAddress(tx, referrer, order.OrderShippingAddress)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return tx.Model(&referral).Updates(map[string]interface{}{
+			"status": "flagged",
+			"flags":  append(referral.Flags, "address"),
+		}).Error
+	}
+	return reward(tx, &referral, order.ID)
+}
+
+// reward gives the referrer their store credit for the delivered order of the referee
+func reward(tx *gorm.DB, referral *models.Referral, orderID uint) error {
+	amount := RewardAmount()
+	entry := models.StoreCreditTransaction{
+		UserID: referral.ReferrerID,
+		Kind:   "referral",
+		Amount: amount,
+		Reason: "Referral reward",
+	}
+	if err := payments.ChangeStoreCredit(tx, &entry); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	referral.Status, referral.OrderID, referral.RewardAmount, referral.RewardedAt = "rewarded", &orderID, amount, &now
+	return tx.Model(referral).Updates(map[string]interface{}{
+		"status":        referral.Status,
+		"order_id":      orderID,
+		"reward_amount": amount,
+		"rewarded_at":   now,
+	}).Error
+}
+
+// Review approves or rejects a flagged referral. An approved referral gets its welcome discount while the
+// referee has not ordered yet, and rewards the referrer right away when an order was already delivered.
+// A rejected referral revokes an unused welcome code.
+func Review(tx *gorm.DB, id uint, approve bool) (*models.Referral, error) {
+	var referral models.Referral
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&referral, id).Error; err != nil {
+		return nil, err
+	}
+	if referral.Status != "flagged" {
+		return nil, ErrNotReviewable
+	}
+
+	if !approve {
+		referral.Status = "rejected"
+		if err := tx.Model(&referral).Update("status", referral.Status).Error; err != nil {
+			return nil, err
+		}
+		if referral.WelcomeCodeID != nil {
+			if err := tx.Model(&models.CampaignCode{}).
+				Where("id = ? AND redeemed_at IS NULL", *referral.WelcomeCodeID).
+				Update("revoked_at", time.Now()).Error; err != nil {
+				return nil, err
+			}
+		}
+		return &referral, nil
+	}
+
+	referral.Status = "pending"
+	if err := tx.Model(&referral).Update("status", referral.Status).Error; err != nil {
+		return nil, err
+	}
+
+	var delivered models.Order
+	err := tx.Select("id").Where("user_id = ? AND order_status = 'delivered'", referral.RefereeID).Order("id").First(&delivered).Error
+	if err == nil {
+		return &referral, reward(tx, &referral, delivered.ID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var ordered int64
+	if err := tx.Model(&models.Order{}).Where("user_id = ?", referral.RefereeID).Count(&ordered).Error; err != nil {
+		return nil, err
+	}
+	if ordered == 0 {
+		if err := issueWelcomeCode(tx, &referral); err != nil {
+			return nil, err
+		}
+	}
+	return &referral, nil
+}
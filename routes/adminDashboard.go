@@ -16,5 +16,7 @@ func AdminDashboardRoutes(router *gin.Engine) {
 		adminDashboardRoutes.GET("/top-selling", controllers.GetTopSellingProducts)
 		adminDashboardRoutes.GET("/monthly-sales", controllers.GetMonthlySales)
 		adminDashboardRoutes.GET("/yearly-revenue", controllers.GetYearlyRevenue)
+		adminDashboardRoutes.GET("/price-history", controllers.GetPriceHistory)
+		adminDashboardRoutes.GET("/abandoned-carts", controllers.GetAbandonedCartStats)
 	}
 }
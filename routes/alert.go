@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AlertRoutes(router *gin.Engine) {
+	alerts := router.Group("/api/alerts")
+	{
+		alerts.GET("", middlewares.AuthMiddleware(), controllers.GetMyAlerts)
+		alerts.POST("/back-in-stock/", middlewares.AuthMiddleware(), controllers.SubscribeBackInStock)
+		alerts.DELETE("/:id/", middlewares.AuthMiddleware(), controllers.DeleteAlert)
+		alerts.POST("/unsubscribe/:token/", controllers.UnsubscribeAlerts)
+	}
+}
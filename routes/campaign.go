@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CampaignRoutes(router *gin.Engine) {
+	campaignRoutes := router.Group("/api/campaigns")
+	campaignRoutes.Use(middlewares.AuthMiddleware())
+	campaignRoutes.Use(middlewares.CheckIfAdmin())
+	{
+		campaignRoutes.POST("/", controllers.CreateCampaign)
+		campaignRoutes.GET("", controllers.GetCampaigns)
+		campaignRoutes.GET("/:id", controllers.GetCampaign)
+		campaignRoutes.PUT("/:id/", controllers.UpdateCampaign)
+		campaignRoutes.DELETE("/:id/", controllers.DeleteCampaign)
+		campaignRoutes.POST("/:id/codes/", controllers.GenerateCampaignCodes)
+		campaignRoutes.GET("/:id/codes", controllers.GetCampaignCodes)
+		campaignRoutes.GET("/:id/codes/export", controllers.ExportCampaignCodes)
+		campaignRoutes.POST("/:id/codes/:code_id/revoke/", controllers.RevokeCampaignCode)
+	}
+}
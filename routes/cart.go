@@ -10,12 +10,13 @@ import (
 func CartRoutes(router *gin.Engine) {
 	cartRoutes := router.Group("/api/cart")
 	{
-		cartRoutes.POST("/", middlewares.AuthMiddleware(), controllers.CreateShoppingCart)
-		cartRoutes.GET("", middlewares.AuthMiddleware(), controllers.GetShoppingCartByUserID)
-		cartRoutes.POST("/item/", middlewares.AuthMiddleware(), controllers.AddCartItem)
-		cartRoutes.PUT("/item/:id/", middlewares.AuthMiddleware(), controllers.UpdateCartItem)
-		cartRoutes.DELETE("/item/:id/", middlewares.AuthMiddleware(), controllers.RemoveCartItem)
-		cartRoutes.DELETE("/:uuid/", middlewares.AuthMiddleware(), controllers.DeleteShoppingCart)
+		cartRoutes.POST("/", middlewares.OptionalAuthMiddleware(), controllers.CreateShoppingCart)
+		cartRoutes.GET("", middlewares.OptionalAuthMiddleware(), controllers.GetShoppingCartByUserID)
+		cartRoutes.POST("/item/", middlewares.OptionalAuthMiddleware(), controllers.AddCartItem)
+		cartRoutes.PUT("/item/:id/", middlewares.OptionalAuthMiddleware(), controllers.UpdateCartItem)
+		cartRoutes.DELETE("/item/:id/", middlewares.OptionalAuthMiddleware(), controllers.RemoveCartItem)
+		cartRoutes.DELETE("/:uuid/", middlewares.OptionalAuthMiddleware(), controllers.DeleteShoppingCart)
+		cartRoutes.POST("/recover/:token/", middlewares.OptionalAuthMiddleware(), controllers.RestoreShoppingCart)
 	}
 
 	wishlistRoutes := router.Group("/api/wish-list")
@@ -23,6 +24,7 @@ func CartRoutes(router *gin.Engine) {
 		wishlistRoutes.POST("/", middlewares.AuthMiddleware(), controllers.AddWishlistItem)
 		wishlistRoutes.GET("", middlewares.AuthMiddleware(), controllers.GetWishlistByUserID)
 		wishlistRoutes.DELETE("/item/:id/", middlewares.AuthMiddleware(), controllers.RemoveWishlistItem)
+		wishlistRoutes.PUT("/item/:id/price-alert/", middlewares.AuthMiddleware(), controllers.SetWishlistPriceAlert)
 		wishlistRoutes.DELETE("/", middlewares.AuthMiddleware(), controllers.ClearWishlist)
 	}
 }
@@ -15,7 +15,10 @@ func CategoryRoutes(router *gin.Engine) {
 		categories.GET("/all", controllers.GetNestedCategories)
 		categories.GET("/sub-category/:parent_id", controllers.GetSubCategories)
 		categories.GET("/:id", controllers.GetCategory)
+		categories.PUT("/reorder/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.ReorderCategories)
+		categories.PUT("/:id/move/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.MoveCategory)
 		categories.PUT("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdateCategory)
+		categories.POST("/:id/image/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UploadCategoryImage)
 		categories.DELETE("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeleteCategory)
 	}
 }
@@ -11,6 +11,7 @@ func ContentRoutes(router *gin.Engine) {
 	content := router.Group("/api/content")
 	{
 		content.POST("/upload-banner-image/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.AddBannerImages)
+		content.POST("/banner-image/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UploadBannerImages)
 		content.GET("/banner-image", controllers.GetBannerImages)
 		content.GET("/banner-image/dashboard", controllers.GetDashboardBannerImages)
 		content.DELETE("/banner-image/:id/", controllers.DeleteBannerImage)
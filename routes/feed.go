@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"backend/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FeedRoutes(router *gin.Engine) {
+	router.GET("/sitemap.xml", controllers.GetSitemap)
+	router.GET("/sitemaps/:page", controllers.GetSitemapPage)
+
+	feeds := router.Group("/feeds")
+	{
+		feeds.GET("/google-merchant.xml", controllers.GetGoogleMerchantFeed)
+		feeds.GET("/google-merchant.csv", controllers.GetGoogleMerchantCSV)
+	}
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GiftCardRoutes(router *gin.Engine) {
+	giftCards := router.Group("/api/gift-cards")
+	{
+		giftCards.GET("/balance", middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware(10, time.Minute), controllers.CheckGiftCardBalance)
+		giftCards.GET("/mine", middlewares.AuthMiddleware(), controllers.GetMyGiftCards)
+		giftCards.POST("/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.CreateGiftCard)
+		giftCards.GET("", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetGiftCards)
+		giftCards.GET("/:id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetGiftCard)
+		giftCards.PUT("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdateGiftCard)
+		giftCards.POST("/:id/adjust/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.AdjustGiftCard)
+	}
+
+	storeCredit := router.Group("/api/store-credit")
+	{
+		storeCredit.GET("", middlewares.AuthMiddleware(), controllers.GetMyStoreCredit)
+		storeCredit.GET("/users/:user_id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetUserStoreCredit)
+		storeCredit.POST("/users/:user_id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.IssueStoreCredit)
+	}
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func LoyaltyRoutes(router *gin.Engine) {
+	loyalty := router.Group("/api/loyalty")
+	{
+		loyalty.GET("", middlewares.AuthMiddleware(), controllers.GetMyLoyalty)
+		loyalty.GET("/users/:user_id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetUserLoyalty)
+		loyalty.POST("/users/:user_id/adjust/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.AdjustLoyaltyPoints)
+		loyalty.GET("/rules", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetLoyaltyRules)
+		loyalty.POST("/rules/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.CreateLoyaltyRule)
+		loyalty.PUT("/rules/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdateLoyaltyRule)
+		loyalty.DELETE("/rules/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeleteLoyaltyRule)
+	}
+}
@@ -10,7 +10,10 @@ import (
 func OrderRoutes(router *gin.Engine) {
 	orders := router.Group("/api/orders")
 	{
-		orders.POST("/", middlewares.AuthMiddleware(), controllers.CreateOrder)
+		orders.POST("/", middlewares.OptionalAuthMiddleware(), controllers.CreateOrder)
+		orders.GET("/guest/:token", controllers.GetGuestOrder)
+		orders.POST("/guest/:token/payments/", controllers.CreateGuestPayment)
+		orders.POST("/claim/", middlewares.AuthMiddleware(), controllers.ClaimGuestOrders)
 		orders.GET("/:id", middlewares.AuthMiddleware(), controllers.GetOrderByID)
 		orders.GET("", middlewares.AuthMiddleware(), controllers.GetOrders)
 		orders.PUT("/dispatch/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DispatchOrder)
@@ -11,13 +11,35 @@ func ProductRoutes(router *gin.Engine) {
 	products := router.Group("/api/products")
 	{
 		products.GET("/search", controllers.SearchProducts)
+		products.POST("/import", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.ImportProducts)
+		products.GET("/import/:id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetProductImport)
+		products.GET("/export", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.ExportProducts)
 		products.POST("/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.CreateProduct)
 		products.GET("", controllers.GetProducts)
-		products.GET("/:id", controllers.GetSingleProduct)
+		products.GET("/:id", middlewares.OptionalAuthMiddleware(), controllers.GetSingleProduct)
+		products.GET("/compare", controllers.CompareProducts)
+		products.GET("/recently-viewed", middlewares.OptionalAuthMiddleware(), controllers.GetRecentlyViewedProducts)
+		products.GET("/for-you", middlewares.OptionalAuthMiddleware(), controllers.GetPersonalisedFeed)
 		products.GET("/new-arrival", controllers.GetNewArrivalProducts)
 		products.GET("/trending", controllers.GetTrendingProducts)
 		products.PUT("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdateProduct)
 		products.DELETE("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeleteProduct)
+		products.GET("/:id/history", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetProductHistory)
+		products.POST("/:id/history/:revision_id/restore/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.RestoreProductRevision)
+		products.GET("/:id/recommendations", controllers.GetProductRecommendations)
+		products.GET("/:id/links", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetProductLinks)
+		products.PUT("/:id/links/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.SetProductLinks)
+		products.PUT("/:id/components/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.SetBundleComponents)
+		products.POST("/:id/images/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UploadProductImages)
+		products.DELETE("/images/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeleteProductImage)
+	}
+
+	attributeDefinitions := router.Group("/api/attribute-definitions")
+	{
+		attributeDefinitions.POST("/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.CreateAttributeDefinition)
+		attributeDefinitions.GET("", controllers.GetAttributeDefinitions)
+		attributeDefinitions.PUT("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdateAttributeDefinition)
+		attributeDefinitions.DELETE("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeleteAttributeDefinition)
 	}
 
 	productAttributes := router.Group("/api/product-attributes")
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PromotionRoutes(router *gin.Engine) {
+	promotion := router.Group("/api/promotions")
+	{
+		promotion.GET("/active", controllers.GetActivePromotions)
+		promotion.POST("/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.CreatePromotion)
+		promotion.GET("", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetPromotions)
+		promotion.GET("/:id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetPromotion)
+		promotion.PUT("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.UpdatePromotion)
+		promotion.DELETE("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.DeletePromotion)
+	}
+}
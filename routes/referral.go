@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ReferralRoutes(router *gin.Engine) {
+	referrals := router.Group("/api/referrals")
+	{
+		referrals.GET("/mine", middlewares.AuthMiddleware(), controllers.GetMyReferrals)
+		referrals.GET("", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetReferrals)
+		referrals.GET("/stats", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetReferralStats)
+		referrals.GET("/users/:user_id", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.GetReferralChain)
+		referrals.PATCH("/:id/", middlewares.AuthMiddleware(), middlewares.CheckIfAdmin(), controllers.ReviewReferral)
+	}
+}
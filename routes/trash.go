@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"backend/controllers"
+	"backend/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TrashRoutes(router *gin.Engine) {
+	trashRoutes := router.Group("/api/admin-panel/trash")
+	trashRoutes.Use(middlewares.AuthMiddleware())
+	trashRoutes.Use(middlewares.CheckIfAdmin())
+	{
+		trashRoutes.GET("/:entity", controllers.GetTrash)
+		trashRoutes.POST("/:entity/:id/restore/", controllers.RestoreFromTrash)
+		trashRoutes.DELETE("/:entity/:id/", controllers.PurgeFromTrash)
+	}
+}
@@ -12,6 +12,8 @@ func UserRoutes(router *gin.Engine) {
 	{
 		userRoutes.POST("/", controllers.RegisterCustomer)
 		userRoutes.POST("/login/", controllers.LoginUser)
+		userRoutes.POST("/verify-email/", middlewares.AuthMiddleware(), controllers.RequestEmailVerification)
+		userRoutes.POST("/verify-email/confirm/", controllers.VerifyEmail)
 		userRoutes.PUT("/", middlewares.AuthMiddleware(), controllers.UpdateUser)
 		userRoutes.GET("/customer", middlewares.AuthMiddleware(), controllers.GetCustomers)
 		userRoutes.DELETE("/", middlewares.AuthMiddleware(), controllers.DeleteCustomer)
@@ -33,17 +33,30 @@ type OrderItem struct {
 	Product         Product       `gorm:"foreignKey:ProductID"`
 	Quantity        int           `gorm:"not null"`
 	PriceAtPurchase float64       `gorm:"not null"`
+	DiscountAmount  float64
+}
+
+// OrderDiscount is the part of a coupon that was taken off a line, or off the shipping without OrderItemID
+type OrderDiscount struct {
+	ID          uint `gorm:"primaryKey"`
+	OrderID     uint `json:"-"`
+	OrderItemID *uint
+	ProductID   *uint
+	Code        string
+	Description string
+	Amount      float64
 }
 
 type Payment struct {
 	ID             uint    `gorm:"primarykey"`
-	PaymentMethod  string  `gorm:"size:50;not null;check:payment_method IN ('credit_card', 'paypal', 'bank_transfer', 'cash_on_delivery')"`
-	PaymentStatus  string  `gorm:"size:50;not null;check:payment_status IN ('pending', 'completed', 'failed')"`
+	PaymentMethod  string  `gorm:"size:50;not null"`
+	PaymentStatus  string  `gorm:"size:50;not null"`
 	Amount         float64 `gorm:"not null"`
 	TransanctionID *string `gorm:"size:11;not null"`
 	PaymentDate    *time.Time
 	OrderID        uint          `gorm:"not null" json:"-"`
 	Order          OrderResponse `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"-"`
+	GiftCardID     *uint         `json:"-"`
 }
 
 type ShippingAddress struct {
@@ -61,14 +74,21 @@ type ShippingAddress struct {
 
 type OrderResponse struct {
 	gorm.Model
-	OrderIdentifier      string      `gorm:"type:varchar(8); not null;unique;index"`
-	UserID               uint        `gorm:"not null" json:"-"`
-	User                 User        `gorm:"foreignKey:UserID" json:"Buyer"`
-	OrderStatus          string      `gorm:"size:50;not null;check:order_status IN ('pending', 'shipped', 'delivered', 'cancelled')"`
-	TotalPrice           float64     `gorm:"not null"`
-	OrderItems           []OrderItem `gorm:"foreignKey:OrderID"`
-	OrderShippingAddress *string     `gorm:"type:text"`
-	PaymentDetails       *Payment    `gorm:"foreignKey:OrderID"`
+	OrderIdentifier      string `gorm:"type:varchar(8); not null;unique;index"`
+	UserID               *uint  `json:"-"`
+	User                 *User  `gorm:"foreignKey:UserID" json:"Buyer"` // Nil for guest orders
+	GuestEmail           *string
+	GuestPhone           *string
+	OrderStatus          string  `gorm:"size:50;not null;check:order_status IN ('pending', 'shipped', 'delivered', 'cancelled')"`
+	TotalPrice           float64 `gorm:"not null"`
+	ItemPrice            float64
+	DiscountAmount       float64
+	ShippingCost         float64
+	OrderItems           []OrderItem     `gorm:"foreignKey:OrderID"`
+	Discounts            []OrderDiscount `gorm:"foreignKey:OrderID"`
+	OrderShippingAddress *string         `gorm:"type:text"`
+	PaymentDetails       *Payment        `gorm:"foreignKey:OrderID"` // Payment of the payment method, without gift cards and store credit
+	Payments             []Payment       `gorm:"foreignKey:OrderID"` // Every tender of the order
 }
 
 type ReviewResponse struct {
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a MinIO-style stand-in that keeps the objects of one bucket in memory. It answers the
+// object requests the S3 driver makes and does not check signatures.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+// newFakeS3 starts a fake S3 server and returns a store connected to it
+func newFakeS3(t *testing.T, bucket string) (*fakeS3, *S3Storage) {
+	t.Helper()
+	fake := &fakeS3{bucket: bucket, objects: map[string]fakeObject{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewS3Storage(S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "test",
+		SecretKey: "testsecret",
+		Bucket:    bucket,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket || key == "" {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeChunks(data)
+		}
+		if err != nil {
+			f.fail(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(object.data))
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(object.data)))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+// object returns the stored data of a key
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[key]
+	return object.data, ok
+}
+
+// decodeChunks strips the chunk headers of a body uploaded with a streaming signature, which the
+// driver uses over plain HTTP
+func decodeChunks(body []byte) ([]byte, error) {
+	var data []byte
+	for {
+		header, rest, found := bytes.Cut(body, []byte("\r\n"))
+		if !found {
+			return nil, errors.New("unterminated chunk header")
+		}
+		size, _, _ := strings.Cut(string(header), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil || int64(len(rest)) < n+2 {
+			return nil, errors.New("malformed chunk")
+		}
+		if n == 0 {
+			return data, nil
+		}
+		data = append(data, rest[:n]...)
+		body = rest[n+2:]
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
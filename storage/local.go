@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps blobs on the local filesystem, served by the API under a public prefix
+type LocalStorage struct {
+	Root      string
+	PublicURL string
+}
+
+func NewLocalStorage(root string, publicURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Root: root, PublicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	target := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.PublicURL + "/" + key
+}
+
+// path resolves a key inside the root directory, stripping any attempt to escape it
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	PublicURL string // Optional CDN or bucket URL, defaults to the endpoint
+}
+
+// S3Storage keeps blobs in an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+type S3Storage struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 storage driver")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		scheme := "http://"
+		if cfg.UseSSL {
+			scheme = "https://"
+		}
+		publicURL = scheme + cfg.Endpoint + "/" + cfg.Bucket
+	}
+
+	return &S3Storage{client: client, bucket: cfg.Bucket, publicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) URL(key string) string {
+	return s.publicURL + "/" + key
+}
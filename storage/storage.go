@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/google/uuid"
+)
+
+// Storage is a blob store for uploaded files such as product, category and banner images.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+var Default Storage
+
+var ErrNotConfigured = errors.New("storage is not configured")
+
+// Init configures the default store from the STORAGE_DRIVER environment variable ("local" or "s3")
+func Init() error {
+	driver := os.Getenv("STORAGE_DRIVER")
+
+	switch driver {
+	case "", "local":
+		store, err := NewLocalStorage(getEnv("STORAGE_LOCAL_DIR", "./uploads"), getEnv("STORAGE_PUBLIC_URL", "/uploads"))
+		if err != nil {
+			return err
+		}
+		Default = store
+	case "s3":
+		store, err := NewS3Storage(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+			PublicURL: os.Getenv("STORAGE_PUBLIC_URL"),
+		})
+		if err != nil {
+			return err
+		}
+		Default = store
+	default:
+		return fmt.Errorf("unknown storage driver %q", driver)
+	}
+
+	log.Printf("Storage initialised with %q driver", getEnv("STORAGE_DRIVER", "local"))
+	return nil
+}
+
+// URL returns the public URL of a key in the default store
+func URL(key string) string {
+	if key == "" || Default == nil {
+		return ""
+	}
+	return Default.URL(key)
+}
+
+// SaveBytes stores data under prefix with a generated name and returns its key
+func SaveBytes(ctx context.Context, prefix string, data []byte) (string, error) {
+	if Default == nil {
+		return "", ErrNotConfigured
+	}
+
+	contentType := http.DetectContentType(data)
+	key := path.Join(prefix, uuid.NewString()+extensionFor(contentType))
+
+	if err := Default.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// Remove deletes a key from the default store, ignoring empty keys
+func Remove(ctx context.Context, key string) error {
+	if key == "" || Default == nil {
+		return nil
+	}
+	return Default.Delete(ctx, key)
+}
+
+func extensionFor(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s3 := newFakeS3(t, "media")
+
+	tests := []struct {
+		name  string
+		store Storage
+		url   string
+	}{
+		{"local", local, "/uploads/products/1.png"},
+		{"s3", s3, s3.publicURL + "/products/1.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			data := []byte("image data")
+			if err := tt.store.Put(ctx, "products/1.png", bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			r, err := tt.store.Get(ctx, "products/1.png")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Get returned %q, %v, want %q", got, err, data)
+			}
+
+			if url := tt.store.URL("products/1.png"); url != tt.url {
+				t.Errorf("URL = %q, want %q", url, tt.url)
+			}
+
+			if err := tt.store.Delete(ctx, "products/1.png"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if r, err := tt.store.Get(ctx, "products/1.png"); err == nil {
+				_, err = io.ReadAll(r)
+				r.Close()
+				if err == nil {
+					t.Error("Get after Delete succeeded")
+				}
+			}
+			if err := tt.store.Delete(ctx, "products/1.png"); err != nil {
+				t.Errorf("deleting a missing key: %v", err)
+			}
+		})
+	}
+}
+
+func TestLocalStorageStaysInRoot(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStorage(filepath.Join(root, "uploads"), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"products/a.png", "uploads/products/a.png"},
+		{"../a.png", "uploads/a.png"},
+		{"products/../../../a.png", "uploads/a.png"},
+		{"/etc/passwd", "uploads/etc/passwd"},
+	}
+	for _, tt := range tests {
+		if got := store.path(tt.key); got != filepath.Join(root, tt.want) {
+			t.Errorf("path(%q) = %q, want %q", tt.key, got, filepath.Join(root, tt.want))
+		}
+	}
+}
+
+func TestSaveBytes(t *testing.T) {
+	fake, store := newFakeS3(t, "media")
+	previous := Default
+	Default = store
+	t.Cleanup(func() { Default = previous })
+
+	tests := []struct {
+		name string
+		data []byte
+		ext  string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), ".png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), ".jpg"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), ".gif"},
+		{"unknown", []byte{0x00, 0x01, 0x02}, ""}, // Extension from the system MIME table, if any
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := SaveBytes(context.Background(), "products", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(key, "products/") || (tt.ext != "" && filepath.Ext(key) != tt.ext) {
+				t.Errorf("key %q, want products/<name>%s", key, tt.ext)
+			}
+			if stored, ok := fake.object(key); !ok || !bytes.Equal(stored, tt.data) {
+				t.Errorf("stored %q, want %q", stored, tt.data)
+			}
+
+			if err := Remove(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := fake.object(key); ok {
+				t.Error("Remove left the object in the store")
+			}
+		})
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	previous := Default
+	Default = nil
+	t.Cleanup(func() { Default = previous })
+
+	if _, err := SaveBytes(context.Background(), "products", []byte("data")); err != ErrNotConfigured {
+		t.Errorf("SaveBytes = %v, want ErrNotConfigured", err)
+	}
+	if err := Remove(context.Background(), "products/a.png"); err != nil {
+		t.Errorf("Remove = %v, want nil", err)
+	}
+	if url := URL("products/a.png"); url != "" {
+		t.Errorf("URL = %q, want empty", url)
+	}
+}
+
+func TestLocalStorageRemovesPartialFiles(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "a.png", failingReader{}, 10, "image/png"); err == nil {
+		t.Fatal("Put succeeded with a failing reader")
+	}
+	if _, err := os.Stat(store.path("a.png")); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
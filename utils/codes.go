@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// codeAlphabet leaves out characters that are easily mistaken for each other, such as 0/O and 1/I
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const codeDigits = "23456789"
+
+var ErrInvalidCodePattern = errors.New("pattern needs at least 4 X or # placeholders and at most 40 characters")
+
+// CodePatternSpace returns how many distinct codes a pattern can produce, capped at max
+func CodePatternSpace(pattern string, max int64) int64 {
+	space := int64(1)
+	for _, r := range pattern {
+		switch r {
+		case 'X':
+			space *= int64(len(codeAlphabet))
+		case '#':
+			space *= int64(len(codeDigits))
+		}
+		if space >= max {
+			return max
+		}
+	}
+	return space
+}
+
+// ValidateCodePattern checks a pattern where X is a random letter or digit, # a random digit and
+// anything else is kept as is
+func ValidateCodePattern(pattern string) error {
+	placeholders := strings.Count(pattern, "X") + strings.Count(pattern, "#")
+	if placeholders < 4 || len(pattern) > 40 {
+		return ErrInvalidCodePattern
+	}
+	return nil
+}
+
+// GenerateCode fills the placeholders of a pattern with cryptographically random characters
+func GenerateCode(prefix string, pattern string) (string, error) {
+	var code strings.Builder
+	code.WriteString(prefix)
+	for _, r := range pattern {
+		alphabet := ""
+		switch r {
+		case 'X':
+			alphabet = codeAlphabet
+		case '#':
+			alphabet = codeDigits
+		default:
+			code.WriteRune(r)
+			continue
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(alphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
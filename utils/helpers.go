@@ -3,6 +3,8 @@ package utils
 import (
 	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"math/rand"
@@ -31,12 +33,13 @@ func ProductQueryParameterToMap(P Parameters) string {
 		}
 	}
 
-	if P.CategoryID != "" {
+	if ids := parseIDList(P.CategoryID); ids != "" {
+		// Filtering by a category includes the products of its subcategories
 		if querystring != "" {
-			querystring = querystring + " AND category_id IN (" + P.CategoryID + ")"
+			querystring = querystring + " AND products.category_id IN " + CategoryDescendantsSQL(ids)
 
 		} else {
-			querystring = "category_id IN (" + P.CategoryID + ")"
+			querystring = "products.category_id IN " + CategoryDescendantsSQL(ids)
 		}
 	}
 	if P.BrandID != "" {
@@ -56,36 +59,44 @@ func ProductQueryParameterToMap(P Parameters) string {
 		}
 	}
 	if P.Status != "" {
+		statusQuery := "products.status = '" + P.Status + "'"
+		if P.Status == "published" {
+			statusQuery = PublishedSQL("products")
+		}
+
 		if querystring != "" {
-			querystring = querystring + " AND products.status = '" + P.Status + "'"
+			querystring = querystring + " AND " + statusQuery
 
 		} else {
-			querystring = "products.status = '" + P.Status + "'"
+			querystring = statusQuery
 		}
 	}
 
+	// Price filters compare against the current price of the cheapest variation, joined as "p"
+	price := "COALESCE(p.price, " + EffectivePriceSQL("products") + ")"
+
 	if P.StartPrice != nil && P.EndPrice != nil {
 		if querystring != "" {
-			querystring = querystring + " AND " + fmt.Sprintf("p.price >= %d AND p.price <= %d", *P.StartPrice, *P.EndPrice)
+			querystring = querystring + " AND " + fmt.Sprintf("%s >= %d AND %s <= %d", price, *P.StartPrice, price, *P.EndPrice)
 
 		} else {
-			querystring = fmt.Sprintf("p.price >= %d AND p.price <= %d", *P.StartPrice, *P.EndPrice)
+			querystring = fmt.Sprintf("%s >= %d AND %s <= %d", price, *P.StartPrice, price, *P.EndPrice)
 		}
 
 	} else if P.StartPrice != nil && P.EndPrice == nil {
 		if querystring != "" {
-			querystring = querystring + " AND " + fmt.Sprintf("p.price >= %d", *P.StartPrice)
+			querystring = querystring + " AND " + fmt.Sprintf("%s >= %d", price, *P.StartPrice)
 
 		} else {
-			querystring = fmt.Sprintf("p.price >= %d", *P.StartPrice)
+			querystring = fmt.Sprintf("%s >= %d", price, *P.StartPrice)
 		}
 
 	} else if P.StartPrice == nil && P.EndPrice != nil {
 		if querystring != "" {
-			querystring = querystring + " AND " + fmt.Sprintf("p.price <= %d", *P.EndPrice)
+			querystring = querystring + " AND " + fmt.Sprintf("%s <= %d", price, *P.EndPrice)
 
 		} else {
-			querystring = fmt.Sprintf("p.price <= %d", *P.EndPrice)
+			querystring = fmt.Sprintf("%s <= %d", price, *P.EndPrice)
 		}
 	}
 	return querystring
@@ -134,3 +145,24 @@ func EncodeImageToBase64(imageBytes []byte) string {
 	encodedString := base64.StdEncoding.EncodeToString(imageBytes)
 	return encodedString
 }
+
+// CategoryDescendantsSQL selects the IDs of the given categories and every category below them
+func CategoryDescendantsSQL(ids string) string {
+	return `(WITH RECURSIVE descendants AS (
+				SELECT id FROM categories WHERE id IN (` + ids + `) AND deleted_at IS NULL
+				UNION
+				SELECT categories.id FROM categories JOIN descendants ON categories.parent_id = descendants.id
+				WHERE categories.deleted_at IS NULL
+			) SELECT id FROM descendants)`
+}
+
+// parseIDList keeps the numeric IDs of a comma separated list, so it can be used in SQL as is
+func parseIDList(list string) string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if _, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64); err == nil {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+	}
+	return strings.Join(ids, ",")
+}
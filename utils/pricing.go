@@ -0,0 +1,64 @@
+package utils
+
+import "strings"
+
+// PublishedSQL is the condition for a product that is visible in the storefront right now:
+// published and inside its publish_at/unpublish_at window
+func PublishedSQL(table string) string {
+	return strings.NewReplacer("{t}", table).Replace(
+		"{t}.status = 'published' AND ({t}.publish_at IS NULL OR {t}.publish_at <= NOW()) AND ({t}.unpublish_at IS NULL OR {t}.unpublish_at > NOW())")
+}
+
+// SaleActiveSQL is the condition for a product whose sale price applies right now
+func SaleActiveSQL(table string) string {
+	return strings.NewReplacer("{t}", table).Replace(
+		"{t}.sale_price IS NOT NULL AND ({t}.sale_starts_at IS NULL OR {t}.sale_starts_at <= NOW()) AND ({t}.sale_ends_at IS NULL OR {t}.sale_ends_at > NOW())")
+}
+
+// EffectivePriceSQL is the price a customer pays for a product right now
+func EffectivePriceSQL(table string) string {
+	return "CASE WHEN " + SaleActiveSQL(table) + " THEN " + table + ".sale_price ELSE " + table + ".price END"
+}
+
+// SalePricingSelect selects price, compare_at_price, on_sale and discount_percentage
+// from the expressions of the current and the regular price
+func SalePricingSelect(priceExpr string, regularExpr string) string {
+	return strings.NewReplacer("{p}", "("+priceExpr+")", "{r}", "("+regularExpr+")").Replace(`{p} AS price,
+				{r} AS compare_at_price,
+				COALESCE({p} < {r}, false) AS on_sale,
+				CASE WHEN {r} > 0 AND {p} < {r} THEN ROUND(({r} - {p}) / {r} * 100) ELSE 0 END AS discount_percentage`)
+}
+
+// VariationPricingJoin joins, as "p", the cheapest variation of each parent product at its current price
+func VariationPricingJoin() string {
+	return `LEFT JOIN (
+				SELECT DISTINCT ON (parent_id) parent_id, ` + EffectivePriceSQL("products") + ` AS price, price AS regular_price
+				FROM products
+				WHERE is_child = true AND deleted_at IS NULL
+				ORDER BY parent_id, ` + EffectivePriceSQL("products") + `
+			) p ON p.parent_id = products.id`
+}
+
+// ListingPricingSelect selects the sale pricing of a listed product, using its cheapest variation when it has any
+func ListingPricingSelect() string {
+	return SalePricingSelect("COALESCE(p.price, "+EffectivePriceSQL("products")+")", "COALESCE(p.regular_price, products.price)")
+}
+
+// inventorySQL is the stock a product can still sell from its own inventory, or nil when it has no inventory record
+func inventorySQL(productIDExpr string) string {
+	return "(SELECT SUM(stock_level - in_open) FROM inventories WHERE product_id = " + productIDExpr + " AND deleted_at IS NULL)"
+}
+
+// AvailableStockSQL is the quantity of a product that can be ordered right now. Variations without an
+// inventory record of their own share their parent's, and bundles are limited by their scarcest component.
+func AvailableStockSQL(table string) string {
+	component := "COALESCE(" + inventorySQL("components.id") + ", " + inventorySQL("components.parent_id") + ", 0)"
+
+	return `CASE WHEN ` + table + `.product_type = 'bundle' THEN COALESCE((
+					SELECT MIN(FLOOR(GREATEST(` + component + `, 0) / bundle_components.quantity))
+					FROM bundle_components
+					JOIN products AS components ON components.id = bundle_components.component_id AND components.deleted_at IS NULL
+					WHERE bundle_components.bundle_id = ` + table + `.id
+				), 0)
+				ELSE COALESCE(` + inventorySQL(table+".id") + `, ` + inventorySQL(table+".parent_id") + `, 0) END`
+}
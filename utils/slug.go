@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Slugify turns a name into a lowercase, hyphen separated URL segment, e.g. "Crème Brûlée Box" becomes "creme-brulee-box"
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop accents left over from the decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			hyphen = false
+		case !hyphen && b.Len() > 0:
+			b.WriteRune('-')
+			hyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 180 {
+		slug = strings.TrimSuffix(slug[:180], "-")
+	}
+	return slug
+}
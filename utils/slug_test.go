@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Crème Brûlée Box", "creme-brulee-box"},
+		{"Gift Box", "gift-box"},
+		{"  Gift   Box  ", "gift-box"},
+		{"Gift-Box!", "gift-box"},
+		{"--Gift--Box--", "gift-box"},
+		{"Box #3, 250g", "box-3-250g"},
+		{"Café & Thé", "cafe-the"},
+		{"ÉCLAIRS", "eclairs"},
+		{"Straße", "stra-e"},
+		{"日本茶", ""},
+		{"", ""},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := Slugify(tt.name); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlugifyLength(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{strings.Repeat("a", 200), strings.Repeat("a", 180)},
+		// A cut right after a separator does not leave a trailing hyphen
+		{strings.Repeat("a", 179) + " " + strings.Repeat("b", 20), strings.Repeat("a", 179)},
+	}
+	for _, tt := range tests {
+		if got := Slugify(tt.name); got != tt.want {
+			t.Errorf("Slugify(%d characters) = %q, want %q", len(tt.name), got, tt.want)
+		}
+	}
+}
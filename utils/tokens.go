@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignToken makes a URL safe token carrying value that cannot be forged. The purpose is part of the
+// signature, so a token made for one purpose is never accepted for another.
+func SignToken(purpose string, value string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return encoded + "." + tokenSignature(purpose, encoded)
+}
+
+// VerifyToken returns the value of a token made by SignToken for the purpose
+func VerifyToken(purpose string, token string) (string, bool) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(tokenSignature(purpose, encoded))) {
+		return "", false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
+
+func tokenSignature(purpose string, encoded string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(purpose + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignExpiringToken is SignToken for a token that VerifyExpiringToken stops accepting at expires
+func SignExpiringToken(purpose string, value string, expires time.Time) string {
+	return SignToken(purpose, strconv.FormatInt(expires.Unix(), 10)+":"+value)
+}
+
+// VerifyExpiringToken returns the value of a token made by SignExpiringToken for the purpose when it has
+// not expired at the given time
+func VerifyExpiringToken(purpose string, token string, at time.Time) (string, bool) {
+	signed, ok := VerifyToken(purpose, token)
+	if !ok {
+		return "", false
+	}
+	expires, value, found := strings.Cut(signed, ":")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if !found || err != nil || !at.Before(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return value, true
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	token := SignToken("order-lookup", "42")
+	encoded, signature, _ := strings.Cut(token, ".")
+	other := SignToken("order-lookup", "43")
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		want    string
+		ok      bool
+	}{
+		{"valid", "order-lookup", token, "42", true},
+		{"other purpose", "cart-recovery", token, "", false},
+		{"tampered value", "order-lookup", strings.SplitN(other, ".", 2)[0] + "." + signature, "", false},
+		{"tampered signature", "order-lookup", encoded + ".x" + signature, "", false},
+		{"no signature", "order-lookup", encoded, "", false},
+		{"empty", "order-lookup", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := VerifyToken(tt.purpose, tt.token)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: VerifyToken = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestVerifyExpiringToken(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	token := SignExpiringToken("order-lookup", "42", now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		at      time.Time
+		want    string
+		ok      bool
+	}{
+		{"before expiry", "order-lookup", token, now, "42", true},
+		{"just before expiry", "order-lookup", token, now.Add(time.Hour - time.Second), "42", true},
+		{"at expiry", "order-lookup", token, now.Add(time.Hour), "", false},
+		{"after expiry", "order-lookup", token, now.Add(2 * time.Hour), "", false},
+		{"other purpose", "cart-recovery", token, now, "", false},
+		{"token without expiry", "order-lookup", SignToken("order-lookup", "42"), now, "", false},
+		{"value with colons", "order-lookup", SignExpiringToken("order-lookup", "a:b", now.Add(time.Hour)), now, "a:b", true},
+	}
+	for _, tt := range tests {
+		got, ok := VerifyExpiringToken(tt.purpose, tt.token, tt.at)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: VerifyExpiringToken = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}